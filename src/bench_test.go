@@ -0,0 +1,57 @@
+package vile
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkScript - time the scripts in the bench directory. The forms of a script define what it needs, up to the
+// last one, which puts the result of the run: that run is what is timed, with and without the optimizer. Save the
+// output of go test -bench for two commits, and compare them with benchstat.
+func benchmarkScript(b *testing.B, name string) {
+	for _, optimize := range []bool{false, true} {
+		mode := "plain"
+		if optimize {
+			mode = "optimized"
+		}
+		b.Run(mode, func(b *testing.B) {
+			rt := newTestRuntime(b)
+			rt.SetFlags(optimize, false, false, false, false)
+			text, err := SlurpFile(filepath.Join("bench", name+".vl"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			forms, err := ReadAll(text, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for ; Cdr(forms) != EmptyList; forms = Cdr(forms) {
+				if _, err := rt.Eval(Car(forms)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			run, err := rt.Eval(List(Intern("func"), EmptyList, Cadr(Car(forms))))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := rt.Call(run); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkScript(b, "fib")
+}
+
+func BenchmarkLists(b *testing.B) {
+	benchmarkScript(b, "lists")
+}
+
+func BenchmarkStrings(b *testing.B) {
+	benchmarkScript(b, "strings")
+}
@@ -0,0 +1,320 @@
+package vile
+
+import (
+	"reflect"
+	"time"
+)
+
+// ChannelType is the type of all channels
+var ChannelType = Intern("<channel>")
+
+// TaskType is the type of the handles returned by spawn
+var TaskType = Intern("<task>")
+
+type channel struct {
+	ch      chan *Object
+	bufsize int
+}
+
+// Channel - create a new <channel> object with the specified buffer size
+func Channel(bufsize int) *Object {
+	return NewObject(ChannelType, &channel{make(chan *Object, bufsize), bufsize})
+}
+
+func IsChannel(obj *Object) bool {
+	return obj.Type == ChannelType
+}
+
+func theChannel(obj *Object) (*channel, error) {
+	if c, ok := obj.Value.(*channel); ok {
+		return c, nil
+	}
+	return nil, Error(ArgumentErrorKey, "Expected a <channel>, got a ", obj.Type)
+}
+
+// seconds - convert a timeout, a <number> of seconds, to a duration
+func seconds(obj *Object) (time.Duration, error) {
+	if !IsNumber(obj) {
+		return 0, Error(ArgumentErrorKey, "timeout must be a <number> of seconds, got a ", obj.Type)
+	}
+	return time.Duration(obj.fval * float64(time.Second)), nil
+}
+
+// the statuses of a receive, see ChannelReceive
+var (
+	ReceivedKey = Intern("ok:")
+	ClosedKey   = Intern("closed:")
+	TimeoutKey  = Intern("timeout:")
+)
+
+// ChannelSend - send the value on the channel, in the default runtime. See Runtime.ChannelSend.
+func ChannelSend(obj *Object, val *Object, timeout *Object) (*Object, error) {
+	return defaultRuntime.ChannelSend(obj, val, timeout)
+}
+
+// ChannelSend - send the value on the channel. If timeout is a <number>, give up after that many seconds and
+// return false. Sending on a closed channel is an error. Like all the operations that wait on channels and tasks,
+// it is stopped by the context of a runtime view with limits, with a limit-error:.
+func (rt *Runtime) ChannelSend(obj *Object, val *Object, timeout *Object) (result *Object, err error) {
+	c, err := theChannel(obj)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if recover() != nil {
+			result, err = nil, Error(ChannelErrorKey, "send on closed channel")
+		}
+	}()
+	var expired <-chan time.Time // never ready without a timeout
+	if timeout != Null {
+		d, err := seconds(timeout)
+		if err != nil {
+			return nil, err
+		}
+		expired = time.After(d)
+	}
+	select {
+	case c.ch <- val:
+		return True, nil
+	case <-expired:
+		return False, nil
+	case <-rt.done():
+		return nil, rt.stopped()
+	}
+}
+
+// ChannelReceive - receive a value from the channel, in the default runtime. See Runtime.ChannelReceive.
+func ChannelReceive(obj *Object, timeout *Object) (*Object, error) {
+	return defaultRuntime.ChannelReceive(obj, timeout)
+}
+
+// ChannelReceive - receive a value from the channel. If timeout is a <number>, give up after that many seconds.
+// The result is a list of the value and a status: ok: if the value was received, closed: once the channel is
+// closed and drained, or timeout: if the timeout expired. The value is null unless it was received.
+func (rt *Runtime) ChannelReceive(obj *Object, timeout *Object) (*Object, error) {
+	c, err := theChannel(obj)
+	if err != nil {
+		return nil, err
+	}
+	var expired <-chan time.Time // never ready without a timeout
+	if timeout != Null {
+		d, err := seconds(timeout)
+		if err != nil {
+			return nil, err
+		}
+		expired = time.After(d)
+	}
+	select {
+	case val, ok := <-c.ch:
+		if !ok {
+			return List(Null, ClosedKey), nil
+		}
+		return List(val, ReceivedKey), nil
+	case <-expired:
+		return List(Null, TimeoutKey), nil
+	case <-rt.done():
+		return nil, rt.stopped()
+	}
+}
+
+// ChannelClose - close the channel. Closing a closed channel is an error.
+func ChannelClose(obj *Object) (err error) {
+	c, err := theChannel(obj)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if recover() != nil {
+			err = Error(ChannelErrorKey, "close of closed channel")
+		}
+	}()
+	close(c.ch)
+	return nil
+}
+
+// ChannelSelect - wait for the first of the cases to be ready, in the default runtime. See Runtime.ChannelSelect.
+func ChannelSelect(cases *Object, timeout *Object) (*Object, error) {
+	return defaultRuntime.ChannelSelect(cases, timeout)
+}
+
+// ChannelSelect - wait for the first of the cases to be ready. Each case is either a <channel> to receive from,
+// or a [channel value] vector to send on. If timeout is a <number>, give up after that many seconds; a timeout
+// of 0 does not wait at all. The result is a list of the index of the case and the received value (null for sends).
+// The index is -1 if the timeout expired.
+func (rt *Runtime) ChannelSelect(cases *Object, timeout *Object) (*Object, error) {
+	var selectCases []reflect.SelectCase
+	for _, c := range cases.elements {
+		if IsVector(c) {
+			if len(c.elements) != 2 {
+				return nil, Error(ArgumentErrorKey, "select send case must be a [channel value] vector: ", c)
+			}
+			ch, err := theChannel(c.elements[0])
+			if err != nil {
+				return nil, err
+			}
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.ch), Send: reflect.ValueOf(c.elements[1])})
+		} else {
+			ch, err := theChannel(c)
+			if err != nil {
+				return nil, err
+			}
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.ch)})
+		}
+	}
+	count := len(selectCases)
+	if timeout != Null {
+		d, err := seconds(timeout)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		} else {
+			selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(time.After(d))})
+		}
+	}
+	stop := -1
+	if done := rt.done(); done != nil {
+		stop = len(selectCases)
+		selectCases = append(selectCases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	idx, val, ok, err := channelSelect(selectCases)
+	if err != nil {
+		return nil, err
+	}
+	if idx == stop {
+		return nil, rt.stopped()
+	}
+	if idx >= count {
+		return List(MinusOne, Null), nil
+	}
+	if selectCases[idx].Dir == reflect.SelectSend || !ok {
+		return List(Number(float64(idx)), Null), nil
+	}
+	return List(Number(float64(idx)), val.Interface().(*Object)), nil
+}
+
+func channelSelect(cases []reflect.SelectCase) (idx int, val reflect.Value, ok bool, err error) {
+	defer func() {
+		if recover() != nil {
+			err = Error(ChannelErrorKey, "send on closed channel")
+		}
+	}()
+	idx, val, ok = reflect.Select(cases)
+	return idx, val, ok, nil
+}
+
+type task struct {
+	done   chan struct{}
+	result *Object
+	err    error
+}
+
+func newTask() *Object {
+	return NewObject(TaskType, &task{done: make(chan struct{})})
+}
+
+func (t *task) finish(result *Object, err error) {
+	t.result = result
+	t.err = err
+	close(t.done)
+}
+
+// Await - wait for the spawned function to finish, in the default runtime. See Runtime.Await.
+func Await(obj *Object, timeout *Object) (*Object, error) {
+	return defaultRuntime.Await(obj, timeout)
+}
+
+// Await - wait for the spawned function to finish, returning its result, or raising its error. If timeout is a
+// <number>, give up after that many seconds and return null.
+func (rt *Runtime) Await(obj *Object, timeout *Object) (*Object, error) {
+	t, ok := obj.Value.(*task)
+	if !ok {
+		return nil, Error(ArgumentErrorKey, "await expected a <task>, got a ", obj.Type)
+	}
+	var expired <-chan time.Time // never ready without a timeout
+	if timeout != Null {
+		d, err := seconds(timeout)
+		if err != nil {
+			return nil, err
+		}
+		expired = time.After(d)
+	}
+	select {
+	case <-t.done:
+	case <-expired:
+		return Null, nil
+	case <-rt.done():
+		return nil, rt.stopped()
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	return t.result, nil
+}
+
+// ChannelErrorKey - for errors sending to or closing a closed channel
+var ChannelErrorKey = Intern("channel-error:")
+
+// expandSelect - expand (select (recv ch (v) body...) (send ch val body...) (timeout secs body...) (default body...))
+// into a call of the select-channels primitive, dispatching on the index of the case that was ready.
+// The parameters of the handler are gensyms, and the functions it calls are global references, so neither can be
+// captured by the bodies or by the locals around the select.
+func expandSelect(expr *Object) (*Object, error) {
+	index := Gensym("select-index")
+	value := Gensym("select-value")
+	var cases []*Object
+	var bodies []*Object
+	timeout := Null
+	timeoutBody := EmptyList
+	for clauses := Cdr(expr); clauses != EmptyList; clauses = Cdr(clauses) {
+		clause := Car(clauses)
+		if !IsList(clause) || clause == EmptyList {
+			return nil, Error(SyntaxErrorKey, expr)
+		}
+		switch Car(clause) {
+		case Intern("recv"):
+			args := Caddr(clause)
+			if ListLength(clause) < 3 || !IsList(args) || ListLength(args) != 1 {
+				return nil, Error(SyntaxErrorKey, clause)
+			}
+			cases = append(cases, Cadr(clause))
+			bodies = append(bodies, List(Cons(Intern("func"), Cons(args, selectBody(Cdddr(clause)))), value))
+		case Intern("send"):
+			if ListLength(clause) < 3 {
+				return nil, Error(SyntaxErrorKey, clause)
+			}
+			cases = append(cases, Vector(Cadr(clause), Caddr(clause)))
+			bodies = append(bodies, Cons(Intern("do"), selectBody(Cdddr(clause))))
+		case Intern("timeout"), Intern("default"):
+			if timeout != Null {
+				return nil, Error(SyntaxErrorKey, expr)
+			}
+			if Car(clause) == Intern("timeout") {
+				if ListLength(clause) < 2 {
+					return nil, Error(SyntaxErrorKey, clause)
+				}
+				timeout = Cadr(clause)
+				timeoutBody = Cddr(clause)
+			} else {
+				timeout = Zero
+				timeoutBody = Cdr(clause)
+			}
+		default:
+			return nil, Error(SyntaxErrorKey, clause)
+		}
+	}
+	dispatch := Cons(Intern("do"), selectBody(timeoutBody))
+	for i := len(bodies) - 1; i >= 0; i-- {
+		dispatch = List(Intern("if"), List(globalReference(Intern("=")), index, Number(float64(i))), bodies[i], dispatch)
+	}
+	handler := List(Intern("func"), List(index, value), dispatch)
+	return List(globalReference(Intern("apply")), handler, List(globalReference(Intern("select-channels")), Vector(cases...), timeout)), nil
+}
+
+func selectBody(body *Object) *Object {
+	if body == EmptyList {
+		return List(Null)
+	}
+	return body
+}
@@ -25,8 +25,13 @@ const ( /* Vile operation code or opcode */
 	opcodeVector         // 14
 	opcodeStruct         // 15
 	opcodeUndefGlobal    // 16
-	opcodeCount          // 17
-) /* Vile have 18 opcodes */
+	opcodePushHandler    // 17
+	opcodePopHandler     // 18
+	opcodeThrow          // 19
+	opcodeGlobalCall     // 20, global then call, made by the optimizer
+	opcodeLocalJumpFalse // 21, local then jumpfalse, made by the optimizer
+	opcodeCount          // 22
+) /* Vile have 22 opcodes */
 
 var LiteralSymbol = Intern("literal")
 var LocalSymbol = Intern("local")
@@ -45,6 +50,11 @@ var DefmacroSymbol = Intern("macro")
 var VectorSymbol = Intern("vector")
 var StructSymbol = Intern("struct")
 var UndefineSymbol = Intern("undefine")
+var PushhandlerSymbol = Intern("pushhandler")
+var PophandlerSymbol = Intern("pophandler")
+var ThrowSymbol = Intern("throw")
+var GlobalcallSymbol = Intern("globalcall")
+var LocaljumpfalseSymbol = Intern("localjumpfalse")
 var FuncSymbol = Intern("func")
 
 var opsyms = initOpsyms()
@@ -68,6 +78,11 @@ func initOpsyms() []*Object {
 	syms[opcodeVector] = VectorSymbol
 	syms[opcodeStruct] = StructSymbol
 	syms[opcodeUndefGlobal] = UndefineSymbol
+	syms[opcodePushHandler] = PushhandlerSymbol
+	syms[opcodePopHandler] = PophandlerSymbol
+	syms[opcodeThrow] = ThrowSymbol
+	syms[opcodeGlobalCall] = GlobalcallSymbol
+	syms[opcodeLocalJumpFalse] = LocaljumpfalseSymbol
 	return syms
 }
 
@@ -78,10 +93,19 @@ type Code struct {
 	argc     int
 	defaults []*Object // defaults => []*Object
 	keys     []*Object // keys     => []*Object
+	rt       *Runtime  // the runtime whose constants the ops refer to
+
+	locations []codeLocation // where the instructions came from, in pc order
+	location  *location      // the location being compiled
+	defined   *location      // where the function was defined, see definition
 }
 
-// MakeCode - create a new code object
+// MakeCode - create a new code object in the default runtime
 func MakeCode(argc int, defaults []*Object, keys []*Object, name string) *Object {
+	return defaultRuntime.makeCode(argc, defaults, keys, name)
+}
+
+func (rt *Runtime) makeCode(argc int, defaults []*Object, keys []*Object, name string) *Object {
 	var ops []int
 	code := &Code{
 		name,
@@ -89,6 +113,10 @@ func MakeCode(argc int, defaults []*Object, keys []*Object, name string) *Object
 		argc,
 		defaults, // nil for normal procs, empty for rest, and non-empty for optional/keyword
 		keys,
+		rt,
+		nil,
+		nil,
+		nil,
 	}
 	result := new(Object)
 	result.Type = CodeType // CodeType is the type of compiled code
@@ -98,7 +126,7 @@ func MakeCode(argc int, defaults []*Object, keys []*Object, name string) *Object
 
 func (code *Code) signature() string {
 	if code.name != "" {
-		val := GetGlobal(Intern("*declarations*"))
+		val := code.rt.GetGlobal(Intern("*declarations*"))
 		if val != nil && IsStruct(val) {
 			sig, _ := Get(val, Intern(code.name))
 			if sig != Null {
@@ -157,11 +185,17 @@ func (code *Code) decompileInto(buf *bytes.Buffer, indent string, pretty bool) {
 		op := code.ops[offset]
 		s := prefix + "(" + opsyms[op].text
 		switch op {
-		case opcodePop, opcodeReturn:
+		case opcodePop, opcodeReturn, opcodePopHandler, opcodeThrow:
 			buf.WriteString(s + ")")
 			offset++
-		case opcodeLiteral, opcodeDefGlobal, opcodeImport, opcodeGlobal, opcodeUndefGlobal, opcodeDefMacro:
-			buf.WriteString(s + " " + Write(constants[code.ops[offset+1]]) + ")")
+		case opcodeLiteral, opcodeDefGlobal, opcodeImport, opcodeGlobal, opcodeUndefGlobal, opcodeDefMacro, opcodePushHandler:
+			val := code.rt.getConstant(code.ops[offset+1])
+			if op == opcodeLiteral && val.primitive != nil && code.rt.primitiveNamed(val.primitive.name) == val.primitive {
+				// a primitive the optimizer made a literal cannot be read back, its global is used instead
+				s = prefix + "(" + GlobalSymbol.text
+				val = Intern(val.primitive.name)
+			}
+			buf.WriteString(s + " " + Write(val) + ")")
 			offset += 2
 		case opcodeCall, opcodeTailCall, opcodeJumpFalse, opcodeJump, opcodeVector, opcodeStruct:
 			buf.WriteString(s + " " + strconv.Itoa(code.ops[offset+1]) + ")")
@@ -169,6 +203,12 @@ func (code *Code) decompileInto(buf *bytes.Buffer, indent string, pretty bool) {
 		case opcodeLocal, opcodeSetLocal:
 			buf.WriteString(s + " " + strconv.Itoa(code.ops[offset+1]) + " " + strconv.Itoa(code.ops[offset+2]) + ")")
 			offset += 3
+		case opcodeGlobalCall:
+			buf.WriteString(s + " " + Write(code.rt.getConstant(code.ops[offset+1])) + " " + strconv.Itoa(code.ops[offset+2]) + ")")
+			offset += 3
+		case opcodeLocalJumpFalse:
+			buf.WriteString(s + " " + strconv.Itoa(code.ops[offset+1]) + " " + strconv.Itoa(code.ops[offset+2]) + " " + strconv.Itoa(code.ops[offset+3]) + ")")
+			offset += 4
 		case opcodeClosure:
 			buf.WriteString(s)
 			if pretty {
@@ -180,7 +220,7 @@ func (code *Code) decompileInto(buf *bytes.Buffer, indent string, pretty bool) {
 			if pretty {
 				indent2 = indent + indentAmount
 			}
-			constants[code.ops[offset+1]].code.decompileInto(buf, indent2, pretty)
+			code.rt.getConstant(code.ops[offset+1]).code.decompileInto(buf, indent2, pretty)
 			buf.WriteString(")")
 			offset += 2
 		default:
@@ -190,6 +230,29 @@ func (code *Code) decompileInto(buf *bytes.Buffer, indent string, pretty bool) {
 	buf.WriteString(")")
 }
 
+// instructionLength - return the number of ints the instruction occupies in the ops, including the opcode itself
+func instructionLength(op int) int {
+	switch op {
+	case opcodePop, opcodeReturn, opcodePopHandler, opcodeThrow:
+		return 1
+	case opcodeLocal, opcodeSetLocal, opcodeGlobalCall:
+		return 3
+	case opcodeLocalJumpFalse:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// hasConstantOperand - return true if the first operand of the instruction is an index into the constants table
+func hasConstantOperand(op int) bool {
+	switch op {
+	case opcodeLiteral, opcodeGlobal, opcodeDefGlobal, opcodeUndefGlobal, opcodeDefMacro, opcodeImport, opcodeClosure, opcodePushHandler, opcodeGlobalCall:
+		return true
+	}
+	return false
+}
+
 func (code *Code) String() string {
 	return code.decompile(true)
 	//	return fmt.Sprintf("(function (%d %v %s) %v)", code.argc, code.defaults, code.keys, code.ops)
@@ -248,7 +311,7 @@ func (code *Code) loadOps(lst *Object) error {
 			} else {
 				return Error(SyntaxErrorKey, funcParams)
 			}
-			fun := MakeCode(argc, defaults, keys, name)
+			fun := code.rt.makeCode(argc, defaults, keys, name)
 			fun.code.loadOps(Cdr(lstFunc))
 			code.emitClosure(fun)
 		case LiteralSymbol:
@@ -316,6 +379,40 @@ func (code *Code) loadOps(lst *Object) error {
 			code.emitDefMacro(Cadr(instr))
 		case ImportSymbol:
 			code.emitImport(Cadr(instr))
+		case PushhandlerSymbol:
+			table := Cadr(instr)
+			if !IsVector(table) || len(table.elements)%2 != 0 {
+				return Error(SyntaxErrorKey, instr)
+			}
+			code.emitPushHandler(table)
+		case PophandlerSymbol:
+			code.emitPopHandler()
+		case ThrowSymbol:
+			code.emitThrow()
+		case GlobalcallSymbol:
+			sym := Cadr(instr)
+			if !IsSymbol(sym) {
+				return Error(GlobalcallSymbol, " argument 1 not a symbol: ", sym)
+			}
+			argc, err := AsIntValue(Caddr(instr))
+			if err != nil {
+				return err
+			}
+			code.emitGlobalCall(sym, argc)
+		case LocaljumpfalseSymbol:
+			i, err := AsIntValue(Cadr(instr))
+			if err != nil {
+				return err
+			}
+			j, err := AsIntValue(Caddr(instr))
+			if err != nil {
+				return err
+			}
+			loc, err := AsIntValue(Cadddr(instr))
+			if err != nil {
+				return err
+			}
+			code.emitLocalJumpFalse(i, j, loc)
 		default:
 			panic(fmt.Sprintf("Bad instruction: %v", op))
 		}
@@ -326,12 +423,13 @@ func (code *Code) loadOps(lst *Object) error {
 
 func (code *Code) emitLiteral(val *Object) {
 	code.ops = append(code.ops, opcodeLiteral)
-	code.ops = append(code.ops, putConstant(val))
+	code.ops = append(code.ops, code.rt.putConstant(val))
 }
 
+// emitGlobal - the symbol may be a reference made by a syntax-rules expansion, see globalName
 func (code *Code) emitGlobal(sym *Object) {
 	code.ops = append(code.ops, opcodeGlobal)
-	code.ops = append(code.ops, putConstant(sym))
+	code.ops = append(code.ops, code.rt.putConstant(globalName(sym)))
 }
 func (code *Code) emitCall(argc int) {
 	code.ops = append(code.ops, opcodeCall)
@@ -359,19 +457,19 @@ func (code *Code) emitSetLocal(i int, j int) {
 }
 func (code *Code) emitDefGlobal(sym *Object) {
 	code.ops = append(code.ops, opcodeDefGlobal)
-	code.ops = append(code.ops, putConstant(sym))
+	code.ops = append(code.ops, code.rt.putConstant(globalName(sym)))
 }
 func (code *Code) emitUndefGlobal(sym *Object) {
 	code.ops = append(code.ops, opcodeUndefGlobal)
-	code.ops = append(code.ops, putConstant(sym))
+	code.ops = append(code.ops, code.rt.putConstant(globalName(sym)))
 }
 func (code *Code) emitDefMacro(sym *Object) {
 	code.ops = append(code.ops, opcodeDefMacro)
-	code.ops = append(code.ops, putConstant(sym))
+	code.ops = append(code.ops, code.rt.putConstant(globalName(sym)))
 }
 func (code *Code) emitClosure(newCode *Object) {
 	code.ops = append(code.ops, opcodeClosure)
-	code.ops = append(code.ops, putConstant(newCode))
+	code.ops = append(code.ops, code.rt.putConstant(newCode))
 }
 func (code *Code) emitJumpFalse(offset int) int {
 	code.ops = append(code.ops, opcodeJumpFalse)
@@ -400,5 +498,31 @@ func (code *Code) emitStruct(slen int) {
 
 func (code *Code) emitImport(sym *Object) {
 	code.ops = append(code.ops, opcodeImport)
-	code.ops = append(code.ops, putConstant(sym))
+	code.ops = append(code.ops, code.rt.putConstant(globalName(sym)))
+}
+
+// emitPushHandler - the table is a vector of catch keys and handler offsets (relative to this instruction), in
+// the order they are to be tried. A null key catches any error.
+func (code *Code) emitPushHandler(table *Object) int {
+	loc := len(code.ops)
+	code.ops = append(code.ops, opcodePushHandler)
+	code.ops = append(code.ops, code.rt.putConstant(table))
+	return loc
+}
+
+func (code *Code) emitPopHandler() {
+	code.ops = append(code.ops, opcodePopHandler)
+}
+
+func (code *Code) emitThrow() {
+	code.ops = append(code.ops, opcodeThrow)
+}
+
+func (code *Code) emitGlobalCall(sym *Object, argc int) {
+	code.ops = append(code.ops, opcodeGlobalCall, code.rt.putConstant(globalName(sym)), argc)
+}
+
+// emitLocalJumpFalse - the offset is relative to this instruction, like that of jumpfalse
+func (code *Code) emitLocalJumpFalse(i int, j int, offset int) {
+	code.ops = append(code.ops, opcodeLocalJumpFalse, i, j, offset)
 }
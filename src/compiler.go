@@ -1,15 +1,18 @@
 package vile
 
 // Compile - compile the source into a code object
-func Compile(expr *Object) (*Object, error) {
-	target := MakeCode(0, nil, nil, "")
+func (rt *Runtime) Compile(expr *Object) (*Object, error) {
+	target := rt.makeCode(0, nil, nil, "")
 
-	err := compileExpr(target, EmptyList, expr, false, false, "")
+	err := rt.compileExpr(target, EmptyList, expr, false, false, "")
 
 	if err != nil {
 		return nil, err
 	}
 	target.code.emitReturn()
+	if rt.optimize {
+		rt.optimizeCode(target.code)
+	}
 	return target, nil
 }
 
@@ -31,7 +34,7 @@ func calculateLocation(sym *Object, env *Object) (int, int, bool) {
 	return -1, -1, false
 }
 
-func compileSelfEvalLiteral(target *Object, expr *Object, isTail bool, ignoreResult bool) error {
+func (rt *Runtime) compileSelfEvalLiteral(target *Object, expr *Object, isTail bool, ignoreResult bool) error {
 	if !ignoreResult {
 		target.code.emitLiteral(expr)
 		if isTail {
@@ -41,8 +44,8 @@ func compileSelfEvalLiteral(target *Object, expr *Object, isTail bool, ignoreRes
 	return nil
 }
 
-func compileSymbol(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool) error {
-	if GetMacro(expr) != nil {
+func (rt *Runtime) compileSymbol(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool) error {
+	if rt.GetMacro(expr) != nil {
 		return Error(Intern("macro-error"), "Cannot use macro as a value: ", expr)
 	}
 	if i, j, ok := calculateLocation(expr, env); ok {
@@ -58,7 +61,7 @@ func compileSymbol(target *Object, env *Object, expr *Object, isTail bool, ignor
 	return nil
 }
 
-func compileQuote(target *Object, expr *Object, isTail bool, ignoreResult bool, lstlen int) error {
+func (rt *Runtime) compileQuote(target *Object, expr *Object, isTail bool, ignoreResult bool, lstlen int) error {
 	if lstlen != 2 {
 		return Error(SyntaxErrorKey, expr)
 	}
@@ -71,13 +74,13 @@ func compileQuote(target *Object, expr *Object, isTail bool, ignoreResult bool,
 	return nil
 }
 
-func compileDef(target *Object, env *Object, lst *Object, isTail bool, ignoreResult bool, lstlen int) error {
+func (rt *Runtime) compileDef(target *Object, env *Object, lst *Object, isTail bool, ignoreResult bool, lstlen int) error {
 	if lstlen < 3 {
 		return Error(SyntaxErrorKey, lst)
 	}
 	sym := Cadr(lst)
 	val := Caddr(lst)
-	err := compileExpr(target, env, val, false, false, sym.String())
+	err := rt.compileExpr(target, env, val, false, false, sym.String())
 	if err == nil {
 		target.code.emitDefGlobal(sym)
 		if ignoreResult {
@@ -89,7 +92,7 @@ func compileDef(target *Object, env *Object, lst *Object, isTail bool, ignoreRes
 	return err
 }
 
-func compileUndef(target *Object, lst *Object, isTail bool, ignoreResult bool, lstlen int) error {
+func (rt *Runtime) compileUndef(target *Object, lst *Object, isTail bool, ignoreResult bool, lstlen int) error {
 	if lstlen != 2 {
 		return Error(SyntaxErrorKey, lst)
 	}
@@ -108,7 +111,7 @@ func compileUndef(target *Object, lst *Object, isTail bool, ignoreResult bool, l
 	return nil
 }
 
-func compileMacro(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, lstlen int) error {
+func (rt *Runtime) compileMacro(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, lstlen int) error {
 	if lstlen != 3 {
 		return Error(SyntaxErrorKey, expr)
 	}
@@ -116,7 +119,7 @@ func compileMacro(target *Object, env *Object, expr *Object, isTail bool, ignore
 	if !IsSymbol(sym) {
 		return Error(SyntaxErrorKey, expr)
 	}
-	err := compileExpr(target, env, Caddr(expr), false, false, sym.String())
+	err := rt.compileExpr(target, env, Caddr(expr), false, false, sym.String())
 	if err != nil {
 		return err
 	}
@@ -131,7 +134,7 @@ func compileMacro(target *Object, env *Object, expr *Object, isTail bool, ignore
 	return err
 }
 
-func compileSet(target *Object, env *Object, lst *Object, isTail bool, ignoreResult bool, context string, lstlen int) error {
+func (rt *Runtime) compileSet(target *Object, env *Object, lst *Object, isTail bool, ignoreResult bool, context string, lstlen int) error {
 	if lstlen != 3 {
 		return Error(SyntaxErrorKey, lst)
 	}
@@ -139,7 +142,7 @@ func compileSet(target *Object, env *Object, lst *Object, isTail bool, ignoreRes
 	if !IsSymbol(sym) {
 		return Error(SyntaxErrorKey, lst)
 	}
-	err := compileExpr(target, env, Caddr(lst), false, false, context)
+	err := rt.compileExpr(target, env, Caddr(lst), false, false, context)
 	if err != nil {
 		return err
 	}
@@ -156,7 +159,15 @@ func compileSet(target *Object, env *Object, lst *Object, isTail bool, ignoreRes
 	return nil
 }
 
-func compileList(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileList(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
+	loc := listLocation(expr)
+	outer := target.code.markLocation(loc)
+	err := rt.compileForm(target, env, expr, isTail, ignoreResult, context)
+	target.code.markLocation(outer)
+	return withLocation(err, loc)
+}
+
+func (rt *Runtime) compileForm(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
 	if expr == EmptyList {
 		if !ignoreResult {
 			target.code.emitLiteral(expr)
@@ -174,44 +185,46 @@ func compileList(target *Object, env *Object, expr *Object, isTail bool, ignoreR
 	fn := Car(lst)
 	switch fn {
 	case Intern("quote"):
-		return compileQuote(target, expr, isTail, ignoreResult, lstlen)
+		return rt.compileQuote(target, expr, isTail, ignoreResult, lstlen)
 	case Intern("do"):
-		return compileSequence(target, env, Cdr(lst), isTail, ignoreResult, context)
+		return rt.compileSequence(target, env, Cdr(lst), isTail, ignoreResult, context)
 	case Intern("if"):
 		if lstlen == 3 || lstlen == 4 {
-			return compileIfElse(target, env, Cadr(expr), Caddr(expr), Cdddr(expr), isTail, ignoreResult, context)
+			return rt.compileIfElse(target, env, Cadr(expr), Caddr(expr), Cdddr(expr), isTail, ignoreResult, context)
 		}
 		return Error(SyntaxErrorKey, expr)
 	case Intern("var"):
-		return compileDef(target, env, expr, isTail, ignoreResult, lstlen)
+		return rt.compileDef(target, env, expr, isTail, ignoreResult, lstlen)
 	case Intern("undef"):
-		return compileUndef(target, expr, isTail, ignoreResult, lstlen)
+		return rt.compileUndef(target, expr, isTail, ignoreResult, lstlen)
 	case Intern("macro"):
-		return compileMacro(target, env, expr, isTail, ignoreResult, lstlen)
+		return rt.compileMacro(target, env, expr, isTail, ignoreResult, lstlen)
 	case Intern("func"):
 		if lstlen < 3 {
 			return Error(SyntaxErrorKey, expr)
 		}
 		body := Cddr(lst)
 		args := Cadr(lst)
-		return compileFn(target, env, args, body, isTail, ignoreResult, context)
+		return rt.compileFn(target, env, args, body, isTail, ignoreResult, context)
 	case Intern("set!"):
-		return compileSet(target, env, expr, isTail, ignoreResult, context, lstlen)
+		return rt.compileSet(target, env, expr, isTail, ignoreResult, context, lstlen)
 	case Intern("code"):
 		return target.code.loadOps(Cdr(expr))
 	case Intern("import"):
-		return compileImport(target, Cdr(lst))
+		return rt.compileImport(target, Cdr(lst))
+	case Intern("try"):
+		return rt.compileTry(target, env, expr, isTail, ignoreResult, context)
 	default:
 		fn, args := optimizeFuncall(fn, Cdr(lst))
-		return compileFuncall(target, env, fn, args, isTail, ignoreResult, context)
+		return rt.compileFuncall(target, env, fn, args, isTail, ignoreResult, context)
 	}
 }
 
-func compileVector(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileVector(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
 	vlen := len(expr.elements)
 	for i := vlen - 1; i >= 0; i-- {
 		obj := expr.elements[i]
-		err := compileExpr(target, env, obj, false, false, context)
+		err := rt.compileExpr(target, env, obj, false, false, context)
 		if err != nil {
 			return err
 		}
@@ -225,16 +238,16 @@ func compileVector(target *Object, env *Object, expr *Object, isTail bool, ignor
 	return nil
 }
 
-func compileStruct(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
-	vlen := len(expr.bindings) * 2
+func (rt *Runtime) compileStruct(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
+	vlen := expr.bindings.size() * 2
 	vals := make([]*Object, 0, vlen)
-	for k, v := range expr.bindings {
-		vals = append(vals, k.toObject())
-		vals = append(vals, v)
+	for _, e := range expr.bindings.entries {
+		vals = append(vals, e.key.toObject())
+		vals = append(vals, e.value)
 	}
 	for i := vlen - 1; i >= 0; i-- {
 		obj := vals[i]
-		err := compileExpr(target, env, obj, false, false, context)
+		err := rt.compileExpr(target, env, obj, false, false, context)
 		if err != nil {
 			return err
 		}
@@ -248,17 +261,17 @@ func compileStruct(target *Object, env *Object, expr *Object, isTail bool, ignor
 	return nil
 }
 
-func compileExpr(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileExpr(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
 	if IsKeyword(expr) || IsType(expr) {
-		return compileSelfEvalLiteral(target, expr, isTail, ignoreResult)
+		return rt.compileSelfEvalLiteral(target, expr, isTail, ignoreResult)
 	} else if IsSymbol(expr) {
-		return compileSymbol(target, env, expr, isTail, ignoreResult)
+		return rt.compileSymbol(target, env, expr, isTail, ignoreResult)
 	} else if IsList(expr) {
-		return compileList(target, env, expr, isTail, ignoreResult, context)
+		return rt.compileList(target, env, expr, isTail, ignoreResult, context)
 	} else if IsVector(expr) {
-		return compileVector(target, env, expr, isTail, ignoreResult, context)
+		return rt.compileVector(target, env, expr, isTail, ignoreResult, context)
 	} else if IsStruct(expr) {
-		return compileStruct(target, env, expr, isTail, ignoreResult, context)
+		return rt.compileStruct(target, env, expr, isTail, ignoreResult, context)
 	}
 	if !ignoreResult {
 		target.code.emitLiteral(expr)
@@ -269,7 +282,7 @@ func compileExpr(target *Object, env *Object, expr *Object, isTail bool, ignoreR
 	return nil
 }
 
-func compileFn(target *Object, env *Object, args *Object, body *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileFn(target *Object, env *Object, args *Object, body *Object, isTail bool, ignoreResult bool, context string) error {
 	argc := 0
 	var syms []*Object
 	var defaults []*Object
@@ -305,11 +318,11 @@ func compileFn(target *Object, env *Object, args *Object, body *Object, isTail b
 				if Cdr(tmp) != EmptyList {
 					return Error(SyntaxErrorKey, tmp)
 				}
-				slen := len(a.bindings)
+				slen := a.bindings.size()
 				defaults = make([]*Object, 0, slen)
 				keys = make([]*Object, 0, slen)
-				for k, defValue := range a.bindings {
-					sym := k.toObject()
+				for _, e := range a.bindings.entries {
+					sym, defValue := e.key.toObject(), e.value
 					if IsList(sym) && Car(sym) == Intern("quote") && Cdr(sym) != EmptyList {
 						sym = Cadr(sym)
 					} else {
@@ -356,8 +369,9 @@ func compileFn(target *Object, env *Object, args *Object, body *Object, isTail b
 	}
 	args = ListFromValues(syms)
 	newEnv := Cons(args, env)
-	fnCode := MakeCode(argc, defaults, keys, context)
-	err := compileSequence(fnCode, newEnv, body, true, false, context)
+	fnCode := rt.makeCode(argc, defaults, keys, context)
+	fnCode.code.defined = target.code.location // that of the func form, or of the form it was expanded from
+	err := rt.compileSequence(fnCode, newEnv, body, true, false, context)
 	if err == nil {
 		if !ignoreResult {
 			target.code.emitClosure(fnCode)
@@ -369,16 +383,16 @@ func compileFn(target *Object, env *Object, args *Object, body *Object, isTail b
 	return err
 }
 
-func compileSequence(target *Object, env *Object, exprs *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileSequence(target *Object, env *Object, exprs *Object, isTail bool, ignoreResult bool, context string) error {
 	if exprs != EmptyList {
 		for Cdr(exprs) != EmptyList {
-			err := compileExpr(target, env, Car(exprs), false, true, context)
+			err := rt.compileExpr(target, env, Car(exprs), false, true, context)
 			if err != nil {
 				return err
 			}
 			exprs = Cdr(exprs)
 		}
-		return compileExpr(target, env, Car(exprs), isTail, ignoreResult, context)
+		return rt.compileExpr(target, env, Car(exprs), isTail, ignoreResult, context)
 	}
 	return Error(SyntaxErrorKey, Cons(Intern("do"), exprs))
 }
@@ -402,16 +416,16 @@ func optimizeFuncall(fn *Object, args *Object) (*Object, *Object) {
 	return fn, args
 }
 
-func compileFuncall(target *Object, env *Object, fn *Object, args *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileFuncall(target *Object, env *Object, fn *Object, args *Object, isTail bool, ignoreResult bool, context string) error {
 	argc := ListLength(args)
 	if argc < 0 {
 		return Error(SyntaxErrorKey, Cons(fn, args))
 	}
-	err := compileArgs(target, env, args, context)
+	err := rt.compileArgs(target, env, args, context)
 	if err != nil {
 		return err
 	}
-	err = compileExpr(target, env, fn, false, false, context)
+	err = rt.compileExpr(target, env, fn, false, false, context)
 	if err != nil {
 		return err
 	}
@@ -426,28 +440,28 @@ func compileFuncall(target *Object, env *Object, fn *Object, args *Object, isTai
 	return nil
 }
 
-func compileArgs(target *Object, env *Object, args *Object, context string) error {
+func (rt *Runtime) compileArgs(target *Object, env *Object, args *Object, context string) error {
 	if args != EmptyList {
-		err := compileArgs(target, env, Cdr(args), context)
+		err := rt.compileArgs(target, env, Cdr(args), context)
 		if err != nil {
 			return err
 		}
-		return compileExpr(target, env, Car(args), false, false, context)
+		return rt.compileExpr(target, env, Car(args), false, false, context)
 	}
 	return nil
 }
 
-func compileIfElse(target *Object, env *Object, predicate *Object, Consequent *Object, antecedentOptional *Object, isTail bool, ignoreResult bool, context string) error {
+func (rt *Runtime) compileIfElse(target *Object, env *Object, predicate *Object, Consequent *Object, antecedentOptional *Object, isTail bool, ignoreResult bool, context string) error {
 	antecedent := Null
 	if antecedentOptional != EmptyList {
 		antecedent = Car(antecedentOptional)
 	}
-	err := compileExpr(target, env, predicate, false, false, context)
+	err := rt.compileExpr(target, env, predicate, false, false, context)
 	if err != nil {
 		return err
 	}
 	loc1 := target.code.emitJumpFalse(0)
-	err = compileExpr(target, env, Consequent, isTail, ignoreResult, context)
+	err = rt.compileExpr(target, env, Consequent, isTail, ignoreResult, context)
 	if err != nil {
 		return err
 	}
@@ -456,7 +470,7 @@ func compileIfElse(target *Object, env *Object, predicate *Object, Consequent *O
 		loc2 = target.code.emitJump(0)
 	}
 	target.code.setJumpLocation(loc1)
-	err = compileExpr(target, env, antecedent, isTail, ignoreResult, context)
+	err = rt.compileExpr(target, env, antecedent, isTail, ignoreResult, context)
 	if err == nil {
 		if !isTail {
 			target.code.setJumpLocation(loc2)
@@ -465,7 +479,7 @@ func compileIfElse(target *Object, env *Object, predicate *Object, Consequent *O
 	return err
 }
 
-func compileImport(target *Object, rest *Object) error {
+func (rt *Runtime) compileImport(target *Object, rest *Object) error {
 	lstlen := ListLength(rest)
 	if lstlen != 1 {
 		return Error(SyntaxErrorKey, Cons(Intern("import"), rest))
@@ -477,3 +491,141 @@ func compileImport(target *Object, rest *Object) error {
 	target.code.emitImport(sym)
 	return nil
 }
+
+// crackTry - split (try body... (catch key (e) handler...)... (finally cleanup...)) into its parts
+func crackTry(expr *Object) (*Object, []*Object, *Object, error) {
+	var clauses []*Object
+	finally := EmptyList
+	var body []*Object
+	for forms := Cdr(expr); forms != EmptyList; forms = Cdr(forms) {
+		form := Car(forms)
+		head := Null
+		if IsList(form) {
+			head = Car(form)
+		}
+		switch head {
+		case Intern("catch"):
+			if finally != EmptyList {
+				return nil, nil, nil, Error(SyntaxErrorKey, expr)
+			}
+			clauses = append(clauses, form)
+		case Intern("finally"):
+			if finally != EmptyList || Cdr(forms) != EmptyList || Cdr(form) == EmptyList {
+				return nil, nil, nil, Error(SyntaxErrorKey, expr)
+			}
+			finally = Cdr(form)
+		default:
+			if clauses != nil {
+				return nil, nil, nil, Error(SyntaxErrorKey, expr)
+			}
+			body = append(body, form)
+		}
+	}
+	if body == nil {
+		return nil, nil, nil, Error(SyntaxErrorKey, expr)
+	}
+	return ListFromValues(body), clauses, finally, nil
+}
+
+// crackCatch - return the keys, the error variable list, and the body of a catch clause. The keys are
+// null for a clause that catches any error.
+func crackCatch(clause *Object) (*Object, *Object, *Object, error) {
+	keys := Null
+	rest := Cdr(clause)
+	if IsKeyword(Car(rest)) {
+		keys = Car(rest)
+		rest = Cdr(rest)
+	} else if IsVector(Car(rest)) {
+		keys = Car(rest)
+		for _, k := range keys.elements {
+			if !IsKeyword(k) {
+				return nil, nil, nil, Error(SyntaxErrorKey, clause)
+			}
+		}
+		rest = Cdr(rest)
+	}
+	args := Car(rest)
+	if !IsList(args) || ListLength(args) != 1 || !IsSymbol(Car(args)) || Cdr(rest) == EmptyList {
+		return nil, nil, nil, Error(SyntaxErrorKey, clause)
+	}
+	return keys, args, Cdr(rest), nil
+}
+
+func (rt *Runtime) compileTry(target *Object, env *Object, expr *Object, isTail bool, ignoreResult bool, context string) error {
+	body, clauses, finally, err := crackTry(expr)
+	if err != nil {
+		return err
+	}
+	if finally != EmptyList {
+		// errors are caught by a catch-all handler that runs the cleanup, then throws the error again. Continuations
+		// escaping from the body run it too, see vm.unwind.
+		table := Vector(finallyKey, Zero)
+		loc := target.code.emitPushHandler(table)
+		err = rt.compileCatch(target, env, body, clauses, context)
+		if err != nil {
+			return err
+		}
+		target.code.emitPopHandler()
+		err = rt.compileSequence(target, env, finally, false, true, context)
+		if err != nil {
+			return err
+		}
+		done := target.code.emitJump(0)
+		table.elements[1] = Number(float64(len(target.code.ops) - loc))
+		err = rt.compileSequence(target, env, finally, false, true, context)
+		if err != nil {
+			return err
+		}
+		target.code.emitThrow()
+		target.code.setJumpLocation(done)
+	} else {
+		err = rt.compileCatch(target, env, body, clauses, context)
+		if err != nil {
+			return err
+		}
+	}
+	if ignoreResult {
+		target.code.emitPop()
+	} else if isTail {
+		target.code.emitReturn()
+	}
+	return nil
+}
+
+// compileCatch - compile the body so that errors matching the catch clauses are handled, leaving the result
+// of the body, or of the handler, on the stack. The body is never in tail position, so its handler can be popped.
+func (rt *Runtime) compileCatch(target *Object, env *Object, body *Object, clauses []*Object, context string) error {
+	if len(clauses) == 0 {
+		return rt.compileSequence(target, env, body, false, false, context)
+	}
+	table := MakeVector(len(clauses)*2, Null)
+	loc := target.code.emitPushHandler(table)
+	err := rt.compileSequence(target, env, body, false, false, context)
+	if err != nil {
+		return err
+	}
+	target.code.emitPopHandler()
+	var exits []int
+	exits = append(exits, target.code.emitJump(0))
+	for i, clause := range clauses {
+		keys, args, handler, err := crackCatch(clause)
+		if err != nil {
+			return err
+		}
+		table.elements[i*2] = keys
+		table.elements[i*2+1] = Number(float64(len(target.code.ops) - loc))
+		// the error is on the stack, as the argument to the handler
+		err = rt.compileFn(target, env, args, handler, false, false, context)
+		if err != nil {
+			return err
+		}
+		target.code.emitCall(1)
+		if i < len(clauses)-1 {
+			exits = append(exits, target.code.emitJump(0))
+		}
+	}
+	for _, loc := range exits {
+		target.code.setJumpLocation(loc)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package vile
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// newTestRuntime - create a new runtime, failing the test if it cannot be initialized
+func newTestRuntime(tb testing.TB) *Runtime {
+	tb.Helper()
+	rt, err := NewRuntime()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return rt
+}
+
+// evalString - read and evaluate the forms in the source, returning the value of the last one
+func evalString(rt *Runtime, src string) (*Object, error) {
+	forms, err := ReadAll(String(src), nil)
+	if err != nil {
+		return nil, err
+	}
+	result := Null
+	for ; forms != EmptyList; forms = Cdr(forms) {
+		result, err = rt.Eval(Car(forms))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// TestConcurrentRuntime - define globals and macros, compile and call functions from several goroutines at once on
+// one runtime. Run it with -race to check the global, macro, symbol and constant tables.
+func TestConcurrentRuntime(t *testing.T) {
+	const workers = 8
+	const rounds = 50
+	rt := newTestRuntime(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- concurrentWorker(rt, w, rounds)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for w := 0; w < workers; w++ {
+		val := rt.GetGlobal(Intern(fmt.Sprintf("worker-%d", w)))
+		if !Equal(val, Number(float64(rounds-1))) {
+			t.Errorf("worker-%d is %v, expected %d", w, val, rounds-1)
+		}
+	}
+}
+
+// concurrentWorker - the rounds of one goroutine of TestConcurrentRuntime. Each round redefines globals and macros
+// the other goroutines use too, and some of its own.
+func concurrentWorker(rt *Runtime, w int, rounds int) error {
+	for i := 0; i < rounds; i++ {
+		rt.DefineGlobal(fmt.Sprintf("worker-%d", w), Number(float64(i)))
+		_, err := evalString(rt, fmt.Sprintf(`
+			(macro shared-inc (x) (list '+ x 1))
+			(macro own-inc-%d (x) (list 'shared-inc x))
+			(fn shared-add (x y) (+ x y))
+			(fn own-function-%d (x) (shared-add (own-inc-%d x) %d))`, w, w, w, i))
+		if err != nil {
+			return err
+		}
+		expr, err := Read(String(fmt.Sprintf("(own-function-%d (shared-inc %d))", w, i)), nil)
+		if err != nil {
+			return err
+		}
+		expanded, err := rt.Macroexpand(expr)
+		if err != nil {
+			return err
+		}
+		if _, err := rt.Compile(expanded); err != nil {
+			return err
+		}
+		fun := rt.GetGlobal(Intern(fmt.Sprintf("own-function-%d", w)))
+		if fun == nil {
+			return fmt.Errorf("own-function-%d is not defined", w)
+		}
+		val, err := rt.Call(fun, Number(float64(i)))
+		if err != nil {
+			return err
+		}
+		if !Equal(val, Number(float64(2*i+1))) {
+			return fmt.Errorf("own-function-%d returned %v in round %d", w, val, i)
+		}
+	}
+	return nil
+}
+
+// TestConcurrentGensym - the symbols generated at the same time are all distinct, and none of them is interned
+func TestConcurrentGensym(t *testing.T) {
+	const workers = 8
+	const count = 1000
+	syms := make([][]*Object, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				syms[w] = append(syms[w], Gensym("tmp"))
+			}
+		}(w)
+	}
+	wg.Wait()
+	names := make(map[string]bool)
+	for _, ss := range syms {
+		for _, sym := range ss {
+			if names[sym.text] {
+				t.Fatalf("%s was generated twice", sym.text)
+			}
+			names[sym.text] = true
+			if Intern(sym.text) == sym {
+				t.Fatalf("%s is interned", sym.text)
+			}
+		}
+	}
+}
+
+// TestConcurrentSharedError - tasks throwing the same error object each catch a copy with their own context, and
+// the shared error is left unchanged. Run it with -race.
+func TestConcurrentSharedError(t *testing.T) {
+	rt := newTestRuntime(t)
+	_, err := evalString(rt, `
+		(var shared (error argument-error: "shared"))
+		(fn fail () (throw shared))
+		(fn catch-shared () (try (fail) (catch argument-error: (e) (error-context e))))
+		(var t1 (spawn catch-shared))
+		(var t2 (spawn catch-shared))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxs, err := evalString(rt, `(list (catch-shared) (await t1) (await t2))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ctx := range listElements(ctxs) {
+		if ctx == Null {
+			t.Errorf("a caught error has no context")
+		}
+	}
+	shared, err := evalString(rt, `(error-context shared)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared != Null {
+		t.Errorf("the shared error was given the context %v", shared)
+	}
+}
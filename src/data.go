@@ -3,7 +3,7 @@ package vile
 import (
 	"bytes"
 	"fmt"
-	"strconv"
+	"strings"
 )
 
 // Object is the Vile object: a union of all possible primitive types. Which fields are used depends on the variant
@@ -18,9 +18,10 @@ type Object struct {
 	continuation *continuation         // non-nil for continuation
 	car          *Object               // non-nil for instances and lists
 	cdr          *Object               // non-nil for slists, nil for everything else
-	bindings     map[structKey]*Object // non-nil for struct
+	bindings     *structBindings       // non-nil for struct
 	elements     []*Object             // non-nil for vector
-	fval         float64               // number
+	fval         float64               // number, the approximation of exact ones
+	ival         int64                 // exact integer number that fits in 64 bits
 	text         string                // string, symbol, keyword, type
 	Value        interface{}           // the rest of the data for more complex things
 }
@@ -51,11 +52,17 @@ func RuneValue(obj *Object) rune {
 
 // IntValue - return native int value of the object
 func IntValue(obj *Object) int {
+	if isFixnum(obj) {
+		return int(obj.ival)
+	}
 	return int(obj.fval)
 }
 
 // Int64Value - return native int64 value of the object
 func Int64Value(obj *Object) int64 {
+	if isFixnum(obj) {
+		return obj.ival
+	}
 	return int64(obj.fval)
 }
 
@@ -99,7 +106,7 @@ func (lob *Object) String() string {
 	case CharacterType:
 		return string([]rune{rune(lob.fval)})
 	case NumberType:
-		return strconv.FormatFloat(lob.fval, 'f', -1, 64)
+		return numberToString(lob)
 	case StringType, SymbolType, KeywordType, TypeType:
 		return lob.text
 	case ListType:
@@ -237,7 +244,7 @@ func Equal(o1 *Object, o2 *Object) bool {
 	case BooleanType, CharacterType:
 		return int(o1.fval) == int(o2.fval)
 	case NumberType:
-		return NumberEqual(o1.fval, o2.fval)
+		return NumberEqual(o1, o2)
 	case StringType:
 		return o1.text == o2.text
 	case ListType:
@@ -246,6 +253,8 @@ func Equal(o1 *Object, o2 *Object) bool {
 		return VectorEqual(o1, o2)
 	case StructType:
 		return StructEqual(o1, o2)
+	case PersistentStructType, PersistentVectorType:
+		return persistentEqual(o1, o2)
 	case SymbolType, KeywordType, TypeType:
 		return o1 == o2
 	case NullType:
@@ -260,6 +269,35 @@ func Equal(o1 *Object, o2 *Object) bool {
 	}
 }
 
+// Compare - return -1, 0 or 1 as the first object orders before, the same as, or after the second. Numbers,
+// strings, characters, symbols and keywords can be compared, each to objects of the same type, and vectors are
+// compared element by element.
+func Compare(o1 *Object, o2 *Object) (int, error) {
+	if o1.Type == o2.Type {
+		switch o1.Type {
+		case NumberType:
+			if c, ok := numberCompare(o1, o2); ok {
+				return c, nil
+			}
+			return 0, Error(ArgumentErrorKey, "Cannot order ", o1, " and ", o2)
+		case StringType:
+			return strings.Compare(o1.text, o2.text), nil
+		case CharacterType:
+			return compareFloats(o1.fval, o2.fval), nil
+		case SymbolType, KeywordType:
+			return strings.Compare(o1.text, o2.text), nil
+		case VectorType:
+			for i := 0; i < len(o1.elements) && i < len(o2.elements); i++ {
+				if c, err := Compare(o1.elements[i], o2.elements[i]); c != 0 || err != nil {
+					return c, err
+				}
+			}
+			return compareInts(int64(len(o1.elements)), int64(len(o2.elements))), nil
+		}
+	}
+	return 0, Error(ArgumentErrorKey, "Cannot compare a ", o1.Type, " to a ", o2.Type)
+}
+
 func IsPrimitiveType(tag *Object) bool {
 	switch tag {
 	case NullType, BooleanType, CharacterType, NumberType, StringType, ListType, VectorType, StructType:
@@ -278,6 +316,18 @@ func Instance(tag *Object, val *Object) (*Object, error) {
 	if IsPrimitiveType(tag) {
 		return val, nil
 	}
+	if (tag == PersistentStructType && IsStruct(val)) || (tag == PersistentVectorType && IsVector(val)) {
+		return ToPersistent(val)
+	}
+	if tag == RegexType {
+		if !IsString(val) {
+			return nil, Error(ArgumentErrorKey, "Expected a <string> pattern for a <regex>, got a ", val.Type)
+		}
+		return Regex(val.text)
+	}
+	if rec := lookupRecord(tag); rec != nil {
+		return recordInstance(tag, rec, val)
+	}
 	result := new(Object)
 	result.Type = tag
 	result.car = val
@@ -338,12 +388,92 @@ func ErrorData(err *Object) *Object {
 	return err.car
 }
 
+// errorPayload - return the data of the error following its key: null if there is none, the object if there
+// is one, or a vector of them
+func errorPayload(err *Object) *Object {
+	data := ErrorData(err)
+	if data == nil || !IsVector(data) {
+		return Null
+	}
+	switch len(data.elements) {
+	case 0, 1:
+		return Null
+	case 2:
+		return data.elements[1]
+	default:
+		return VectorFromElements(data.elements[1:], len(data.elements)-1)
+	}
+}
+
+// errorMessage - return a readable description of the error's data
+func errorMessage(err *Object) string {
+	payload := errorPayload(err)
+	if payload == Null {
+		return unkeywordedString(errorKey(err))
+	}
+	return payload.String()
+}
+
+// errorContext - the context of an error: the function it occurred in, where in the source, and the calls
+// that were pending
+func errorContext(function string, loc *location, trace []*Object) *Object {
+	ctx := MakeStruct(3)
+	if function == "" {
+		Put(ctx, Intern("function:"), Null)
+	} else {
+		Put(ctx, Intern("function:"), String(function))
+	}
+	if loc == nil {
+		Put(ctx, Intern("location:"), Null)
+	} else {
+		Put(ctx, Intern("location:"), String(loc.String()))
+	}
+	if trace != nil {
+		Put(ctx, Intern("trace:"), Vector(trace...))
+	}
+	return ctx
+}
+
+// withContext - return a copy of the error with the context, leaving the error itself unchanged, as it may be
+// shared with other tasks or thrown again
+func withContext(err *Object, ctx *Object) *Object {
+	if ctx == nil {
+		return &Object{Type: ErrorType, car: err.car}
+	}
+	return &Object{Type: ErrorType, car: err.car, Value: ctx}
+}
+
+// ErrorContext - return the context recorded for the error when it was raised, as a <struct>, or null
+func ErrorContext(err *Object) *Object {
+	if ctx, ok := err.Value.(*Object); ok {
+		return ctx
+	}
+	return Null
+}
+
+// errorKey - return the keyword that classifies the error, as matched by catch clauses
+func errorKey(err *Object) *Object {
+	data := ErrorData(err)
+	if data != nil && IsVector(data) && len(data.elements) > 0 && IsKeyword(data.elements[0]) {
+		return data.elements[0]
+	}
+	return ErrorKey
+}
+
 // Error
 func (lob *Object) Error() string {
 	if lob.Type == ErrorType {
 		s := lob.car.String()
-		if lob.text != "" {
-			s += " [in " + lob.text + "]"
+		if ctx := ErrorContext(lob); ctx != Null {
+			fn := structGet(ctx, Intern("function:"))
+			loc := structGet(ctx, Intern("location:"))
+			if fn != Null && loc != Null {
+				s += " [in " + fn.text + " at " + loc.text + "]"
+			} else if fn != Null {
+				s += " [in " + fn.text + "]"
+			} else if loc != Null {
+				s += " [at " + loc.text + "]"
+			}
 		}
 		return s
 	}
@@ -0,0 +1,79 @@
+package vile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format - format the arguments as directed by the format string, as fmt.Sprintf does, with verbs that take vile
+// objects: %v and %s give the displayed form of any object, %q its written form, %d, %x, %X, %o and %b exact
+// integers of any size, %f, %e and %g any number, as a float, and %c a character. %% is a percent sign. Flags,
+// width and precision are as in fmt. The arguments must match the verbs, in number and in type.
+func Format(format string, args []*Object) (string, error) {
+	var buf strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			buf.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("+-# 0123456789.", format[j]) >= 0 {
+			j++
+		}
+		if j == len(format) {
+			return "", Error(ArgumentErrorKey, "format: incomplete verb at the end of ", EncodeString(format))
+		}
+		spec, verb := format[i:j], format[j]
+		i = j
+		if verb == '%' {
+			buf.WriteByte('%')
+			continue
+		}
+		if next == len(args) {
+			return "", Error(ArgumentErrorKey, "format: missing argument for ", spec+string(verb))
+		}
+		val, err := formatValue(verb, args[next])
+		if err != nil {
+			return "", err
+		}
+		next++
+		if verb == 'q' || verb == 'v' {
+			verb = 's'
+		}
+		fmt.Fprintf(&buf, spec+string(verb), val)
+	}
+	if next < len(args) {
+		return "", Error(ArgumentErrorKey, "format: ", len(args)-next, " unused argument(s)")
+	}
+	return buf.String(), nil
+}
+
+// formatValue - return the native value the fmt verb is applied to for the object
+func formatValue(verb byte, obj *Object) (interface{}, error) {
+	switch verb {
+	case 'v', 's':
+		return obj.String(), nil
+	case 'q':
+		return Write(obj), nil
+	case 'd', 'x', 'X', 'o', 'b':
+		if obj.Type == NumberType {
+			if n, err := integerValue(obj); err == nil {
+				return n, nil
+			}
+		}
+		return nil, Error(ArgumentErrorKey, "format: %", string(verb), " expected an integer, got ", obj)
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		if obj.Type == NumberType {
+			return obj.fval, nil
+		}
+		return nil, Error(ArgumentErrorKey, "format: %", string(verb), " expected a <number>, got a ", obj.Type)
+	case 'c':
+		if obj.Type == CharacterType {
+			return rune(obj.fval), nil
+		}
+		return nil, Error(ArgumentErrorKey, "format: %c expected a <char>, got a ", obj.Type)
+	}
+	return nil, Error(ArgumentErrorKey, "format: unknown verb %", string(verb))
+}
@@ -0,0 +1,78 @@
+package vile
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "write the .out files of the test scripts from their plain output")
+
+// TestScripts - run each script in tests that has a golden .out file next to it, plain and optimized, and compare
+// what it prints to the file. With -update, the .out files of all the scripts are written instead.
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("tests", "*.vl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range scripts {
+		golden := strings.TrimSuffix(script, ".vl") + ".out"
+		if *updateGolden {
+			out, err := runScript(script, false)
+			if err != nil {
+				t.Fatalf("%s: %v", script, err)
+			}
+			if err := os.WriteFile(golden, out, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := os.ReadFile(golden)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, optimize := range []bool{false, true} {
+			out, err := runScript(script, optimize)
+			if err != nil {
+				t.Errorf("%s (optimize %v): %v", script, optimize, err)
+				continue
+			}
+			if !bytes.Equal(out, expected) {
+				t.Errorf("%s (optimize %v): the output differs from %s:\n%s", script, optimize, golden, out)
+			}
+		}
+	}
+}
+
+// runScript - load the script in a new runtime, and return what it printed
+func runScript(script string, optimize bool) ([]byte, error) {
+	rt, err := NewRuntime()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		printed <- out
+	}()
+	rt.SetFlags(optimize, false, false, false, false)
+	err = rt.Load(script)
+	os.Stdout = stdout
+	w.Close()
+	out := <-printed
+	r.Close()
+	return out, err
+}
@@ -0,0 +1,301 @@
+package vile
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Runtime - an independent vile environment. Each runtime has its own globals, macros, constants, load path
+// and flags, so one program can host several of them, for example one per tenant. Symbols are shared by all
+// runtimes, but objects that refer to code (functions, macros) must not be passed from one runtime to another.
+type Runtime struct {
+	*environment
+	limiter *limiter       // non-nil for a view of the runtime created by WithLimits
+	caller  *profileCaller // while profiling, where the primitive given this view of the runtime was called
+}
+
+// environment - the state of a runtime, shared with the views of it
+type environment struct {
+	globals globalTable
+
+	macroLock sync.RWMutex
+	macros    map[*Object]*macro
+
+	// the parsed syntax-rules, by the form they were parsed from, see syntaxRulesOf
+	syntaxLock  sync.RWMutex
+	syntaxRules map[*Object]*syntaxRules
+
+	// the macro applications recorded while tracing expansions
+	expansionLock     sync.Mutex
+	tracingExpansions bool
+	expansions        []*Object
+
+	// The constants are append-only, and published as a whole slice, so a snapshot of them can be read without
+	// locking: every index it covers stays valid. Definitions take the lock.
+	constantsLock sync.Mutex
+	constantsMap  map[*Object]int
+	constants     atomic.Value // []*Object
+
+	// the bindings of the globals referred to by the global instructions, by the index of their symbol in the
+	// constants. Published like the constants, see globalCell.
+	cellsLock sync.Mutex
+	cells     atomic.Value // []*binding
+
+	optimize    bool
+	verbose     bool
+	debug       bool
+	trace       bool
+	interactive bool
+
+	maxStackSize int // the size the stack of a vm may grow to, in slots
+
+	profiler atomic.Pointer[profiler] // non-nil while profiling, see StartProfiling. Read by the vms of spawned tasks.
+
+	extensions []Extension
+}
+
+func newRuntime() *Runtime {
+	rt := &Runtime{environment: &environment{
+		macros:       make(map[*Object]*macro, 0),
+		syntaxRules:  make(map[*Object]*syntaxRules, 0),
+		constantsMap: make(map[*Object]int, 0),
+		maxStackSize: defaultMaxStackSize,
+	}}
+	rt.globals.bindings.Store(make(map[*Object]*binding, 0))
+	rt.constants.Store(make([]*Object, 0, 1000))
+	rt.cells.Store([]*binding(nil))
+	return rt
+}
+
+// NewRuntime - create a new runtime, with the primitives and the given extensions initialized, and the load
+// path taken from the environment. The error is that of the first extension that fails to initialize.
+func NewRuntime(extns ...Extension) (*Runtime, error) {
+	rt := newRuntime()
+	if err := rt.Init(extns...); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// the runtime used by the package level functions
+var defaultRuntime = newRuntime()
+
+// DefaultRuntime - return the runtime that the package level functions operate on
+func DefaultRuntime() *Runtime {
+	return defaultRuntime
+}
+
+// binding - the value of a global variable. Bindings are never removed from their runtime, only set to nil
+type binding struct {
+	value unsafe.Pointer // *Object, accessed atomically
+}
+
+func (b *binding) get() *Object {
+	return (*Object)(atomic.LoadPointer(&b.value))
+}
+
+func (b *binding) set(val *Object) {
+	atomic.StorePointer(&b.value, unsafe.Pointer(val))
+}
+
+// globalTable - the global bindings of a runtime. The VM looks them up without locking: the map is never
+// modified once published, a new binding replaces it with a copy. Values are set atomically in the bindings.
+type globalTable struct {
+	lock     sync.Mutex
+	bindings atomic.Value // map[*Object]*binding
+}
+
+func (t *globalTable) snapshot() map[*Object]*binding {
+	return t.bindings.Load().(map[*Object]*binding)
+}
+
+func (t *globalTable) lookup(sym *Object) *binding {
+	return t.snapshot()[sym]
+}
+
+// bind - return the binding for the symbol, creating it if needed
+func (t *globalTable) bind(sym *Object) *binding {
+	if b := t.lookup(sym); b != nil {
+		return b
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	old := t.snapshot()
+	if b, ok := old[sym]; ok {
+		return b
+	}
+	bindings := make(map[*Object]*binding, len(old)+1)
+	for k, v := range old {
+		bindings[k] = v
+	}
+	b := new(binding)
+	bindings[sym] = b
+	t.bindings.Store(bindings)
+	return b
+}
+
+// The package level functions below operate on the default runtime.
+
+// SetFlags - set various flags controlling the default runtime
+func SetFlags(o bool, v bool, d bool, t bool, i bool) {
+	defaultRuntime.SetFlags(o, v, d, t, i)
+}
+
+// SetMaxStackSize - set the size the stack of the default runtime may grow to
+func SetMaxStackSize(size int) {
+	defaultRuntime.SetMaxStackSize(size)
+}
+
+// Bind the value to the global name
+func DefineGlobal(name string, obj *Object) {
+	defaultRuntime.DefineGlobal(name, obj)
+}
+
+// Register a primitive function to the specified global name
+func DefineFunction(name string, fun PrimitiveFunction, result *Object, args ...*Object) {
+	defaultRuntime.DefineFunction(name, fun, result, args...)
+}
+
+// Register a primitive function with Rest arguments to the specified global name
+func DefineFunctionRestArgs(name string, fun PrimitiveFunction, result *Object, rest *Object, args ...*Object) {
+	defaultRuntime.DefineFunctionRestArgs(name, fun, result, rest, args...)
+}
+
+// Register a primitive function with optional arguments to the specified global name
+func DefineFunctionOptionalArgs(name string, fun PrimitiveFunction, result *Object, args []*Object, defaults ...*Object) {
+	defaultRuntime.DefineFunctionOptionalArgs(name, fun, result, args, defaults...)
+}
+
+// Register a primitive function with keyword arguments to the specified global name
+func DefineFunctionKeyArgs(name string, fun PrimitiveFunction, result *Object, args []*Object, defaults []*Object, keys []*Object) {
+	defaultRuntime.DefineFunctionKeyArgs(name, fun, result, args, defaults, keys)
+}
+
+// Register a primitive macro with the specified name.
+func DefineMacro(name string, fun PrimitiveFunction) {
+	defaultRuntime.DefineMacro(name, fun)
+}
+
+// Globals - return a slice of all defined global symbols
+func Globals() []*Object {
+	return defaultRuntime.Globals()
+}
+
+// GetGlobal - return the global value for the specified symbol, or nil if the symbol is not defined.
+func GetGlobal(sym *Object) *Object {
+	return defaultRuntime.GetGlobal(sym)
+}
+
+// IsDefined - return true if the there is a global value defined for the symbol
+func IsDefined(sym *Object) bool {
+	return defaultRuntime.IsDefined(sym)
+}
+
+// Macros - return a slice of all defined macros
+func Macros() []*Object {
+	return defaultRuntime.Macros()
+}
+
+// GetMacro - return the macro for the symbol, or nil if not defined
+func GetMacro(sym *Object) *macro {
+	return defaultRuntime.GetMacro(sym)
+}
+
+func Import(sym *Object) error {
+	return defaultRuntime.Import(sym)
+}
+
+func FindModuleByName(moduleName string) (string, error) {
+	return defaultRuntime.FindModuleByName(moduleName)
+}
+
+func FindModuleFile(name string) (string, error) {
+	return defaultRuntime.FindModuleFile(name)
+}
+
+func Load(name string) error {
+	return defaultRuntime.Load(name)
+}
+
+func LoadFile(file string) error {
+	return defaultRuntime.LoadFile(file)
+}
+
+func Eval(expr *Object) (*Object, error) {
+	return defaultRuntime.Eval(expr)
+}
+
+func RunStringEval(vileCode string) error {
+	return defaultRuntime.RunStringEval(vileCode)
+}
+
+// Call - call the function with the arguments in the default runtime
+func Call(fun *Object, args ...*Object) (*Object, error) {
+	return defaultRuntime.Call(fun, args...)
+}
+
+// Compile - compile the source into a code object
+func Compile(expr *Object) (*Object, error) {
+	return defaultRuntime.Compile(expr)
+}
+
+// Macroexpand - return the expansion of all macros in the object and return the result
+func Macroexpand(expr *Object) (*Object, error) {
+	return defaultRuntime.Macroexpand(expr)
+}
+
+// Macroexpand1 - return the result of applying the macro named by the head of the form once
+func Macroexpand1(expr *Object) (*Object, error) {
+	return defaultRuntime.Macroexpand1(expr)
+}
+
+// CompileFile - compile the file, returning the textual (lap) listing of the code
+func CompileFile(name string) (*Object, error) {
+	return defaultRuntime.CompileFile(name)
+}
+
+// CompileModule - compile the file into the binary module format, and write it next to the source file
+// with an .lvm extension. The name of the written file is returned.
+func CompileModule(name string) (string, error) {
+	return defaultRuntime.CompileModule(name)
+}
+
+// DecodeLVM - decode a binary compiled module, returning its top level code objects in load order
+func DecodeLVM(data []byte) ([]*Object, error) {
+	return defaultRuntime.DecodeLVM(data)
+}
+
+func AddVileDirectory(dirname string) {
+	defaultRuntime.AddVileDirectory(dirname)
+}
+
+func InitPrimitives() {
+	defaultRuntime.InitPrimitives()
+}
+
+func Init(extns ...Extension) error {
+	return defaultRuntime.Init(extns...)
+}
+
+func Cleanup() {
+	defaultRuntime.Cleanup()
+}
+
+func Run(args ...string) {
+	defaultRuntime.Run(args...)
+}
+
+// StartProfiling - start profiling the default runtime
+func StartProfiling() {
+	defaultRuntime.StartProfiling()
+}
+
+// StopProfiling - stop profiling the default runtime, and return the results
+func StopProfiling() *Profile {
+	return defaultRuntime.StopProfiling()
+}
+
+func ReadEvalPrintLoop() {
+	defaultRuntime.ReadEvalPrintLoop()
+}
@@ -0,0 +1,28 @@
+package vile
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingExtension - an extension whose initialization fails
+type failingExtension struct{}
+
+func (failingExtension) Init() error {
+	return errors.New("cannot initialize")
+}
+
+func (failingExtension) Cleanup() {
+}
+
+func (failingExtension) String() string {
+	return "failing"
+}
+
+// TestNewRuntimeExtensionError - the error of an extension that fails to initialize is returned to the caller
+func TestNewRuntimeExtensionError(t *testing.T) {
+	rt, err := NewRuntime(failingExtension{})
+	if err == nil || err.Error() != "cannot initialize" || rt != nil {
+		t.Errorf("expected the error of the extension, got %v", err)
+	}
+}
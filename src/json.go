@@ -0,0 +1,477 @@
+package vile
+
+import (
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSONReader - a streaming decoder of JSON values. Objects are decoded to <struct>, arrays to <vector>, and
+// null, true and false to Null, True and False. The keys of objects are <keyword> or <string>, as chosen when
+// the reader is created. Integers are read as exact numbers, of any size, and other numbers as floats. Syntax
+// errors report the line and column they occurred at, as does nesting arrays and objects more than maxJSONDepth
+// deep.
+type JSONReader struct {
+	dr    *dataReader
+	keys  *Object
+	depth int
+}
+
+// the deepest nesting of arrays and objects that is read
+const maxJSONDepth = 1000
+
+// NewJSONReader - create a reader of the JSON values in the input. The keys are KeywordType or StringType; with
+// KeywordType, the keys that cannot be read back as keywords are left as strings.
+func NewJSONReader(in io.Reader, keys *Object) *JSONReader {
+	return &JSONReader{dr: newDataReader(in), keys: keys}
+}
+
+// Read - read the next JSON value from the input, returning io.EOF when there are no more values. The values
+// can be separated by whitespace, as in a JSON lines file.
+func (jr *JSONReader) Read() (*Object, error) {
+	c, err := jr.skipWhitespace()
+	if err != nil {
+		return nil, err
+	}
+	return jr.readValue(c)
+}
+
+// ReadJSON - read the single JSON value in the input
+func ReadJSON(in io.Reader, keys *Object) (*Object, error) {
+	return NewJSONReader(in, keys).readSingle()
+}
+
+// readSingle - read the value that is the whole of the remaining input
+func (jr *JSONReader) readSingle() (*Object, error) {
+	val, err := jr.Read()
+	if err == io.EOF {
+		return nil, jr.error("Expected a JSON value, got end of input")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = jr.skipWhitespace(); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, jr.error("Unexpected data after the JSON value")
+	}
+	return val, nil
+}
+
+// ReadJSONFile - read the JSON values in the file one at a time, calling the function with each of them, as for a
+// JSON lines file too large to read at once. Without a function, the file holds a single value, which is returned.
+func (rt *Runtime) ReadJSONFile(path string, fun *Object, keys *Object) (*Object, error) {
+	f, err := os.Open(ExpandFilePath(path))
+	if err != nil {
+		return nil, Error(IOErrorKey, err.Error())
+	}
+	defer f.Close()
+	jr := NewJSONReader(f, keys)
+	jr.dr.file = path
+	if fun == Null {
+		return jr.readSingle()
+	}
+	vm := rt.newVM(defaultStackSize)
+	for {
+		val, err := jr.Read()
+		if err == io.EOF {
+			return Null, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := vm.call(fun, []*Object{val}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WriteJSON - write the object to the output as JSON, indenting nested data by indentSize unless it is empty.
+// Lists are written as arrays, and keywords, symbols, types and characters as strings. The members of objects
+// are in the order of the keys of the structs, unless the SortKeys option is given.
+func WriteJSON(out io.Writer, obj *Object, indentSize string, options ...WriteOption) error {
+	s, err := writeToString(obj, newWriteMode(true, options), indentSize)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, s)
+	return err
+}
+
+func (jr *JSONReader) error(args ...interface{}) error {
+	return withLocation(Error(SyntaxErrorKey, args...), jr.dr.location())
+}
+
+// next - return the next character, where the end of input is an error
+func (jr *JSONReader) next() (byte, error) {
+	c, err := jr.dr.getChar()
+	if err == io.EOF {
+		return 0, jr.error("Unexpected end of JSON input")
+	}
+	return c, err
+}
+
+func (jr *JSONReader) skipWhitespace() (byte, error) {
+	for {
+		c, err := jr.dr.getChar()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c, nil
+		}
+	}
+}
+
+// nextData - return the next character that is not whitespace, where the end of input is an error
+func (jr *JSONReader) nextData() (byte, error) {
+	c, err := jr.skipWhitespace()
+	if err == io.EOF {
+		return 0, jr.error("Unexpected end of JSON input")
+	}
+	return c, err
+}
+
+func (jr *JSONReader) readValue(c byte) (*Object, error) {
+	switch {
+	case c == '{' || c == '[':
+		if jr.depth == maxJSONDepth {
+			return nil, jr.error("JSON arrays and objects are nested more than ", maxJSONDepth, " deep")
+		}
+		jr.depth++
+		defer func() { jr.depth-- }()
+		if c == '{' {
+			return jr.readObject()
+		}
+		return jr.readArray()
+	case c == '"':
+		s, err := jr.readString()
+		if err != nil {
+			return nil, err
+		}
+		return String(s), nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return jr.readNumber(c)
+	case c >= 'a' && c <= 'z':
+		return jr.readLiteral(c)
+	}
+	return nil, jr.error("Unexpected character in JSON: ", strconv.QuoteRune(rune(c)))
+}
+
+func (jr *JSONReader) readObject() (*Object, error) {
+	strct := MakeStruct(0)
+	c, err := jr.nextData()
+	if err != nil {
+		return nil, err
+	}
+	if c == '}' {
+		return strct, nil
+	}
+	for {
+		if c != '"' {
+			return nil, jr.error("Expected a string key in JSON object")
+		}
+		name, err := jr.readString()
+		if err != nil {
+			return nil, err
+		}
+		if c, err = jr.nextData(); err != nil {
+			return nil, err
+		}
+		if c != ':' {
+			return nil, jr.error("Expected ':' after key in JSON object")
+		}
+		if c, err = jr.nextData(); err != nil {
+			return nil, err
+		}
+		val, err := jr.readValue(c)
+		if err != nil {
+			return nil, err
+		}
+		Put(strct, jr.key(name), val)
+		if c, err = jr.nextData(); err != nil {
+			return nil, err
+		}
+		if c == '}' {
+			return strct, nil
+		}
+		if c != ',' {
+			return nil, jr.error("Expected ',' or '}' in JSON object")
+		}
+		if c, err = jr.nextData(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// key - return the struct key for the name of a JSON object member
+func (jr *JSONReader) key(name string) *Object {
+	if jr.keys == KeywordType && isKeywordName(name) {
+		return Intern(name + ":")
+	}
+	return String(name)
+}
+
+// isKeywordName - return true if the name followed by a colon reads back as a keyword
+func isKeywordName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if isWhitespace(name[i]) || isDelimiter(name[i]) || name[i] == ';' {
+			return false
+		}
+	}
+	return parseNumber(name) == nil
+}
+
+func (jr *JSONReader) readArray() (*Object, error) {
+	var elements []*Object
+	c, err := jr.nextData()
+	if err != nil {
+		return nil, err
+	}
+	if c == ']' {
+		return Vector(), nil
+	}
+	for {
+		val, err := jr.readValue(c)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, val)
+		if c, err = jr.nextData(); err != nil {
+			return nil, err
+		}
+		if c == ']' {
+			return Vector(elements...), nil
+		}
+		if c != ',' {
+			return nil, jr.error("Expected ',' or ']' in JSON array")
+		}
+		if c, err = jr.nextData(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (jr *JSONReader) readString() (string, error) {
+	var buf []byte
+	for {
+		c, err := jr.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '"':
+			return string(buf), nil
+		case c < 0x20:
+			return "", jr.error("Control character in JSON string")
+		case c != '\\':
+			buf = append(buf, c)
+			continue
+		}
+		if c, err = jr.next(); err != nil {
+			return "", err
+		}
+		switch c {
+		case '"', '\\', '/':
+			buf = append(buf, c)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, err := jr.readHex()
+			if err != nil {
+				return "", err
+			}
+			if utf16.IsSurrogate(r) {
+				if c, err := jr.next(); err != nil || c != '\\' {
+					return "", jr.error("Expected the low surrogate of a JSON \\u escape")
+				}
+				if c, err := jr.next(); err != nil || c != 'u' {
+					return "", jr.error("Expected the low surrogate of a JSON \\u escape")
+				}
+				r2, err := jr.readHex()
+				if err != nil {
+					return "", err
+				}
+				r = utf16.DecodeRune(r, r2)
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			return "", jr.error("Bad escape in JSON string: \\", string(c))
+		}
+	}
+}
+
+func (jr *JSONReader) readHex() (rune, error) {
+	var hex [4]byte
+	for i := range hex {
+		c, err := jr.next()
+		if err != nil {
+			return 0, err
+		}
+		hex[i] = c
+	}
+	n, err := strconv.ParseUint(string(hex[:]), 16, 32)
+	if err != nil {
+		return 0, jr.error("Bad \\u escape in JSON string: \\u", string(hex[:]))
+	}
+	return rune(n), nil
+}
+
+func (jr *JSONReader) readNumber(first byte) (*Object, error) {
+	buf := []byte{first}
+	for {
+		c, err := jr.dr.getChar()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (c < '0' || c > '9') && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			jr.dr.ungetChar()
+			break
+		}
+		buf = append(buf, c)
+	}
+	s := string(buf)
+	if !isJSONNumber(s) {
+		return nil, jr.error("Bad number in JSON: ", s)
+	}
+	if n := parseInteger(s); n != nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, jr.error("Bad number in JSON: ", s)
+	}
+	return Number(f), nil
+}
+
+// isJSONNumber - return true if the string follows the JSON grammar for numbers, which is stricter than Go's
+func isJSONNumber(s string) bool {
+	digits := func(i int) int {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	if i < len(s) && s[i] == '0' {
+		i++
+	} else if j := digits(i); j > i {
+		i = j
+	} else {
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		j := digits(i + 1)
+		if j == i+1 {
+			return false
+		}
+		i = j
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		j := digits(i)
+		if j == i {
+			return false
+		}
+		i = j
+	}
+	return i == len(s)
+}
+
+func (jr *JSONReader) readLiteral(first byte) (*Object, error) {
+	buf := []byte{first}
+	for {
+		c, err := jr.dr.getChar()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c < 'a' || c > 'z' {
+			jr.dr.ungetChar()
+			break
+		}
+		buf = append(buf, c)
+	}
+	switch string(buf) {
+	case "null":
+		return Null, nil
+	case "true":
+		return True, nil
+	case "false":
+		return False, nil
+	}
+	return nil, jr.error("Unexpected literal in JSON: ", string(buf))
+}
+
+// jsonString - encode the string as a JSON string literal
+func jsonString(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			buf.WriteString("\\\"")
+		case '\\':
+			buf.WriteString("\\\\")
+		case '\n':
+			buf.WriteString("\\n")
+		case '\t':
+			buf.WriteString("\\t")
+		case '\r':
+			buf.WriteString("\\r")
+		case '\b':
+			buf.WriteString("\\b")
+		case '\f':
+			buf.WriteString("\\f")
+		default:
+			if c < 0x20 {
+				buf.WriteString("\\u00")
+				buf.WriteByte("0123456789abcdef"[c>>4])
+				buf.WriteByte("0123456789abcdef"[c&0xf])
+			} else {
+				buf.WriteRune(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// jsonNumber - encode the number as JSON, which has no representation for infinities and NaN. Rationals are
+// written as their float approximation.
+func jsonNumber(obj *Object) (string, error) {
+	if math.IsInf(obj.fval, 0) || math.IsNaN(obj.fval) {
+		return "", Error(ArgumentErrorKey, "Number cannot be described in JSON: ", obj)
+	}
+	if isRational(obj) {
+		return strconv.FormatFloat(obj.fval, 'g', -1, 64), nil
+	}
+	return obj.String(), nil
+}
@@ -0,0 +1,48 @@
+package vile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadJSONFile - json-read-file calls its function with each value of a JSON lines file, or returns the single
+// value of the file without one
+func TestReadJSONFile(t *testing.T) {
+	dir := t.TempDir()
+	lines := filepath.Join(dir, "lines.json")
+	if err := os.WriteFile(lines, []byte("{\"n\": 1}\n{\"n\": 2}\n\n{\"n\": 3}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	single := filepath.Join(dir, "single.json")
+	if err := os.WriteFile(single, []byte("[1, {\"a\": true}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRuntime(t)
+	total, err := evalString(rt, fmt.Sprintf(`
+		(var total 0)
+		(json-read-file %q (func (val) (set! total (+ total (n: val)))))
+		total`, lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(total, Number(6)) {
+		t.Errorf("the values of the lines add up to %v", total)
+	}
+	val, err := evalString(rt, fmt.Sprintf(`(json-write (json-read-file %q))`, single))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.text != `[1, {"a": true}]` {
+		t.Errorf("the file was read as %s", val.text)
+	}
+	_, err = evalString(rt, fmt.Sprintf(`(json-read-file %q)`, lines))
+	if e, ok := err.(*Object); !ok || !IsError(e) || errorKey(e) != SyntaxErrorKey {
+		t.Errorf("several values were read as one, the error is %v", err)
+	}
+	_, err = evalString(rt, fmt.Sprintf(`(json-read-file %q)`, filepath.Join(dir, "missing.json")))
+	if e, ok := err.(*Object); !ok || !IsError(e) || errorKey(e) != IOErrorKey {
+		t.Errorf("a missing file was read, the error is %v", err)
+	}
+}
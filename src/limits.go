@@ -0,0 +1,165 @@
+package vile
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+)
+
+// LimitErrorKey - for evaluations stopped by their context or by one of their Limits. These errors cannot be caught:
+// they pass through try forms, without running their finally blocks, and the *top-handler*.
+var LimitErrorKey = Intern("limit-error:")
+
+// Limits - bounds on the evaluations of a runtime view created by WithLimits. A zero field means no limit.
+type Limits struct {
+	MaxInstructions int64 // the number of vm instructions executed
+	MaxStackDepth   int   // the depth of nested, non tail, function calls
+	MaxAllocations  int64 // the number of call frames, closures, primitive results, and list, vector, struct and string elements created
+}
+
+// the primitives that build their result, charged for each of its elements: a list, vector or struct element, or
+// a byte of a string. range and make_struct are charged before they build it.
+var buildingPrimitives = map[string]bool{
+	"list": true, "concat": true, "map": true, "filter": true, "sort": true, "take": true, "zip": true,
+	"reverse_list": true, "to_vector": true, "to_struct": true, "keys": true, "values": true,
+	"string-split": true, "string-join": true, "string-replace": true, "reverse_string": true, "to_string": true,
+	"format": true, "sprintf": true, "regex-find-all": true, "regex-split": true, "regex-replace": true,
+	"json-read": true, "json-write": true,
+}
+
+// the number of instructions a vm executes between checks of its limits, when it has no instruction limit closer
+const limitCheckInterval = 1024
+
+// limiter - the state of the limits of a runtime view. The counts are shared by all the vms running in the view,
+// including spawned ones, so they are updated atomically.
+type limiter struct {
+	ctx          context.Context
+	limits       Limits
+	instructions int64
+	allocations  int64
+}
+
+// WithLimits - return a view of the runtime whose evaluations are bounded by the context and the limits. The view
+// shares the globals, macros and constants of the runtime. Its counts accumulate over everything evaluated through
+// it, so create a new view for each evaluation to be bounded separately.
+func (rt *Runtime) WithLimits(ctx context.Context, limits Limits) *Runtime {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Runtime{environment: rt.environment, limiter: &limiter{ctx: ctx, limits: limits}}
+}
+
+// done - return a channel that is closed when the context of the runtime view is done, for the primitives that
+// block to wait on. It is nil, and never ready, without limits.
+func (rt *Runtime) done() <-chan struct{} {
+	if rt.limiter == nil {
+		return nil
+	}
+	return rt.limiter.ctx.Done()
+}
+
+// stopped - the error for an evaluation stopped by the context of its runtime view
+func (rt *Runtime) stopped() error {
+	return rt.limiter.stopped()
+}
+
+func (l *limiter) stopped() error {
+	return Error(LimitErrorKey, "evaluation stopped: ", l.ctx.Err().Error())
+}
+
+// allocate - charge the objects a primitive creates to the allocation limit of the runtime view, failing if that
+// exceeds it
+func (rt *Runtime) allocate(n int64) error {
+	l := rt.limiter
+	if l == nil || l.limits.MaxAllocations <= 0 {
+		return nil
+	}
+	if atomic.AddInt64(&l.allocations, n) > l.limits.MaxAllocations {
+		return Error(LimitErrorKey, "allocation limit exceeded: ", l.limits.MaxAllocations)
+	}
+	return nil
+}
+
+// elementCount - the number of elements of a list, vector or struct, or of bytes of a string
+func elementCount(obj *Object) int64 {
+	switch obj.Type {
+	case ListType:
+		return int64(ListLength(obj))
+	case VectorType:
+		return int64(len(obj.elements))
+	case StructType:
+		return int64(StructLength(obj))
+	case StringType:
+		return int64(len(obj.text))
+	}
+	return 0
+}
+
+// checkLimits - account for the instructions executed and the objects allocated since the last check, and give the
+// vm the budget of instructions it may execute before it checks again. While profiling, the samples are taken here
+// too. Without limits or profiling, the budget never runs out.
+func (vm *vm) checkLimits(env *frame, pc int) error {
+	executed := vm.granted - vm.budget
+	if vm.profiler != nil {
+		vm.profile(executed, env, pc)
+	}
+	l := vm.limiter
+	if l == nil {
+		vm.granted = math.MaxInt
+		if vm.profiler != nil {
+			vm.granted = profileInterval
+		}
+		vm.budget = vm.granted
+		return nil
+	}
+	allocations := atomic.AddInt64(&l.allocations, vm.allocations)
+	vm.allocations = 0
+	instructions := atomic.AddInt64(&l.instructions, int64(executed))
+	vm.granted, vm.budget = 0, 0
+	select {
+	case <-l.ctx.Done():
+		return addContext(env, pc, l.stopped())
+	default:
+	}
+	if l.limits.MaxAllocations > 0 && allocations > l.limits.MaxAllocations {
+		return addContext(env, pc, Error(LimitErrorKey, "allocation limit exceeded: ", l.limits.MaxAllocations))
+	}
+	budget := int64(limitCheckInterval)
+	if vm.profiler != nil {
+		budget = profileInterval
+	}
+	if l.limits.MaxInstructions > 0 {
+		remaining := l.limits.MaxInstructions - instructions
+		if remaining <= 0 {
+			return addContext(env, pc, Error(LimitErrorKey, "instruction limit exceeded: ", l.limits.MaxInstructions))
+		}
+		if remaining < budget {
+			budget = remaining
+		}
+	}
+	vm.granted, vm.budget = int(budget), int(budget)
+	return nil
+}
+
+// countInstructions - account for the instructions executed since the last check, when the vm is done with the
+// code it executed in the frame
+func (vm *vm) countInstructions(env *frame) {
+	executed := vm.granted - vm.budget
+	vm.granted, vm.budget = 0, 0
+	if vm.profiler != nil {
+		vm.profile(executed, env, 0)
+	}
+	if vm.limiter != nil {
+		atomic.AddInt64(&vm.limiter.instructions, int64(executed))
+		atomic.AddInt64(&vm.limiter.allocations, vm.allocations)
+		vm.allocations = 0
+	}
+}
+
+// checkDepth - fail if the frame is nested deeper than the limit allows
+func (vm *vm) checkDepth(f *frame, env *frame, pc int) error {
+	if vm.limiter != nil && vm.limiter.limits.MaxStackDepth > 0 && f.depth > vm.limiter.limits.MaxStackDepth {
+		return addContext(env, pc, Error(LimitErrorKey, "stack depth limit exceeded: ", vm.limiter.limits.MaxStackDepth))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package vile
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// evalLimited - evaluate the source in a view of the runtime with the context and limits, returning the error
+func evalLimited(t *testing.T, rt *Runtime, ctx context.Context, limits Limits, src string) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		_, err := evalString(rt.WithLimits(ctx, limits), src)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("evaluation was not stopped: %s", src)
+		return nil
+	}
+}
+
+func expectLimitError(t *testing.T, err error, src string) {
+	t.Helper()
+	e, ok := err.(*Object)
+	if !ok || !IsError(e) || errorKey(e) != LimitErrorKey {
+		t.Errorf("expected a limit-error: from %s, got %v", src, err)
+	}
+}
+
+// TestLimitErrorsAreNotCaught - limit errors pass through try forms and the top handler, including when they are
+// raised in a function called back by a primitive
+func TestLimitErrorsAreNotCaught(t *testing.T) {
+	rt := newTestRuntime(t)
+	_, err := evalString(rt, `
+		(fn spin (n) (spin (+ n 1)))
+		(var *top-handler* (func (e) "handled"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		`(spin 0)`,
+		`(try (spin 0) (catch (e) "caught"))`,
+		`(try (spin 0) (catch limit-error: (e) "caught") (finally "cleaned up"))`,
+		`(try (map (func (x) (spin x)) '(1 2)) (catch (e) "caught"))`,
+		`(map (func (x) (try (spin x) (catch (e) "caught"))) '(1 2))`,
+	} {
+		err := evalLimited(t, rt, context.Background(), Limits{MaxInstructions: 100000}, src)
+		expectLimitError(t, err, src)
+	}
+}
+
+// TestBlockedPrimitivesAreStopped - the primitives that wait on channels and tasks return when the context is done
+func TestBlockedPrimitivesAreStopped(t *testing.T) {
+	rt := newTestRuntime(t)
+	_, err := evalString(rt, `(var blocked (chan))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		`(recv blocked)`,
+		`(send blocked 1)`,
+		`(await (spawn (func () (recv blocked))))`,
+		`(select (recv blocked (v) v))`,
+		`(try (recv blocked) (catch (e) "caught"))`,
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := evalLimited(t, rt, ctx, Limits{}, src)
+		cancel()
+		expectLimitError(t, err, src)
+	}
+}
+
+// TestPrimitivesChargeTheirElements - the primitives that build large results are charged for each of their
+// elements, and range and make_struct before they build them
+func TestPrimitivesChargeTheirElements(t *testing.T) {
+	rt := newTestRuntime(t)
+	for _, src := range []string{
+		`(car (range 5000000))`,
+		`(car (range 0 1e15 0.5))`,
+		`(make_struct 1000000000)`,
+		`(len (string-join (map to_string (range 200)) "......"))`,
+		`(try (car (to_vector (range 600))) (catch (e) "caught"))`,
+	} {
+		err := evalLimited(t, rt, context.Background(), Limits{MaxAllocations: 1000}, src)
+		expectLimitError(t, err, src)
+	}
+	src := `(car (range 100))`
+	if err := evalLimited(t, rt, context.Background(), Limits{MaxAllocations: 1000}, src); err != nil {
+		t.Errorf("%s failed within the limit: %v", src, err)
+	}
+}
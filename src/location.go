@@ -0,0 +1,148 @@
+package vile
+
+import (
+	"fmt"
+	"sort"
+)
+
+// location - where in the source a list was read from. The reader stores it in the Value of the first cell of
+// each list it produces, and the compiler carries it through to the code as a pc-to-line table.
+type location struct {
+	file   string
+	line   int
+	column int
+}
+
+func (loc *location) String() string {
+	if loc.file == "" {
+		return fmt.Sprintf("%d:%d", loc.line, loc.column)
+	}
+	return fmt.Sprintf("%s:%d:%d", loc.file, loc.line, loc.column)
+}
+
+// listLocation - return where the list was read from, or nil if it was not read from source
+func listLocation(obj *Object) *location {
+	if obj.Type == ListType {
+		if loc, ok := obj.Value.(*location); ok {
+			return loc
+		}
+	}
+	return nil
+}
+
+// inheritLocation - return the expansion of the form, located where the form was if it has no location of its own.
+// The first cell is copied rather than modified, the expansion may be shared.
+func inheritLocation(expanded *Object, form *Object) *Object {
+	loc := listLocation(form)
+	if loc == nil || expanded.Type != ListType || expanded == EmptyList || listLocation(expanded) != nil {
+		return expanded
+	}
+	located := Cons(expanded.car, expanded.cdr)
+	located.Value = loc
+	return located
+}
+
+// withLocation - record the location in a copy of the error, unless it already has a context
+func withLocation(err error, loc *location) error {
+	if loc != nil {
+		if e, ok := err.(*Object); ok && e.Type == ErrorType && e.Value == nil {
+			return withContext(e, errorContext("", loc, nil))
+		}
+	}
+	return err
+}
+
+// codeLocation - the location of the instructions of a code object starting at pc, up to the next entry
+type codeLocation struct {
+	pc  int
+	loc *location
+}
+
+// markLocation - attribute the instructions emitted from now on to the location, and return the previous one,
+// to be restored when the form at the location has been compiled
+func (code *Code) markLocation(loc *location) *location {
+	prev := code.location
+	if loc == nil || loc == prev {
+		return prev
+	}
+	pc := len(code.ops)
+	if n := len(code.locations); n > 0 && code.locations[n-1].pc == pc {
+		code.locations[n-1].loc = loc
+	} else {
+		code.locations = append(code.locations, codeLocation{pc, loc})
+	}
+	code.location = loc
+	return prev
+}
+
+// locationOf - return the location of the instruction at the pc, or nil if it is not known
+func (code *Code) locationOf(pc int) *location {
+	i := sort.Search(len(code.locations), func(i int) bool { return code.locations[i].pc > pc })
+	if i == 0 {
+		return nil
+	}
+	return code.locations[i-1].loc
+}
+
+// definition - return the location of the form that defined the function, or for top level code, that of its first
+// instruction. It is nil if neither is known.
+func (code *Code) definition() *location {
+	if code.defined != nil {
+		return code.defined
+	}
+	if len(code.locations) > 0 {
+		return code.locations[0].loc
+	}
+	return nil
+}
+
+// the number of callers listed in the trace of an error
+const maxTraceLength = 10
+
+// where - describe the position of the pc in the code of the frame
+func (f *frame) where(pc int) string {
+	name := f.code.name
+	loc := f.code.locationOf(pc)
+	switch {
+	case loc == nil && name == "":
+		return "at top level"
+	case loc == nil:
+		return "in " + name
+	case name == "":
+		return "at " + loc.String()
+	default:
+		return "in " + name + " at " + loc.String()
+	}
+}
+
+// stackTrace - describe the calls that are pending in the frame, innermost first
+func stackTrace(env *frame) []*Object {
+	var trace []*Object
+	for f := env; f != nil && f.previous != nil && f.ops != nil; f = f.previous {
+		caller := f.previous
+		if caller.code == nil {
+			break
+		}
+		if len(trace) == maxTraceLength {
+			trace = append(trace, String("..."))
+			break
+		}
+		trace = append(trace, String(caller.where(f.pc-1)))
+	}
+	return trace
+}
+
+// ErrorReport - return the description of the error followed by its stack trace, one caller per line
+func ErrorReport(err error) string {
+	s := err.Error()
+	if e, ok := theError(err); ok {
+		if ctx := ErrorContext(e); ctx != Null {
+			if trace := structGet(ctx, Intern("trace:")); IsVector(trace) {
+				for _, caller := range trace.elements {
+					s += "\n    " + caller.text
+				}
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,660 @@
+package vile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+)
+
+/*
+ * The binary module format (.lvm)
+ *
+ *   header:    "LVM" 0x00, then the format version as a big endian uint16
+ *   constants: a count, then each constant. Code objects are constants too, and they
+ *              always follow the constants their instructions refer to
+ *   thunks:    a count, then the constant index of each top level code object, in load order
+ *   trailer:   the crc32 (IEEE) checksum of everything before it, big endian
+ *
+ * Instruction operands that refer to the constants table are rewritten to refer to the
+ * constants of the module, and are mapped back into the runtime's constants when loaded.
+ * Each code object ends with its pc-to-line table, whose file names are string constants,
+ * and the location of its definition, if known.
+ * Symbols that are not interned, such as those renamed by macro expansions, are numbered
+ * within the module, so each decodes to a single new symbol, distinct from every other.
+ */
+
+var lvmMagic = []byte{'L', 'V', 'M', 0}
+
+const lvmVersion = 7
+
+const ( /* tags for the objects in the constants of a module */
+	lvmNull = iota
+	lvmTrue
+	lvmFalse
+	lvmCharacter
+	lvmNumber
+	lvmString
+	lvmSymbol // symbols, keywords, and types, all interned by name
+	lvmList
+	lvmVector
+	lvmStruct
+	lvmInstance
+	lvmCode
+	lvmInteger    // exact integers that fit in 64 bits
+	lvmExact      // other exact numbers, as their text
+	lvmPrimitive  // primitive functions, by name, as the optimizer makes them literals
+	lvmUninterned // symbols that are not interned, such as gensyms, by their number in the module and their name
+	lvmReference  // references to globals made by macro expansions, by the name of the global, see globalName
+)
+
+// IsLVM - return true if the data looks like a binary compiled module
+func IsLVM(data []byte) bool {
+	return len(data) >= len(lvmMagic) && bytes.Equal(data[:len(lvmMagic)], lvmMagic)
+}
+
+type lvmEncoder struct {
+	buf    bytes.Buffer
+	pool   []*Object
+	poolId map[*Object]int
+	files  map[string]*Object // the constants holding the file names of the locations
+	// the uninterned symbols, numbered so that each decodes to a single symbol, distinct from all others
+	uninterned map[*Object]int
+}
+
+// EncodeLVM - encode the top level code objects (as returned by Compile) into the binary module format
+func EncodeLVM(thunks []*Object) ([]byte, error) {
+	enc := &lvmEncoder{poolId: make(map[*Object]int), files: make(map[string]*Object), uninterned: make(map[*Object]int)}
+	var roots []int
+	for _, thunk := range thunks {
+		if !IsCode(thunk) {
+			return nil, Error(ArgumentErrorKey, "Not a <code> object: ", thunk)
+		}
+		roots = append(roots, enc.addCode(thunk))
+	}
+	enc.buf.Write(lvmMagic)
+	var version [2]byte
+	binary.BigEndian.PutUint16(version[:], lvmVersion)
+	enc.buf.Write(version[:])
+	enc.putInt(len(enc.pool))
+	for _, obj := range enc.pool {
+		var err error
+		if IsCode(obj) {
+			err = enc.putCode(obj.code)
+		} else {
+			err = enc.putObject(obj)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	enc.putInt(len(roots))
+	for _, idx := range roots {
+		enc.putInt(idx)
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(enc.buf.Bytes()))
+	enc.buf.Write(sum[:])
+	return enc.buf.Bytes(), nil
+}
+
+func (enc *lvmEncoder) add(obj *Object) int {
+	idx, ok := enc.poolId[obj]
+	if !ok {
+		idx = len(enc.pool)
+		enc.pool = append(enc.pool, obj)
+		enc.poolId[obj] = idx
+	}
+	return idx
+}
+
+func (enc *lvmEncoder) addCode(obj *Object) int {
+	if idx, ok := enc.poolId[obj]; ok {
+		return idx
+	}
+	ops := obj.code.ops
+	for pc := 0; pc < len(ops); pc += instructionLength(ops[pc]) {
+		if hasConstantOperand(ops[pc]) {
+			c := obj.code.rt.getConstant(ops[pc+1])
+			if IsCode(c) {
+				enc.addCode(c)
+			} else {
+				enc.add(c)
+			}
+		}
+	}
+	for _, l := range obj.code.locations {
+		enc.addFile(l.loc.file)
+	}
+	if obj.code.defined != nil {
+		enc.addFile(obj.code.defined.file)
+	}
+	return enc.add(obj)
+}
+
+func (enc *lvmEncoder) addFile(file string) {
+	obj, ok := enc.files[file]
+	if !ok {
+		obj = String(file)
+		enc.files[file] = obj
+	}
+	enc.add(obj)
+}
+
+func (enc *lvmEncoder) putInt(n int) {
+	enc.putInt64(int64(n))
+}
+
+func (enc *lvmEncoder) putInt64(n int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	enc.buf.Write(tmp[:binary.PutVarint(tmp[:], n)])
+}
+
+func (enc *lvmEncoder) putString(s string) {
+	enc.putInt(len(s))
+	enc.buf.WriteString(s)
+}
+
+func (enc *lvmEncoder) putObjects(objs []*Object) error {
+	if objs == nil {
+		enc.putInt(-1)
+		return nil
+	}
+	enc.putInt(len(objs))
+	for _, o := range objs {
+		err := enc.putObject(o)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *lvmEncoder) putCode(code *Code) error {
+	enc.buf.WriteByte(lvmCode)
+	enc.putString(code.name)
+	enc.putInt(code.argc)
+	err := enc.putObjects(code.defaults)
+	if err != nil {
+		return err
+	}
+	err = enc.putObjects(code.keys)
+	if err != nil {
+		return err
+	}
+	ops := code.ops
+	enc.putInt(len(ops))
+	for pc := 0; pc < len(ops); {
+		op := ops[pc]
+		n := instructionLength(op)
+		enc.putInt(op)
+		for i := 1; i < n; i++ {
+			if i == 1 && hasConstantOperand(op) {
+				enc.putInt(enc.poolId[code.rt.getConstant(ops[pc+1])])
+			} else {
+				enc.putInt(ops[pc+i])
+			}
+		}
+		pc += n
+	}
+	enc.putInt(len(code.locations))
+	for _, l := range code.locations {
+		enc.putInt(l.pc)
+		enc.putLocation(l.loc)
+	}
+	if code.defined == nil {
+		enc.buf.WriteByte(0)
+	} else {
+		enc.buf.WriteByte(1)
+		enc.putLocation(code.defined)
+	}
+	return nil
+}
+
+func (enc *lvmEncoder) putLocation(loc *location) {
+	enc.putInt(enc.poolId[enc.files[loc.file]])
+	enc.putInt(loc.line)
+	enc.putInt(loc.column)
+}
+
+func (enc *lvmEncoder) putObject(obj *Object) error {
+	switch obj.Type {
+	case NullType:
+		enc.buf.WriteByte(lvmNull)
+	case BooleanType:
+		if obj == True {
+			enc.buf.WriteByte(lvmTrue)
+		} else {
+			enc.buf.WriteByte(lvmFalse)
+		}
+	case CharacterType:
+		enc.buf.WriteByte(lvmCharacter)
+		enc.putInt(int(obj.fval))
+	case NumberType:
+		if isFixnum(obj) {
+			enc.buf.WriteByte(lvmInteger)
+			enc.putInt64(obj.ival)
+		} else if IsExact(obj) {
+			enc.buf.WriteByte(lvmExact)
+			enc.putString(obj.String())
+		} else {
+			var tmp [8]byte
+			binary.BigEndian.PutUint64(tmp[:], math.Float64bits(obj.fval))
+			enc.buf.WriteByte(lvmNumber)
+			enc.buf.Write(tmp[:])
+		}
+	case StringType:
+		enc.buf.WriteByte(lvmString)
+		enc.putString(obj.text)
+	case SymbolType, KeywordType, TypeType:
+		if obj.Type == SymbolType && globalName(obj) != obj {
+			enc.buf.WriteByte(lvmReference)
+			enc.putString(globalName(obj).text)
+		} else if !isInterned(obj) {
+			id, ok := enc.uninterned[obj]
+			if !ok {
+				id = len(enc.uninterned)
+				enc.uninterned[obj] = id
+			}
+			enc.buf.WriteByte(lvmUninterned)
+			enc.putInt(id)
+			enc.putString(obj.text)
+		} else {
+			enc.buf.WriteByte(lvmSymbol)
+			enc.putString(obj.text)
+		}
+	case ListType:
+		enc.buf.WriteByte(lvmList)
+		enc.putInt(ListLength(obj))
+		for obj != EmptyList {
+			err := enc.putObject(obj.car)
+			if err != nil {
+				return err
+			}
+			obj = obj.cdr
+		}
+	case VectorType:
+		enc.buf.WriteByte(lvmVector)
+		return enc.putObjects(obj.elements)
+	case StructType:
+		enc.buf.WriteByte(lvmStruct)
+		enc.putInt(obj.bindings.size())
+		for _, e := range obj.bindings.entries {
+			err := enc.putObject(e.key.toObject())
+			if err != nil {
+				return err
+			}
+			err = enc.putObject(e.value)
+			if err != nil {
+				return err
+			}
+		}
+	case PersistentStructType, PersistentVectorType: // as an instance of their plain counterpart, see Instance
+		enc.buf.WriteByte(lvmInstance)
+		enc.putString(obj.Type.text)
+		if obj.Type == PersistentStructType {
+			return enc.putObject(obj.Value.(*pstruct).toStruct())
+		}
+		return enc.putObject(VectorFromElementsNoCopy(obj.Value.(*pvector).elements()))
+	case FunctionType:
+		if obj.primitive == nil {
+			return Error(ArgumentErrorKey, "Cannot encode object in compiled module: ", obj)
+		}
+		enc.buf.WriteByte(lvmPrimitive)
+		enc.putString(obj.primitive.name)
+	default:
+		if !IsInstance(obj) {
+			return Error(ArgumentErrorKey, "Cannot encode object in compiled module: ", obj)
+		}
+		enc.buf.WriteByte(lvmInstance)
+		enc.putString(obj.Type.text)
+		return enc.putObject(obj.car)
+	}
+	return nil
+}
+
+type lvmDecoder struct {
+	rt         *Runtime
+	data       []byte
+	pos        int
+	pool       []*Object
+	uninterned map[int]*Object // the uninterned symbols, by their number in the module
+}
+
+// DecodeLVM - decode a binary compiled module, returning its top level code objects in load order
+func (rt *Runtime) DecodeLVM(data []byte) ([]*Object, error) {
+	if !IsLVM(data) || len(data) < len(lvmMagic)+6 {
+		return nil, Error(IOErrorKey, "Not a compiled vile module")
+	}
+	end := len(data) - 4
+	if crc32.ChecksumIEEE(data[:end]) != binary.BigEndian.Uint32(data[end:]) {
+		return nil, Error(IOErrorKey, "Compiled module is corrupt (checksum mismatch)")
+	}
+	version := binary.BigEndian.Uint16(data[len(lvmMagic):])
+	if version != lvmVersion {
+		return nil, Error(IOErrorKey, "Unsupported compiled module version: ", int(version))
+	}
+	dec := &lvmDecoder{rt: rt, data: data[:end], pos: len(lvmMagic) + 2, uninterned: make(map[int]*Object)}
+	count, err := dec.getInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		var obj *Object
+		if dec.pos < len(dec.data) && dec.data[dec.pos] == lvmCode {
+			dec.pos++
+			obj, err = dec.getCode()
+		} else {
+			obj, err = dec.getObject()
+		}
+		if err != nil {
+			return nil, err
+		}
+		dec.pool = append(dec.pool, obj)
+	}
+	count, err = dec.getInt()
+	if err != nil {
+		return nil, err
+	}
+	thunks := make([]*Object, 0, count)
+	for i := 0; i < count; i++ {
+		obj, err := dec.getPoolObject()
+		if err != nil {
+			return nil, err
+		}
+		if !IsCode(obj) {
+			return nil, dec.corrupt()
+		}
+		thunks = append(thunks, obj)
+	}
+	return thunks, nil
+}
+
+func (dec *lvmDecoder) corrupt() error {
+	return Error(IOErrorKey, "Compiled module is corrupt at offset ", dec.pos)
+}
+
+func (dec *lvmDecoder) getInt() (int, error) {
+	n, err := dec.getInt64()
+	return int(n), err
+}
+
+func (dec *lvmDecoder) getInt64() (int64, error) {
+	n, size := binary.Varint(dec.data[dec.pos:])
+	if size <= 0 {
+		return 0, dec.corrupt()
+	}
+	dec.pos += size
+	return n, nil
+}
+
+func (dec *lvmDecoder) getString() (string, error) {
+	n, err := dec.getInt()
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || dec.pos+n > len(dec.data) {
+		return "", dec.corrupt()
+	}
+	s := string(dec.data[dec.pos : dec.pos+n])
+	dec.pos += n
+	return s, nil
+}
+
+func (dec *lvmDecoder) getPoolObject() (*Object, error) {
+	idx, err := dec.getInt()
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 || idx >= len(dec.pool) {
+		return nil, dec.corrupt()
+	}
+	return dec.pool[idx], nil
+}
+
+func (dec *lvmDecoder) getObjects() ([]*Object, error) {
+	n, err := dec.getInt()
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	objs := make([]*Object, 0, n)
+	for i := 0; i < n; i++ {
+		o, err := dec.getObject()
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, o)
+	}
+	return objs, nil
+}
+
+func (dec *lvmDecoder) getCode() (*Object, error) {
+	name, err := dec.getString()
+	if err != nil {
+		return nil, err
+	}
+	argc, err := dec.getInt()
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := dec.getObjects()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := dec.getObjects()
+	if err != nil {
+		return nil, err
+	}
+	count, err := dec.getInt()
+	if err != nil {
+		return nil, err
+	}
+	result := dec.rt.makeCode(argc, defaults, keys, name)
+	ops := make([]int, 0, count)
+	for len(ops) < count {
+		op, err := dec.getInt()
+		if err != nil {
+			return nil, err
+		}
+		if op < 0 || op >= opcodeCount {
+			return nil, dec.corrupt()
+		}
+		ops = append(ops, op)
+		n := instructionLength(op)
+		for i := 1; i < n; i++ {
+			if i == 1 && hasConstantOperand(op) {
+				c, err := dec.getPoolObject()
+				if err != nil {
+					return nil, err
+				}
+				ops = append(ops, dec.rt.putConstant(c))
+			} else {
+				arg, err := dec.getInt()
+				if err != nil {
+					return nil, err
+				}
+				ops = append(ops, arg)
+			}
+		}
+	}
+	result.code.ops = ops
+	count, err = dec.getInt()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		var l codeLocation
+		if l.pc, err = dec.getInt(); err != nil {
+			return nil, err
+		}
+		if l.loc, err = dec.getLocation(); err != nil {
+			return nil, err
+		}
+		result.code.locations = append(result.code.locations, l)
+	}
+	if dec.pos >= len(dec.data) {
+		return nil, dec.corrupt()
+	}
+	defined := dec.data[dec.pos]
+	dec.pos++
+	switch defined {
+	case 0:
+	case 1:
+		if result.code.defined, err = dec.getLocation(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, dec.corrupt()
+	}
+	return result, nil
+}
+
+func (dec *lvmDecoder) getLocation() (*location, error) {
+	file, err := dec.getPoolObject()
+	if err != nil {
+		return nil, err
+	}
+	if !IsString(file) {
+		return nil, dec.corrupt()
+	}
+	loc := &location{file: file.text}
+	if loc.line, err = dec.getInt(); err != nil {
+		return nil, err
+	}
+	if loc.column, err = dec.getInt(); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+func (dec *lvmDecoder) getObject() (*Object, error) {
+	if dec.pos >= len(dec.data) {
+		return nil, dec.corrupt()
+	}
+	tag := dec.data[dec.pos]
+	dec.pos++
+	switch tag {
+	case lvmNull:
+		return Null, nil
+	case lvmTrue:
+		return True, nil
+	case lvmFalse:
+		return False, nil
+	case lvmCharacter:
+		c, err := dec.getInt()
+		if err != nil {
+			return nil, err
+		}
+		return Character(rune(c)), nil
+	case lvmNumber:
+		if dec.pos+8 > len(dec.data) {
+			return nil, dec.corrupt()
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(dec.data[dec.pos:]))
+		dec.pos += 8
+		return Number(f), nil
+	case lvmInteger:
+		n, err := dec.getInt64()
+		if err != nil {
+			return nil, err
+		}
+		return Int(n), nil
+	case lvmExact:
+		s, err := dec.getString()
+		if err != nil {
+			return nil, err
+		}
+		num := parseNumber(s)
+		if num == nil {
+			return nil, dec.corrupt()
+		}
+		return num, nil
+	case lvmString:
+		s, err := dec.getString()
+		if err != nil {
+			return nil, err
+		}
+		return String(s), nil
+	case lvmPrimitive:
+		s, err := dec.getString()
+		if err != nil {
+			return nil, err
+		}
+		prim := dec.rt.primitiveNamed(s)
+		if prim == nil {
+			return nil, Error(IOErrorKey, "Compiled module refers to an unknown primitive: ", s)
+		}
+		return &Object{Type: FunctionType, primitive: prim}, nil
+	case lvmSymbol:
+		s, err := dec.getString()
+		if err != nil || !IsValidSymbolName(s) {
+			return nil, dec.corrupt()
+		}
+		return Intern(s), nil
+	case lvmUninterned:
+		id, err := dec.getInt()
+		if err != nil {
+			return nil, err
+		}
+		s, err := dec.getString()
+		if err != nil || !IsValidSymbolName(s) {
+			return nil, dec.corrupt()
+		}
+		sym, ok := dec.uninterned[id]
+		if !ok {
+			sym = &Object{Type: SymbolType, text: s}
+			dec.uninterned[id] = sym
+		}
+		return sym, nil
+	case lvmReference:
+		s, err := dec.getString()
+		if err != nil || !IsValidSymbolName(s) {
+			return nil, dec.corrupt()
+		}
+		return globalReference(Intern(s)), nil
+	case lvmList:
+		elements, err := dec.getObjects()
+		if err != nil {
+			return nil, err
+		}
+		return ListFromValues(elements), nil
+	case lvmVector:
+		elements, err := dec.getObjects()
+		if err != nil {
+			return nil, err
+		}
+		return VectorFromElementsNoCopy(elements), nil
+	case lvmStruct:
+		n, err := dec.getInt()
+		if err != nil {
+			return nil, err
+		}
+		strct := MakeStruct(n)
+		for i := 0; i < n; i++ {
+			k, err := dec.getObject()
+			if err != nil {
+				return nil, err
+			}
+			v, err := dec.getObject()
+			if err != nil {
+				return nil, err
+			}
+			if !IsValidStructKey(k) {
+				return nil, dec.corrupt()
+			}
+			Put(strct, k, v)
+		}
+		return strct, nil
+	case lvmInstance:
+		name, err := dec.getString()
+		if err != nil || !IsValidTypeName(name) {
+			return nil, dec.corrupt()
+		}
+		val, err := dec.getObject()
+		if err != nil {
+			return nil, err
+		}
+		return Instance(Intern(name), val)
+	}
+	return nil, dec.corrupt()
+}
@@ -0,0 +1,47 @@
+package vile
+
+import (
+	"testing"
+)
+
+// TestLVMUninternedSymbols - a gensym in a compiled module decodes to a single symbol, shared by the code objects
+// that refer to it, and distinct from the interned symbol of the same name
+func TestLVMUninternedSymbols(t *testing.T) {
+	older := newTestRuntime(t)
+	sym := Gensym("hidden")
+	var thunks []*Object
+	for _, expr := range []*Object{
+		List(Intern("var"), sym, Number(41)),
+		List(Intern("var"), Intern("get-hidden"), List(Intern("func"), EmptyList, List(Intern("+"), sym, Number(1)))),
+	} {
+		code, err := older.Compile(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thunks = append(thunks, code)
+	}
+	data, err := EncodeLVM(thunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := newTestRuntime(t)
+	decoded, err := newer.DecodeLVM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, thunk := range decoded {
+		if _, err := newer.importCode(thunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := evalString(newer, "(get-hidden)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(result, Number(42)) {
+		t.Errorf("get-hidden returned %v", result)
+	}
+	if newer.IsDefined(Intern(sym.text)) {
+		t.Errorf("the interned symbol %s was defined by the module", sym.text)
+	}
+}
@@ -19,21 +19,29 @@ func (mac *macro) String() string {
 }
 
 // Macroexpand - return the expansion of all macros in the object and return the result
-func Macroexpand(expr *Object) (*Object, error) {
-	return macroexpandObject(expr)
+func (rt *Runtime) Macroexpand(expr *Object) (*Object, error) {
+	return rt.macroexpandObject(expr)
 }
 
-func macroexpandObject(expr *Object) (*Object, error) {
+func (rt *Runtime) macroexpandObject(expr *Object) (*Object, error) {
 	if IsList(expr) {
 		if expr != EmptyList {
-			return macroexpandList(expr)
+			return rt.macroexpandList(expr)
 		}
 	}
 	// check point println(expr)
 	return expr, nil
 }
 
-func macroexpandList(expr *Object) (*Object, error) {
+func (rt *Runtime) macroexpandList(expr *Object) (*Object, error) {
+	expanded, err := rt.macroexpandForm(expr)
+	if err != nil {
+		return nil, withLocation(err, listLocation(expr))
+	}
+	return inheritLocation(expanded, expr), nil
+}
+
+func (rt *Runtime) macroexpandForm(expr *Object) (*Object, error) {
 	if expr == EmptyList {
 		return expr, nil
 	}
@@ -41,7 +49,7 @@ func macroexpandList(expr *Object) (*Object, error) {
 	fn := Car(lst)
 	head := fn
 	if IsSymbol(fn) {
-		result, err := expandPrimitive(fn, lst)
+		result, err := rt.expandPrimitive(fn, lst)
 		if err != nil {
 			return nil, err
 		}
@@ -50,46 +58,97 @@ func macroexpandList(expr *Object) (*Object, error) {
 		}
 		head = fn
 	} else if IsList(fn) {
-		expanded, err := macroexpandList(fn)
+		expanded, err := rt.macroexpandList(fn)
 		if err != nil {
 			return nil, err
 		}
 		head = expanded
 	}
-	tail, err := expandSequence(Cdr(expr))
+	tail, err := rt.expandSequence(Cdr(expr))
 	if err != nil {
 		return nil, err
 	}
 	return Cons(head, tail), nil
 }
 
-func (mac *macro) expand(expr *Object) (*Object, error) {
-	expander := mac.expander
-	if expander.Type == FunctionType {
-		if expander.code != nil {
-			if expander.code.argc == 1 {
-				expanded, err := execCompileTime(expander.code, expr)
-				if err == nil {
-					if IsList(expanded) {
-						return macroexpandObject(expanded)
-					}
-					return expanded, err
-				}
-				return nil, err
-			}
-		} else if expander.primitive != nil {
-			args := []*Object{expr}
-			expanded, err := expander.primitive.fun(args)
-			if err == nil {
-				return macroexpandObject(expanded)
-			}
-			return nil, err
+// Macroexpand1 - return the result of applying the macro named by the head of the form once, without expanding
+// it further. Any other form is returned as is.
+func (rt *Runtime) Macroexpand1(expr *Object) (*Object, error) {
+	if IsList(expr) && expr != EmptyList && IsSymbol(Car(expr)) {
+		if mac := rt.GetMacro(Car(expr)); mac != nil {
+			return mac.apply(rt, expr)
 		}
 	}
-	return nil, Error(MacroErrorKey, "Bad macro expander function: ", expander)
+	return expr, nil
+}
+
+// TraceExpansions - turn the recording of macro applications on or off. While it is on, each application of a
+// macro in the runtime is recorded as a {macro: input: output:} struct. Turning it off returns the applications
+// recorded since it was turned on, in order.
+func (rt *Runtime) TraceExpansions(on bool) []*Object {
+	rt.expansionLock.Lock()
+	defer rt.expansionLock.Unlock()
+	steps := rt.expansions
+	rt.expansions = nil
+	rt.tracingExpansions = on
+	return steps
+}
+
+// MacroexpandTrace - expand all the macros in the object like Macroexpand, and return the result along with the
+// macro applications that produced it, as recorded by TraceExpansions
+func (rt *Runtime) MacroexpandTrace(expr *Object) (*Object, []*Object, error) {
+	rt.TraceExpansions(true)
+	expanded, err := rt.macroexpandObject(expr)
+	steps := rt.TraceExpansions(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return expanded, steps, nil
+}
+
+func (rt *Runtime) recordExpansion(name *Object, input *Object, output *Object) {
+	rt.expansionLock.Lock()
+	defer rt.expansionLock.Unlock()
+	if rt.tracingExpansions {
+		step := MakeStruct(3)
+		Put(step, Intern("macro:"), name)
+		Put(step, Intern("input:"), input)
+		Put(step, Intern("output:"), output)
+		rt.expansions = append(rt.expansions, step)
+	}
+}
+
+// apply - call the expander of the macro with the form, without expanding the result
+func (mac *macro) apply(rt *Runtime, expr *Object) (*Object, error) {
+	expander := mac.expander
+	var expanded *Object
+	var err error
+	switch {
+	case expander.Type != FunctionType:
+		return nil, Error(MacroErrorKey, "Bad macro expander function: ", expander)
+	case expander.code != nil && expander.code.argc == 1:
+		expanded, err = rt.execCompileTime(expander.code, expr)
+	case expander.primitive != nil:
+		expanded, err = expander.primitive.call(rt, []*Object{expr})
+	default:
+		return nil, Error(MacroErrorKey, "Bad macro expander function: ", expander)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rt.recordExpansion(mac.name, expr, expanded)
+	return expanded, nil
 }
 
-func expandSequence(seq *Object) (*Object, error) {
+func (mac *macro) expand(rt *Runtime, expr *Object) (*Object, error) {
+	expanded, err := mac.apply(rt, expr)
+	if err != nil {
+		return nil, err
+	}
+	return rt.macroexpandObject(expanded)
+}
+
+func (rt *Runtime) expandSequence(seq *Object) (*Object, error) {
 	var result []*Object
 	if seq == nil {
 		panic("Whoops: should be (), not nil!")
@@ -97,7 +156,7 @@ func expandSequence(seq *Object) (*Object, error) {
 	for seq != EmptyList {
 		item := Car(seq)
 		if IsList(item) {
-			expanded, err := macroexpandList(item)
+			expanded, err := rt.macroexpandList(item)
 			if err != nil {
 				return nil, err
 			}
@@ -115,17 +174,17 @@ func expandSequence(seq *Object) (*Object, error) {
 	return lst, nil
 }
 
-func expandIf(expr *Object) (*Object, error) {
+func (rt *Runtime) expandIf(expr *Object) (*Object, error) {
 	i := ListLength(expr)
 	if i == 4 {
-		tmp, err := expandSequence(Cdr(expr))
+		tmp, err := rt.expandSequence(Cdr(expr))
 		if err != nil {
 			return nil, err
 		}
 		return Cons(Car(expr), tmp), nil
 	} else if i == 3 {
 		tmp := List(Cadr(expr), Caddr(expr), Null)
-		tmp, err := expandSequence(tmp)
+		tmp, err := rt.expandSequence(tmp)
 		if err != nil {
 			return nil, err
 		}
@@ -142,17 +201,17 @@ func expandUndef(expr *Object) (*Object, error) {
 	return expr, nil
 }
 
-func expandDefn(expr *Object) (*Object, error) {
+func (rt *Runtime) expandDefn(expr *Object) (*Object, error) {
 	exprLen := ListLength(expr)
 	if exprLen >= 4 {
 		name := Cadr(expr)
 		if IsSymbol(name) {
 			args := Caddr(expr)
-			body, err := expandSequence(Cdddr(expr))
+			body, err := rt.expandSequence(Cdddr(expr))
 			if err != nil {
 				return nil, err
 			}
-			tmp, err := expandFn(Cons(Intern("func"), Cons(args, body)))
+			tmp, err := rt.expandFn(Cons(Intern("func"), Cons(args, body)))
 			if err != nil {
 				return nil, err
 			}
@@ -163,22 +222,30 @@ func expandDefn(expr *Object) (*Object, error) {
 	return nil, Error(SyntaxErrorKey, expr)
 }
 
-func expandDefmacro(expr *Object) (*Object, error) {
+func (rt *Runtime) expandDefmacro(expr *Object) (*Object, error) {
 	exprLen := ListLength(expr)
+	if exprLen == 3 && IsSymbol(Cadr(expr)) {
+		// (macro name expander), where the expander is a function of the whole form
+		expander, err := rt.macroexpandObject(Caddr(expr))
+		if err != nil {
+			return nil, err
+		}
+		return List(Car(expr), Cadr(expr), expander), nil
+	}
 	if exprLen >= 4 {
 		name := Cadr(expr)
 		if IsSymbol(name) {
 			args := Caddr(expr)
-			body, err := expandSequence(Cdddr(expr))
+			body, err := rt.expandSequence(Cdddr(expr))
 			if err != nil {
 				return nil, err
 			}
-			tmp, err := expandFn(Cons(Intern("func"), Cons(args, body)))
+			tmp, err := rt.expandFn(Cons(Intern("func"), Cons(args, body)))
 			if err != nil {
 				return nil, err
 			}
 			sym := Intern("expr")
-			tmp, err = expandFn(List(Intern("func"), List(sym), List(Intern("apply"), tmp, List(Intern("cdr"), sym))))
+			tmp, err = rt.expandFn(List(Intern("func"), List(sym), List(Intern("apply"), tmp, List(Intern("cdr"), sym))))
 			if err != nil {
 				return nil, err
 			}
@@ -188,7 +255,7 @@ func expandDefmacro(expr *Object) (*Object, error) {
 	return nil, Error(SyntaxErrorKey, expr)
 }
 
-func expandDef(expr *Object) (*Object, error) {
+func (rt *Runtime) expandDef(expr *Object) (*Object, error) {
 	exprLen := ListLength(expr)
 	if exprLen != 3 {
 		return nil, Error(SyntaxErrorKey, expr)
@@ -204,19 +271,19 @@ func expandDef(expr *Object) (*Object, error) {
 	if !IsList(body) {
 		return expr, nil
 	}
-	val, err := macroexpandList(body)
+	val, err := rt.macroexpandList(body)
 	if err != nil {
 		return nil, err
 	}
 	return List(Car(expr), name, val), nil
 }
 
-func expandFn(expr *Object) (*Object, error) {
+func (rt *Runtime) expandFn(expr *Object) (*Object, error) {
 	exprLen := ListLength(expr)
 	if exprLen < 3 {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
-	body, err := expandSequence(Cddr(expr))
+	body, err := rt.expandSequence(Cddr(expr))
 	if err != nil {
 		return nil, err
 	}
@@ -229,7 +296,7 @@ func expandFn(expr *Object) (*Object, error) {
 				if Caar(tmp) == Intern("macro") {
 					return nil, Error(MacroErrorKey, "macros can only be defined at top level")
 				}
-				def, err := expandDef(Car(tmp))
+				def, err := rt.expandDef(Car(tmp))
 				if err != nil {
 					return nil, err
 				}
@@ -238,7 +305,7 @@ func expandFn(expr *Object) (*Object, error) {
 			}
 			bindings = ReverseList(bindings)
 			tmp = Cons(Intern("letrec"), Cons(bindings, tmp))
-			tmp2, err := macroexpandList(tmp)
+			tmp2, err := rt.macroexpandList(tmp)
 			return List(Car(expr), Cadr(expr), tmp2), err
 		}
 	}
@@ -246,14 +313,49 @@ func expandFn(expr *Object) (*Object, error) {
 	return Cons(Car(expr), Cons(args, body)), nil
 }
 
-func expandSetBang(expr *Object) (*Object, error) {
+func (rt *Runtime) expandTry(expr *Object) (*Object, error) {
+	var result []*Object
+	result = append(result, Car(expr))
+	for forms := Cdr(expr); forms != EmptyList; forms = Cdr(forms) {
+		form := Car(forms)
+		if IsList(form) && (Car(form) == Intern("catch") || Car(form) == Intern("finally")) {
+			// the clause head, the catch keys, and the error variable are not expressions
+			var head []*Object
+			head = append(head, Car(form))
+			rest := Cdr(form)
+			if Car(form) == Intern("catch") {
+				if IsKeyword(Car(rest)) || IsVector(Car(rest)) {
+					head = append(head, Car(rest))
+					rest = Cdr(rest)
+				}
+				head = append(head, Car(rest))
+				rest = Cdr(rest)
+			}
+			body, err := rt.expandSequence(rest)
+			if err != nil {
+				return nil, err
+			}
+			form, _ = Concat(ListFromValues(head), body)
+		} else if IsList(form) {
+			expanded, err := rt.macroexpandList(form)
+			if err != nil {
+				return nil, err
+			}
+			form = expanded
+		}
+		result = append(result, form)
+	}
+	return ListFromValues(result), nil
+}
+
+func (rt *Runtime) expandSetBang(expr *Object) (*Object, error) {
 	exprLen := ListLength(expr)
 	if exprLen != 3 {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
 	var val = Caddr(expr)
 	if IsList(val) {
-		v, err := macroexpandList(val)
+		v, err := rt.macroexpandList(val)
 		if err != nil {
 			return nil, err
 		}
@@ -262,34 +364,36 @@ func expandSetBang(expr *Object) (*Object, error) {
 	return List(Car(expr), Cadr(expr), val), nil
 }
 
-func expandPrimitive(fn *Object, expr *Object) (*Object, error) {
+func (rt *Runtime) expandPrimitive(fn *Object, expr *Object) (*Object, error) {
 	switch fn {
 	case Intern("quote"):
 		return expr, nil
 	case Intern("do"):
-		return expandSequence(expr)
+		return rt.expandSequence(expr)
 	case Intern("if"):
-		return expandIf(expr)
+		return rt.expandIf(expr)
 	case Intern("var"):
-		return expandDef(expr)
+		return rt.expandDef(expr)
 	case Intern("undef"):
 		return expandUndef(expr)
 	case Intern("fn"):
-		return expandDefn(expr)
+		return rt.expandDefn(expr)
 	case Intern("macro"):
-		return expandDefmacro(expr)
+		return rt.expandDefmacro(expr)
 	case Intern("func"):
-		return expandFn(expr)
+		return rt.expandFn(expr)
 	case Intern("set!"):
-		return expandSetBang(expr)
+		return rt.expandSetBang(expr)
 	case Intern("lap"):
 		return expr, nil
 	case Intern("import"):
 		return expr, nil
+	case Intern("try"):
+		return rt.expandTry(expr)
 	default:
-		macro := GetMacro(fn)
+		macro := rt.GetMacro(fn)
 		if macro != nil {
-			tmp, err := macro.expand(expr)
+			tmp, err := macro.expand(rt, expr)
 			return tmp, err
 		}
 		return nil, nil
@@ -332,7 +436,7 @@ func crackLetrecBindings(bindings *Object, tail *Object) (*Object, *Object, bool
 	return ListFromValues(names), head, true
 }
 
-func expandLetrec(expr *Object) (*Object, error) {
+func (rt *Runtime) expandLetrec(expr *Object) (*Object, error) {
 	body := Cddr(expr)
 	if body == EmptyList {
 		return nil, Error(SyntaxErrorKey, expr)
@@ -345,7 +449,7 @@ func expandLetrec(expr *Object) (*Object, error) {
 	if !ok {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
-	code, err := macroexpandList(Cons(Intern("fn"), Cons(names, body)))
+	code, err := rt.macroexpandList(Cons(Intern("fn"), Cons(names, body)))
 	if err != nil {
 		return nil, err
 	}
@@ -353,7 +457,7 @@ func expandLetrec(expr *Object) (*Object, error) {
 	return Cons(code, values), nil
 }
 
-func crackLetBindings(bindings *Object) (*Object, *Object, bool) {
+func (rt *Runtime) crackLetBindings(bindings *Object) (*Object, *Object, bool) {
 	var names []*Object
 	var values []*Object
 	for bindings != EmptyList {
@@ -364,7 +468,7 @@ func crackLetBindings(bindings *Object) (*Object, *Object, bool) {
 				names = append(names, name)
 				tmp2 := Cdr(tmp)
 				if tmp2 != EmptyList {
-					val, err := macroexpandObject(Car(tmp2))
+					val, err := rt.macroexpandObject(Car(tmp2))
 					if err == nil {
 						values = append(values, val)
 						bindings = Cdr(bindings)
@@ -378,15 +482,15 @@ func crackLetBindings(bindings *Object) (*Object, *Object, bool) {
 	return ListFromValues(names), ListFromValues(values), true
 }
 
-func expandLet(expr *Object) (*Object, error) {
+func (rt *Runtime) expandLet(expr *Object) (*Object, error) {
 	if IsSymbol(Cadr(expr)) {
-		return expandNamedLet(expr)
+		return rt.expandNamedLet(expr)
 	}
 	bindings := Cadr(expr)
 	if !IsList(bindings) {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
-	names, values, ok := crackLetBindings(bindings)
+	names, values, ok := rt.crackLetBindings(bindings)
 	if !ok {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
@@ -394,29 +498,29 @@ func expandLet(expr *Object) (*Object, error) {
 	if body == EmptyList {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
-	code, err := macroexpandList(Cons(Intern("fn"), Cons(names, body)))
+	code, err := rt.macroexpandList(Cons(Intern("fn"), Cons(names, body)))
 	if err != nil {
 		return nil, err
 	}
 	return Cons(code, values), nil
 }
 
-func expandNamedLet(expr *Object) (*Object, error) {
+func (rt *Runtime) expandNamedLet(expr *Object) (*Object, error) {
 	name := Cadr(expr)
 	bindings := Caddr(expr)
 	if !IsList(bindings) {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
-	names, values, ok := crackLetBindings(bindings)
+	names, values, ok := rt.crackLetBindings(bindings)
 	if !ok {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
 	body := Cdddr(expr)
 	tmp := List(Intern("letrec"), List(List(name, Cons(Intern("func"), Cons(names, body)))), Cons(name, values))
-	return macroexpandList(tmp)
+	return rt.macroexpandList(tmp)
 }
 
-func nextCondClause(expr *Object, clauses *Object, count int) (*Object, error) {
+func (rt *Runtime) nextCondClause(expr *Object, clauses *Object, count int) (*Object, error) {
 	var result *Object
 	var err error
 	tmpsym := Intern("__tmp__")
@@ -461,7 +565,7 @@ func nextCondClause(expr *Object, clauses *Object, count int) (*Object, error) {
 			}
 		}
 	} else {
-		result, err = nextCondClause(expr, next, count-1)
+		result, err = rt.nextCondClause(expr, next, count-1)
 		if err != nil {
 			return nil, err
 		}
@@ -474,10 +578,10 @@ func nextCondClause(expr *Object, clauses *Object, count int) (*Object, error) {
 			result = List(ifsym, Car(clause0), Cons(dosym, Cdr(clause0)), result)
 		}
 	}
-	return macroexpandObject(result)
+	return rt.macroexpandObject(result)
 }
 
-func expandCond(expr *Object) (*Object, error) {
+func (rt *Runtime) expandCond(expr *Object) (*Object, error) {
 	i := ListLength(expr)
 	if i < 2 {
 		return nil, Error(SyntaxErrorKey, expr)
@@ -489,20 +593,20 @@ func expandCond(expr *Object) (*Object, error) {
 			expr = Cons(Intern("do"), Cdr(tmp))
 			tmp = List(Intern("if"), Car(tmp), expr)
 		}
-		return macroexpandObject(tmp)
+		return rt.macroexpandObject(tmp)
 	} else {
-		return nextCondClause(expr, Cdr(expr), i-1)
+		return rt.nextCondClause(expr, Cdr(expr), i-1)
 	}
 }
 
-func expandQuasiquote(expr *Object) (*Object, error) {
+func (rt *Runtime) expandQuasiquote(expr *Object) (*Object, error) {
 	if ListLength(expr) != 2 {
 		return nil, Error(SyntaxErrorKey, expr)
 	}
-	return expandQQ(Cadr(expr))
+	return rt.expandQQ(Cadr(expr))
 }
 
-func expandQQ(expr *Object) (*Object, error) {
+func (rt *Runtime) expandQQ(expr *Object) (*Object, error) {
 	switch expr.Type {
 	case ListType:
 		if expr == EmptyList {
@@ -513,16 +617,16 @@ func expandQQ(expr *Object) (*Object, error) {
 				if expr.cdr.cdr != EmptyList {
 					return nil, Error(SyntaxErrorKey, expr)
 				}
-				return macroexpandObject(expr.cdr.car)
+				return rt.macroexpandObject(expr.cdr.car)
 			} else if expr.car == UnquoteSymbolSplicing {
 				return nil, Error(MacroErrorKey, "unquote-splicing can only occur in the context of a list ")
 			}
 		}
-		tmp, err := expandQQList(expr)
+		tmp, err := rt.expandQQList(expr)
 		if err != nil {
 			return nil, err
 		}
-		return macroexpandObject(tmp)
+		return rt.macroexpandObject(tmp)
 	case SymbolType:
 		return List(Intern("quote"), expr), nil
 	default:
@@ -530,7 +634,7 @@ func expandQQ(expr *Object) (*Object, error) {
 	}
 }
 
-func expandQQList(lst *Object) (*Object, error) {
+func (rt *Runtime) expandQQList(lst *Object) (*Object, error) {
 	var tmp *Object
 	var err error
 	result := List(Intern("concat"))
@@ -542,7 +646,7 @@ func expandQQList(lst *Object) (*Object, error) {
 				return nil, Error(MacroErrorKey, "nested quasiquote not supported")
 			}
 			if Car(item) == UnquoteSymbol && ListLength(item) == 2 {
-				tmp, err = macroexpandObject(Cadr(item))
+				tmp, err = rt.macroexpandObject(Cadr(item))
 				tmp = List(Intern("list"), tmp)
 				if err != nil {
 					return nil, err
@@ -550,14 +654,14 @@ func expandQQList(lst *Object) (*Object, error) {
 				tail.cdr = List(tmp)
 				tail = tail.cdr
 			} else if Car(item) == UnquoteSymbolSplicing && ListLength(item) == 2 {
-				tmp, err = macroexpandObject(Cadr(item))
+				tmp, err = rt.macroexpandObject(Cadr(item))
 				if err != nil {
 					return nil, err
 				}
 				tail.cdr = List(tmp)
 				tail = tail.cdr
 			} else {
-				tmp, err = expandQQList(item)
+				tmp, err = rt.expandQQList(item)
 				if err != nil {
 					return nil, err
 				}
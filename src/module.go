@@ -9,76 +9,108 @@ import (
 	"github.com/boynton/cli"
 )
 
-var verbose bool
-var debug bool
-var interactive bool
-
 // SetFlags - set various flags controlling the runtime
-func SetFlags(o bool, v bool, d bool, t bool, i bool) {
-	optimize = o
-	verbose = v
-	debug = d
-	trace = t
-	interactive = i
+func (rt *Runtime) SetFlags(o bool, v bool, d bool, t bool, i bool) {
+	rt.optimize = o
+	rt.verbose = v
+	rt.debug = d
+	rt.trace = t
+	rt.interactive = i
+}
+
+// SetMaxStackSize - set the size, in slots, that the stack of a vm may grow to. It also limits the depth of nested
+// calls. Past it, a stack-overflow: error is raised.
+func (rt *Runtime) SetMaxStackSize(size int) {
+	rt.maxStackSize = size
 }
 
 // Version - this version of vole
 var Version = "(development version)"
 
-var constantsMap = make(map[*Object]int, 0)
-var constants = make([]*Object, 0, 1000)
-var macroMap = make(map[*Object]*macro, 0)
-var primitives = make([]*primitive, 0, 1000)
-
 // Bind the value to the global name
-func DefineGlobal(name string, obj *Object) {
+func (rt *Runtime) DefineGlobal(name string, obj *Object) {
 	sym := Intern(name)
 	if sym == nil {
 		panic("Cannot define a value for this symbol: " + name)
 	}
-	defGlobal(sym, obj)
+	rt.defGlobal(sym, obj)
 }
 
-func definePrimitive(name string, prim *Object) {
+func (rt *Runtime) definePrimitive(name string, prim *Object) {
 	sym := Intern(name)
-	if GetGlobal(sym) != nil {
+	if rt.GetGlobal(sym) != nil {
 		println("*** Warning: redefining ", name, " with a primitive")
 	}
-	defGlobal(sym, prim)
+	rt.defGlobal(sym, prim)
 }
 
 // Register a primitive function to the specified global name
-func DefineFunction(name string, fun PrimitiveFunction, result *Object, args ...*Object) {
+func (rt *Runtime) DefineFunction(name string, fun PrimitiveFunction, result *Object, args ...*Object) {
 	prim := Primitive(name, fun, result, args, nil, nil, nil)
-	definePrimitive(name, prim)
+	rt.definePrimitive(name, prim)
 }
 
 // Register a primitive function with Rest arguments to the specified global name
-func DefineFunctionRestArgs(name string, fun PrimitiveFunction, result *Object, rest *Object, args ...*Object) {
+func (rt *Runtime) DefineFunctionRestArgs(name string, fun PrimitiveFunction, result *Object, rest *Object, args ...*Object) {
 	prim := Primitive(name, fun, result, args, rest, []*Object{}, nil)
-	definePrimitive(name, prim)
+	rt.definePrimitive(name, prim)
 }
 
 // Register a primitive function with optional arguments to the specified global name
-func DefineFunctionOptionalArgs(name string, fun PrimitiveFunction, result *Object, args []*Object, defaults ...*Object) {
+func (rt *Runtime) DefineFunctionOptionalArgs(name string, fun PrimitiveFunction, result *Object, args []*Object, defaults ...*Object) {
 	prim := Primitive(name, fun, result, args, nil, defaults, nil)
-	definePrimitive(name, prim)
+	rt.definePrimitive(name, prim)
 }
 
 // Register a primitive function with keyword arguments to the specified global name
-func DefineFunctionKeyArgs(name string, fun PrimitiveFunction, result *Object, args []*Object, defaults []*Object, keys []*Object) {
+func (rt *Runtime) DefineFunctionKeyArgs(name string, fun PrimitiveFunction, result *Object, args []*Object, defaults []*Object, keys []*Object) {
 	prim := Primitive(name, fun, result, args, nil, defaults, keys)
-	definePrimitive(name, prim)
+	rt.definePrimitive(name, prim)
+}
+
+// defineRuntimeFunction - like DefineFunction, for a primitive that is passed the runtime it is called in
+func (rt *Runtime) defineRuntimeFunction(name string, fun runtimeFunction, result *Object, args ...*Object) {
+	prim := Primitive(name, nil, result, args, nil, nil, nil)
+	prim.primitive.rtfun = fun
+	rt.definePrimitive(name, prim)
+}
+
+// defineRuntimeFunctionRestArgs - like DefineFunctionRestArgs, for a primitive that is passed the runtime
+func (rt *Runtime) defineRuntimeFunctionRestArgs(name string, fun runtimeFunction, result *Object, rest *Object, args ...*Object) {
+	prim := Primitive(name, nil, result, args, rest, []*Object{}, nil)
+	prim.primitive.rtfun = fun
+	rt.definePrimitive(name, prim)
+}
+
+// defineRuntimeFunctionOptionalArgs - like DefineFunctionOptionalArgs, for a primitive that is passed the runtime
+func (rt *Runtime) defineRuntimeFunctionOptionalArgs(name string, fun runtimeFunction, result *Object, args []*Object, defaults ...*Object) {
+	prim := Primitive(name, nil, result, args, nil, defaults, nil)
+	prim.primitive.rtfun = fun
+	rt.definePrimitive(name, prim)
+}
+
+// defineRuntimeFunctionKeyArgs - like DefineFunctionKeyArgs, for a primitive that is passed the runtime
+func (rt *Runtime) defineRuntimeFunctionKeyArgs(name string, fun runtimeFunction, result *Object, args []*Object, defaults []*Object, keys []*Object) {
+	prim := Primitive(name, nil, result, args, nil, defaults, keys)
+	prim.primitive.rtfun = fun
+	rt.definePrimitive(name, prim)
+}
+
+// defineRuntimeMacro - like DefineMacro, for an expander that is passed the runtime it is called in
+func (rt *Runtime) defineRuntimeMacro(name string, fun runtimeFunction) {
+	prim := Primitive(name, nil, AnyType, []*Object{AnyType}, nil, nil, nil)
+	prim.primitive.rtfun = fun
+	rt.defMacro(Intern(name), prim)
 }
 
 // Register a primitive macro with the specified name.
-func DefineMacro(name string, fun PrimitiveFunction) {
+func (rt *Runtime) DefineMacro(name string, fun PrimitiveFunction) {
 	sym := Intern(name)
-	if GetMacro(sym) != nil {
-		println("*** Warning: redefining macro ", name, " -> ", GetMacro(sym))
+	if rt.GetMacro(sym) != nil {
+		println("*** Warning: redefining macro ", name, " -> ", rt.GetMacro(sym))
 	}
 	prim := Primitive(name, fun, AnyType, []*Object{AnyType}, nil, nil, nil)
-	defMacro(sym, prim)
+	rt.defMacro(sym, prim)
 }
 
 // GetKeywords - return a slice of Vile primitive reserved words
@@ -96,85 +128,139 @@ func GetKeywords() []*Object {
 		Intern("set!"),
 		Intern("code"),
 		Intern("import"),
+		Intern("try"),
 	}
 
 	return keywords
 }
 
 // Globals - return a slice of all defined global symbols
-func Globals() []*Object {
+func (rt *Runtime) Globals() []*Object {
 	var syms []*Object
-	for _, sym := range symtab {
-		if sym.car != nil {
+	for sym, b := range rt.globals.snapshot() {
+		if b.get() != nil {
 			syms = append(syms, sym)
 		}
 	}
 	return syms
 }
 
+func (rt *Runtime) globalValue(sym *Object) *Object {
+	if b := rt.globals.lookup(sym); b != nil {
+		return b.get()
+	}
+	return nil
+}
+
+// globalCell - return the binding of the global whose symbol is the constant at idx, caching it so that the
+// global instructions need no lookup in the globals. A binding is never replaced once created: defGlobal and
+// undefGlobal set the value in it, so the cached bindings always see the current value.
+func (rt *Runtime) globalCell(idx int) *binding {
+	rt.cellsLock.Lock()
+	defer rt.cellsLock.Unlock()
+	cells := rt.cellsSnapshot()
+	if idx < len(cells) && cells[idx] != nil {
+		return cells[idx]
+	}
+	constants := rt.constantsSnapshot()
+	updated := make([]*binding, len(constants))
+	copy(updated, cells)
+	b := rt.globals.bind(constants[idx])
+	updated[idx] = b
+	rt.cells.Store(updated)
+	return b
+}
+
+func (rt *Runtime) cellsSnapshot() []*binding {
+	return rt.cells.Load().([]*binding)
+}
+
 // GetGlobal - return the global value for the specified symbol, or nil if the symbol is not defined.
-func GetGlobal(sym *Object) *Object {
+func (rt *Runtime) GetGlobal(sym *Object) *Object {
 	if IsSymbol(sym) {
-		return sym.car
+		return rt.globalValue(sym)
 	}
 	return nil
 }
 
-func defGlobal(sym *Object, val *Object) {
-	sym.car = val
-	delete(macroMap, sym)
+func (rt *Runtime) defGlobal(sym *Object, val *Object) {
+	rt.globals.bind(sym).set(val)
+	rt.macroLock.Lock()
+	delete(rt.macros, sym)
+	rt.macroLock.Unlock()
 }
 
 // IsDefined - return true if the there is a global value defined for the symbol
-func IsDefined(sym *Object) bool {
-	return sym.car != nil
+func (rt *Runtime) IsDefined(sym *Object) bool {
+	return rt.globalValue(sym) != nil
 }
 
-func undefGlobal(sym *Object) {
-	sym.car = nil
+func (rt *Runtime) undefGlobal(sym *Object) {
+	if b := rt.globals.lookup(sym); b != nil {
+		b.set(nil)
+	}
 }
 
 // Macros - return a slice of all defined macros
-func Macros() []*Object {
-	keys := make([]*Object, 0, len(macroMap))
-	for k := range macroMap {
+func (rt *Runtime) Macros() []*Object {
+	rt.macroLock.RLock()
+	defer rt.macroLock.RUnlock()
+	keys := make([]*Object, 0, len(rt.macros))
+	for k := range rt.macros {
 		keys = append(keys, k)
 	}
 	return keys
 }
 
 // GetMacro - return the macro for the symbol, or nil if not defined
-func GetMacro(sym *Object) *macro {
-	mac, ok := macroMap[sym]
+func (rt *Runtime) GetMacro(sym *Object) *macro {
+	rt.macroLock.RLock()
+	mac, ok := rt.macros[sym]
+	rt.macroLock.RUnlock()
 	if !ok {
 		return nil
 	}
 	return mac
 }
 
-func defMacro(sym *Object, val *Object) {
-	macroMap[sym] = Macro(sym, val)
+func (rt *Runtime) defMacro(sym *Object, val *Object) {
+	mac := Macro(sym, val)
+	rt.macroLock.Lock()
+	rt.macros[sym] = mac
+	rt.macroLock.Unlock()
 }
 
 // note: unlike java, we cannot use maps or arrays as keys (they are not comparable).
 // so, we will end up with duplicates, unless we do some deep compare, when putting map or array constants
-func putConstant(val *Object) int {
-	idx, present := constantsMap[val]
+func (rt *Runtime) putConstant(val *Object) int {
+	rt.constantsLock.Lock()
+	defer rt.constantsLock.Unlock()
+	idx, present := rt.constantsMap[val]
 	if !present {
-		idx = len(constants)
-		constants = append(constants, val)
-		constantsMap[val] = idx
+		values := rt.constantsSnapshot()
+		idx = len(values)
+		rt.constants.Store(append(values, val))
+		rt.constantsMap[val] = idx
 	}
 	return idx
 }
 
-func Import(sym *Object) error {
-	return Load(sym.text)
+// constantsSnapshot - return the constants defined so far
+func (rt *Runtime) constantsSnapshot() []*Object {
+	return rt.constants.Load().([]*Object)
+}
+
+func (rt *Runtime) getConstant(idx int) *Object {
+	return rt.constantsSnapshot()[idx]
+}
+
+func (rt *Runtime) Import(sym *Object) error {
+	return rt.Load(sym.text)
 }
 
-func importCode(thunk *Object) (*Object, error) {
+func (rt *Runtime) importCode(thunk *Object) (*Object, error) {
 	var args []*Object
-	result, err := exec(thunk.code, args)
+	result, err := rt.exec(thunk.code, args)
 
 	if err != nil {
 		return nil, err
@@ -184,12 +270,12 @@ func importCode(thunk *Object) (*Object, error) {
 
 var loadPathSymbol = Intern("*load-path*")
 
-func FindModuleByName(moduleName string) (string, error) {
+func (rt *Runtime) FindModuleByName(moduleName string) (string, error) {
 	// ~/go/src/github.com/sami2020pro/vile/src/lib
 	if moduleName == "vile" || moduleName == "vile.vl" {
 		return "@/vile.vl", nil
 	}
-	loadPath := GetGlobal(loadPathSymbol)
+	loadPath := rt.GetGlobal(loadPathSymbol)
 	if loadPath == nil {
 		loadPath = String(".")
 	}
@@ -215,21 +301,21 @@ func FindModuleByName(moduleName string) (string, error) {
 	return "", Error(IOErrorKey, "FindModuleByName Module not found: ", moduleName)
 }
 
-func Load(name string) error {
-	if verbose {
+func (rt *Runtime) Load(name string) error {
+	if rt.verbose {
 		fmt.Println("; [loading " + name + "]")
 	}
-	file, err := FindModuleFile(name)
+	file, err := rt.FindModuleFile(name)
 	if err != nil {
 		return err
 	}
-	return LoadFile(file)
+	return rt.LoadFile(file)
 }
 
-func LoadFile(file string) error {
-	if verbose {
+func (rt *Runtime) LoadFile(file string) error {
+	if rt.verbose {
 		println("; loadFile: " + file)
-	} else if interactive {
+	} else if rt.interactive {
 		println("[loading " + file + "]")
 	}
 	fileText, err := SlurpFile(file)
@@ -237,14 +323,17 @@ func LoadFile(file string) error {
 	if err != nil {
 		return err
 	}
-	exprs, err := ReadAll(fileText, nil)
+	if strings.HasSuffix(file, ".lvm") && IsLVM([]byte(fileText.text)) {
+		return rt.loadCompiledModule(file, []byte(fileText.text))
+	}
+	exprs, err := ReadSource(fileText, file, nil)
 
 	if err != nil {
 		return err
 	}
 	for exprs != EmptyList {
 		expr := Car(exprs)
-		_, err = Eval(expr)
+		_, err = rt.Eval(expr)
 		if err != nil {
 			return err
 		}
@@ -253,32 +342,46 @@ func LoadFile(file string) error {
 	return nil
 }
 
-func Eval(expr *Object) (*Object, error) {
-	if debug {
+func (rt *Runtime) loadCompiledModule(file string, data []byte) error {
+	thunks, err := rt.DecodeLVM(data)
+	if err != nil {
+		return Error(IOErrorKey, file, ": ", err.Error())
+	}
+	for _, thunk := range thunks {
+		_, err = rt.importCode(thunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rt *Runtime) Eval(expr *Object) (*Object, error) {
+	if rt.debug {
 		println("; eval: ", Write(expr))
 	}
-	expanded, err := macroexpandObject(expr)
+	expanded, err := rt.macroexpandObject(expr)
 	if err != nil {
 		return nil, err
 	}
-	if debug {
+	if rt.debug {
 		println("; expanded to: ", Write(expanded))
 	}
-	code, err := Compile(expanded)
+	code, err := rt.Compile(expanded)
 	if err != nil {
 		return nil, err
 	}
-	if debug {
+	if rt.debug {
 		val := strings.Replace(Write(code), "\n", "\n; ", -1)
 		println("; compiled to:\n;  ", val)
 	}
-	return importCode(code)
+	return rt.importCode(code)
 }
 
-func FindModuleFile(name string) (string, error) {
+func (rt *Runtime) FindModuleFile(name string) (string, error) {
 	i := strings.Index(name, ".")
 	if i < 0 {
-		file, err := FindModuleByName(name)
+		file, err := rt.FindModuleByName(name)
 		if err != nil {
 			return "", err
 		}
@@ -290,75 +393,181 @@ func FindModuleFile(name string) (string, error) {
 	return name, nil
 }
 
-func compileObject(expr *Object) (string, error) {
-	if debug {
+func (rt *Runtime) compileObject(expr *Object) (*Object, error) {
+	if rt.debug {
 		println("; compile: ", Write(expr))
 	}
-	expanded, err := macroexpandObject(expr)
+	expanded, err := rt.macroexpandObject(expr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if debug {
+	if rt.debug {
 		println("; expanded to: ", Write(expanded))
 	}
-	thunk, err := Compile(expanded)
+	thunk, err := rt.Compile(expanded)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if debug {
+	if rt.debug {
 		println("; compiled to: ", Write(thunk))
 	}
-	return thunk.code.decompile(true) + "\n", nil
+	err = rt.evalCompileTimeForms(expanded)
+	if err != nil {
+		return nil, err
+	}
+	return thunk, nil
 }
 
-// caveats: when you compile a file, you actually run it. This is so we can handle imports and macros correctly.
-func CompileFile(name string) (*Object, error) {
-	file, err := FindModuleFile(name)
+// compileFileThunks - compile each top level expression in the file, returning the file name and the compiled code objects.
+// Nothing in the file is run, except the macro definitions and imports needed to expand the rest of it. Those are
+// evaluated in a fresh runtime, see compileTimeRuntime, which is discarded once the file has been compiled.
+func (rt *Runtime) compileFileThunks(name string) (string, []*Object, error) {
+	file, err := rt.FindModuleFile(name)
 	if err != nil {
-		return nil, err
+		return "", nil, err
+	}
+	if strings.HasSuffix(file, ".lvm") {
+		source := strings.TrimSuffix(file, ".lvm") + ".vl"
+		if !IsFileReadable(source) {
+			return "", nil, Error(IOErrorKey, "Module is already compiled: ", file)
+		}
+		file = source
 	}
-	if verbose {
+	if rt.verbose {
 		println("; loadFile: " + file)
 	}
 	fileText, err := SlurpFile(file)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-
-	exprs, err := ReadAll(fileText, nil)
-	result := ";\n; code generated from " + file + "\n;\n"
-	var lvm string
+	exprs, err := ReadSource(fileText, file, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	crt, err := rt.compileTimeRuntime()
+	if err != nil {
+		return "", nil, err
+	}
+	var thunks []*Object
 	for exprs != EmptyList { // until the code is finished
-		expr := Car(exprs)
-		lvm, err = compileObject(expr)
+		thunk, err := crt.compileObject(Car(exprs))
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		result += lvm
+		thunks = append(thunks, thunk)
 		exprs = Cdr(exprs)
 	}
+	return file, thunks, nil
+}
+
+// compileTimeRuntime - create the runtime that files are compiled in. It is initialized like a new runtime, with the
+// flags, stack size and load path of this one, and the extensions that can be initialized into a specific runtime, so
+// nothing the compiled file defines is seen by this runtime, or by the tasks running in it.
+func (rt *Runtime) compileTimeRuntime() (*Runtime, error) {
+	var extns []Extension
+	for _, ext := range rt.extensions {
+		if _, ok := ext.(RuntimeExtension); ok {
+			extns = append(extns, ext)
+		}
+	}
+	crt := newRuntime()
+	crt.SetFlags(rt.optimize, rt.verbose, rt.debug, rt.trace, rt.interactive)
+	crt.SetMaxStackSize(rt.maxStackSize)
+	if err := crt.Init(extns...); err != nil {
+		return nil, err
+	}
+	if loadPath := rt.globalValue(loadPathSymbol); loadPath != nil {
+		crt.defGlobal(loadPathSymbol, loadPath)
+	}
+	return crt, nil
+}
+
+// evalCompileTimeForms - evaluate the macro definitions and imports in the expanded top level form, so that
+// the forms following it can be expanded. Nothing else is run.
+func (rt *Runtime) evalCompileTimeForms(expanded *Object) error {
+	if !IsList(expanded) || expanded == EmptyList {
+		return nil
+	}
+	switch Car(expanded) {
+	case Intern("macro"), Intern("import"):
+		code, err := rt.Compile(expanded)
+		if err != nil {
+			return err
+		}
+		_, err = rt.exec(code.code, nil)
+		return err
+	case Intern("do"):
+		for forms := Cdr(expanded); forms != EmptyList; forms = Cdr(forms) {
+			err := rt.evalCompileTimeForms(Car(forms))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CompileFile - compile the file, returning the textual (lap) listing of the code
+func (rt *Runtime) CompileFile(name string) (*Object, error) {
+	file, thunks, err := rt.compileFileThunks(name)
+	if err != nil {
+		return nil, err
+	}
+	result := ";\n; code generated from " + file + "\n;\n"
+	for _, thunk := range thunks {
+		result += thunk.code.decompile(true) + "\n"
+	}
 	return String(result), nil
 }
 
+// CompileModule - compile the file into the binary module format, and write it next to the source file
+// with an .lvm extension. The name of the written file is returned.
+func (rt *Runtime) CompileModule(name string) (string, error) {
+	file, thunks, err := rt.compileFileThunks(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(file, "@/") {
+		return "", Error(IOErrorKey, "Cannot write a compiled module for a builtin library: ", file)
+	}
+	data, err := EncodeLVM(thunks)
+	if err != nil {
+		return "", err
+	}
+	output := strings.TrimSuffix(file, filepath.Ext(file)) + ".lvm"
+	err = SpitFile(output, string(data))
+	if err != nil {
+		return "", Error(IOErrorKey, err.Error())
+	}
+	return output, nil
+}
+
 type Extension interface {
 	Init() error
 	Cleanup()
 	String() string
 }
 
-var extensions []Extension
+// RuntimeExtension - an Extension that can be initialized into a specific runtime. Plain extensions define their
+// functions with the package functions, so they can only extend the default runtime.
+type RuntimeExtension interface {
+	Extension
+	InitRuntime(rt *Runtime) error
+}
 
-func AddVileDirectory(dirname string) {
+func (rt *Runtime) AddVileDirectory(dirname string) {
 	loadPath := dirname
-	tmp := GetGlobal(loadPathSymbol)
+	tmp := rt.GetGlobal(loadPathSymbol)
 	if tmp != nil {
 		loadPath = dirname + ":" + StringValue(tmp)
 	}
-	DefineGlobal(StringValue(loadPathSymbol), String(loadPath))
+	rt.DefineGlobal(StringValue(loadPathSymbol), String(loadPath))
 }
 
-func Init(extns ...Extension) {
-	extensions = extns
+// Init - set the load path, define the primitives, and initialize the extensions, returning the error of the first
+// extension that fails
+func (rt *Runtime) Init(extns ...Extension) error {
+	rt.extensions = extns
 	loadPath := os.Getenv("VILE_PATH")
 	home := os.Getenv("HOME")
 
@@ -374,52 +583,63 @@ func Init(extns ...Extension) {
 		}
 	}
 	loadPath += ":@/"
-	DefineGlobal(StringValue(loadPathSymbol), String(loadPath))
-	InitPrimitives()
-	for _, ext := range extensions {
-		err := ext.Init()
+	rt.DefineGlobal(StringValue(loadPathSymbol), String(loadPath))
+	rt.InitPrimitives()
+	for _, ext := range rt.extensions {
+		var err error
+		if rext, ok := ext.(RuntimeExtension); ok {
+			err = rext.InitRuntime(rt)
+		} else {
+			err = ext.Init()
+		}
 		if err != nil {
-			Fatal("*** ", err)
+			return err
 		}
 	}
+	return nil
 }
 
-func Cleanup() {
-	for _, ext := range extensions {
+func (rt *Runtime) Cleanup() {
+	for _, ext := range rt.extensions {
 		ext.Cleanup()
 	}
 }
 
-func Run(args ...string) {
+func (rt *Runtime) Run(args ...string) {
 	for _, filename := range args {
-		err := Load(filename)
+		err := rt.Load(filename)
 		if err != nil {
-			Fatal("*** ", err.Error())
+			Fatal("*** ", ErrorReport(err))
 		}
 	}
 }
 
 func Main(extns ...Extension) {
-	var help, compile, optimize, verbose, debug, trace, noInit bool
-	var path string
+	var help, compile, lap, optimize, verbose, debug, trace, noInit bool
+	var path, profile string
+	var maxStack int
 	cmd := cli.New("vile", "The Vile Language")
 	cmd.BoolOption(&help, "help", false, "Show help")
-	cmd.BoolOption(&compile, "compile", false, "compile the file and output lap")
+	cmd.BoolOption(&compile, "compile", false, "compile the file to a binary .lvm module")
+	cmd.BoolOption(&lap, "lap", false, "compile the file and output lap")
 	cmd.BoolOption(&optimize, "optimize", false, "optimize execution speed, should work for correct code, relax some checks")
 	cmd.BoolOption(&verbose, "verbose", false, "verbose mode, print extra information")
 	cmd.BoolOption(&debug, "debug", false, "debug mode, print extra information about compilation")
 	cmd.BoolOption(&trace, "trace", false, "trace VM instructions as they get executed")
 	cmd.BoolOption(&noInit, "noinit", false, "disable initialization from the $HOME/.vl file")
-	//var prof bool
-	//cmd.BoolOption(&prof, "profile", false, "profile the code")
+	cmd.StringOption(&profile, "profile", "", "profile the files run, print a report and write a pprof profile to the given file")
 	cmd.StringOption(&path, "path", "", "add directories to vile load path")
+	cmd.IntOption(&maxStack, "stack", defaultMaxStackSize, "the size, in slots, the VM stack may grow to")
 	args, _ := cmd.Parse()
 	if help {
 		fmt.Println(cmd.Usage())
 		os.Exit(1)
 	}
 	interactive := len(args) == 0
-	Init(extns...)
+	SetMaxStackSize(maxStack)
+	if err := Init(extns...); err != nil {
+		Fatal("*** ", err)
+	}
 	if path != "" {
 		for _, p := range strings.Split(path, ":") {
 			expandedPath := ExpandFilePath(p)
@@ -434,7 +654,19 @@ func Main(extns ...Extension) {
 		}
 	}
 	if len(args) > 0 {
+		SetFlags(optimize, verbose, debug, trace, interactive)
 		if compile {
+			// just compile and write the LVM modules
+			for _, filename := range args {
+				output, err := CompileModule(filename)
+				if err != nil {
+					Fatal("*** ", err)
+				}
+				if verbose {
+					println("; wrote " + output)
+				}
+			}
+		} else if lap {
 			// just compile and print LVM code
 			for _, filename := range args {
 				lap, err := CompileFile(filename)
@@ -443,8 +675,15 @@ func Main(extns ...Extension) {
 				}
 				Println(lap)
 			}
+		} else if profile != "" {
+			StartProfiling()
+			Run(args...)
+			prof := StopProfiling()
+			prof.Report(os.Stderr)
+			if err := prof.WriteFile(profile); err != nil {
+				Fatal("*** ", err)
+			}
 		} else {
-			SetFlags(optimize, verbose, debug, trace, interactive)
 			Run(args...)
 		}
 	} else {
@@ -102,17 +102,24 @@ func Read(input *Object, keys *Object) (*Object, error) {
 		if err == io.EOF {
 			return Null, nil
 		}
-		return nil, err
+		return nil, withLocation(err, reader.location())
 	}
 	return obj, nil
 }
 
 // ReadAll - read all items in the input, returning a list of them.
 func ReadAll(input *Object, keys *Object) (*Object, error) {
+	return ReadSource(input, "", keys)
+}
+
+// ReadSource - read all items in the source text of the file, returning a list of them. The lists read
+// remember the file, line and column they started at, and syntax errors report where they occurred.
+func ReadSource(input *Object, file string, keys *Object) (*Object, error) {
 	if !IsString(input) {
 		return nil, Error(ArgumentErrorKey, "read-all invalid input: ", input)
 	}
 	reader := newDataReader(strings.NewReader(input.text))
+	reader.file = file
 	lst := EmptyList
 	tail := EmptyList
 	val, err := reader.readData(keys)
@@ -127,25 +134,36 @@ func ReadAll(input *Object, keys *Object) (*Object, error) {
 		val, err = reader.readData(keys)
 	}
 	if err != io.EOF {
-		return nil, err
+		return nil, withLocation(err, reader.location())
 	}
 	return lst, nil
 }
 
 type dataReader struct {
-	in  *bufio.Reader
-	pos int
+	in         *bufio.Reader
+	pos        int
+	file       string
+	line       int // the line and column of the last character read
+	column     int
+	prevColumn int // the column before it, to unget a newline
 }
 
 func newDataReader(in io.Reader) *dataReader {
 	br := bufio.NewReader(in)
-	return &dataReader{br, 0}
+	return &dataReader{in: br, line: 1}
 }
 
 func (dr *dataReader) getChar() (byte, error) {
 	b, e := dr.in.ReadByte()
 	if e == nil {
 		dr.pos++
+		dr.prevColumn = dr.column
+		if b == '\n' {
+			dr.line++
+			dr.column = 0
+		} else {
+			dr.column++
+		}
 	}
 	return b, e
 }
@@ -154,10 +172,19 @@ func (dr *dataReader) ungetChar() error {
 	e := dr.in.UnreadByte()
 	if e == nil {
 		dr.pos--
+		if dr.column == 0 {
+			dr.line--
+		}
+		dr.column = dr.prevColumn
 	}
 	return e
 }
 
+// location - return the location of the last character read
+func (dr *dataReader) location() *location {
+	return &location{dr.file, dr.line, dr.column}
+}
+
 func (dr *dataReader) readData(keys *Object) (*Object, error) {
 	//c, n, e := dr.in.ReadRune()
 	c, e := dr.getChar()
@@ -275,6 +302,8 @@ func (dr *dataReader) decodeString() (*Object, error) {
 					return nil, e
 				}
 				buf = append(buf, c)
+			default: // \" and \\
+				buf = append(buf, c)
 			}
 		} else if c == '"' {
 			break
@@ -290,11 +319,16 @@ func (dr *dataReader) decodeString() (*Object, error) {
 }
 
 func (dr *dataReader) decodeList(keys *Object) (*Object, error) {
+	loc := dr.location()
 	items, err := dr.decodeSequence(')', keys)
 	if err != nil {
 		return nil, err
 	}
-	return ListFromValues(items), nil
+	lst := ListFromValues(items)
+	if lst != EmptyList {
+		lst.Value = loc
+	}
+	return lst, nil
 }
 
 func (dr *dataReader) decodeVector(keys *Object) (*Object, error) {
@@ -382,6 +416,7 @@ func (dr *dataReader) decodeStruct(keys *Object) (*Object, error) {
 }
 
 func (dr *dataReader) decodeSequence(endChar byte, keys *Object) ([]*Object, error) {
+	start := dr.location()
 	c, err := dr.getChar()
 	var items []*Object
 	for err == nil {
@@ -400,13 +435,17 @@ func (dr *dataReader) decodeSequence(endChar byte, keys *Object) ([]*Object, err
 			return items, nil
 		}
 		dr.ungetChar()
-		element, err := dr.readData(keys)
+		var element *Object
+		element, err = dr.readData(keys)
 		if err != nil {
-			return nil, err
+			break
 		}
 		items = append(items, element)
 		c, err = dr.getChar()
 	}
+	if err == io.EOF {
+		return nil, withLocation(Error(SyntaxErrorKey, "Missing '", string(endChar), "' at end of input"), start)
+	}
 	return nil, err
 }
 
@@ -429,12 +468,11 @@ func (dr *dataReader) decodeAtom(firstChar byte) (*Object, error) {
 			return False, nil
 		}
 	}
-	f, err := strconv.ParseFloat(s, 64)
-	if err == nil {
+	if num := parseNumber(s); num != nil {
 		if keyword {
 			return nil, Error(SyntaxErrorKey, "Keyword cannot have a name that looks like a number: ", s, ":")
 		}
-		return Number(f), nil
+		return num, nil
 	}
 	if keyword {
 		s += ":"
@@ -616,16 +654,40 @@ func isDelimiter(b byte) bool {
 
 const defaultIndentSize = "    "
 
-func Write(obj *Object) string {
-	return writeIndent(obj, "")
+// WriteOption - an option for how Write, Pretty and WriteJSON write data
+type WriteOption int
+
+const (
+	// SortKeys - write the keys of structs in sorted order, rather than in the order they were added
+	SortKeys WriteOption = iota + 1
+)
+
+// writeMode - how data is written, set from the WriteOptions
+type writeMode struct {
+	json     bool
+	sortKeys bool
+}
+
+func newWriteMode(json bool, options []WriteOption) writeMode {
+	mode := writeMode{json: json}
+	for _, o := range options {
+		if o == SortKeys {
+			mode.sortKeys = true
+		}
+	}
+	return mode
+}
+
+func Write(obj *Object, options ...WriteOption) string {
+	return writeIndent(obj, "", options)
 }
 
-func Pretty(obj *Object) string {
-	return writeIndent(obj, defaultIndentSize)
+func Pretty(obj *Object, options ...WriteOption) string {
+	return writeIndent(obj, defaultIndentSize, options)
 }
 
-func writeIndent(obj *Object, indentSize string) string {
-	s, _ := writeToString(obj, false, indentSize)
+func writeIndent(obj *Object, indentSize string, options []WriteOption) string {
+	s, _ := writeToString(obj, newWriteMode(false, options), indentSize)
 	return s
 }
 
@@ -642,22 +704,22 @@ func writeAllIndent(obj *Object, indent string) string {
 		var buf bytes.Buffer
 		for obj != EmptyList {
 			o := Car(obj)
-			s, _ := writeToString(o, false, indent)
+			s, _ := writeToString(o, writeMode{}, indent)
 			buf.WriteString(s)
 			buf.WriteString("\n")
 			obj = Cdr(obj)
 		}
 		return buf.String()
 	}
-	s, _ := writeToString(obj, false, indent)
+	s, _ := writeToString(obj, writeMode{}, indent)
 	if indent == "" {
 		return s + "\n"
 	}
 	return s
 }
 
-func writeToString(obj *Object, json bool, indentSize string) (string, error) {
-	viledn, err := writeData(obj, json, "", indentSize)
+func writeToString(obj *Object, mode writeMode, indentSize string) (string, error) {
+	viledn, err := writeData(obj, mode, "", indentSize)
 	if err != nil {
 		return "", err
 	}
@@ -667,30 +729,56 @@ func writeToString(obj *Object, json bool, indentSize string) (string, error) {
 	return viledn, nil
 }
 
-func writeData(obj *Object, json bool, indent string, indentSize string) (string, error) {
+func writeData(obj *Object, mode writeMode, indent string, indentSize string) (string, error) {
 	switch obj.Type {
-	case BooleanType, NullType, NumberType:
+	case BooleanType, NullType:
+		return obj.String(), nil
+	case NumberType:
+		if mode.json {
+			return jsonNumber(obj)
+		}
 		return obj.String(), nil
 	case ListType:
-		if json {
-			return writeVector(listToVector(obj), json, indent, indentSize)
+		if mode.json {
+			return writeVector(listToVector(obj), mode, indent, indentSize)
 		}
-		return writeList(obj, indent, indentSize), nil
+		return writeList(obj, mode, indent, indentSize), nil
 	case KeywordType:
-		if json {
-			return EncodeString(unkeywordedString(obj)), nil
+		if mode.json {
+			return jsonString(unkeywordedString(obj)), nil
 		}
 		return obj.String(), nil
 	case SymbolType, TypeType:
+		if mode.json {
+			return jsonString(obj.text), nil
+		}
 		return obj.String(), nil
 	case StringType:
+		if mode.json {
+			return jsonString(obj.text), nil
+		}
 		return EncodeString(obj.text), nil
 	case VectorType:
-		return writeVector(obj, json, indent, indentSize)
+		return writeVector(obj, mode, indent, indentSize)
 	case StructType:
-		return writeStruct(obj, json, indent, indentSize)
+		return writeStruct(obj, mode, indent, indentSize)
+	case PersistentStructType:
+		s, err := writeStruct(obj.Value.(*pstruct).toStruct(), mode, indent, indentSize)
+		if err != nil || mode.json {
+			return s, err
+		}
+		return "#" + obj.Type.text + s, nil
+	case PersistentVectorType:
+		s, err := writeVector(VectorFromElementsNoCopy(obj.Value.(*pvector).elements()), mode, indent, indentSize)
+		if err != nil || mode.json {
+			return s, err
+		}
+		return "#" + obj.Type.text + s, nil
 	case CharacterType:
 		c := rune(obj.fval)
+		if mode.json {
+			return jsonString(string(c)), nil
+		}
 		switch c {
 		case 0:
 			return "#\\null", nil
@@ -717,7 +805,7 @@ func writeData(obj *Object, json bool, indent string, indentSize string) (string
 			return fmt.Sprintf("#\\x%04X", c), nil
 		}
 	default:
-		if json {
+		if mode.json {
 			return "", Error(ArgumentErrorKey, "Data cannot be described in JSON: ", obj)
 		}
 		if obj == nil {
@@ -727,7 +815,7 @@ func writeData(obj *Object, json bool, indent string, indentSize string) (string
 	}
 }
 
-func writeList(lst *Object, indent string, indentSize string) string {
+func writeList(lst *Object, mode writeMode, indent string, indentSize string) string {
 	if lst == EmptyList {
 		return "()"
 	}
@@ -751,12 +839,12 @@ func writeList(lst *Object, indent string, indentSize string) string {
 		delim = "\n" + nextIndent
 		buf.WriteString("\n" + nextIndent)
 	}
-	s, _ := writeData(lst.car, false, nextIndent, indentSize)
+	s, _ := writeData(lst.car, mode, nextIndent, indentSize)
 	buf.WriteString(s)
 	lst = lst.cdr
 	for lst != EmptyList {
 		buf.WriteString(delim)
-		s, _ := writeData(lst.car, false, nextIndent, indentSize)
+		s, _ := writeData(lst.car, mode, nextIndent, indentSize)
 		buf.WriteString(s)
 		lst = lst.cdr
 	}
@@ -767,13 +855,13 @@ func writeList(lst *Object, indent string, indentSize string) string {
 	return buf.String()
 }
 
-func writeVector(vec *Object, json bool, indent string, indentSize string) (string, error) {
+func writeVector(vec *Object, mode writeMode, indent string, indentSize string) (string, error) {
 	var buf bytes.Buffer
 	buf.WriteString("[")
 	vlen := len(vec.elements)
 	if vlen > 0 {
 		delim := ""
-		if json {
+		if mode.json {
 			delim = ","
 		}
 		nextIndent := ""
@@ -784,13 +872,13 @@ func writeVector(vec *Object, json bool, indent string, indentSize string) (stri
 		} else {
 			delim = delim + " "
 		}
-		s, err := writeData(vec.elements[0], json, nextIndent, indentSize)
+		s, err := writeData(vec.elements[0], mode, nextIndent, indentSize)
 		if err != nil {
 			return "", err
 		}
 		buf.WriteString(s)
 		for i := 1; i < vlen; i++ {
-			s, err := writeData(vec.elements[i], json, nextIndent, indentSize)
+			s, err := writeData(vec.elements[i], mode, nextIndent, indentSize)
 			if err != nil {
 				return "", err
 			}
@@ -805,13 +893,13 @@ func writeVector(vec *Object, json bool, indent string, indentSize string) (stri
 	return buf.String(), nil
 }
 
-func writeStruct(strct *Object, json bool, indent string, indentSize string) (string, error) {
+func writeStruct(strct *Object, mode writeMode, indent string, indentSize string) (string, error) {
 	var buf bytes.Buffer
 	buf.WriteString("{")
-	size := len(strct.bindings)
+	size := strct.bindings.size()
 	delim := ""
 	sep := " "
-	if json {
+	if mode.json {
 		delim = ","
 		sep = ": "
 	}
@@ -825,20 +913,21 @@ func writeStruct(strct *Object, json bool, indent string, indentSize string) (st
 			delim = delim + " "
 		}
 	}
-	first := true
-	for k, v := range strct.bindings {
-		if first {
-			first = false
-		} else {
+	entries := strct.bindings.entries
+	if mode.sortKeys {
+		entries = strct.bindings.sorted()
+	}
+	for i, e := range entries {
+		if i > 0 {
 			buf.WriteString(delim)
 		}
-		s, err := writeData(k.toObject(), json, nextIndent, indentSize)
+		s, err := writeData(e.key.toObject(), mode, nextIndent, indentSize)
 		if err != nil {
 			return "", err
 		}
 		buf.WriteString(s)
 		buf.WriteString(sep)
-		s, err = writeData(v, json, nextIndent, indentSize)
+		s, err = writeData(e.value, mode, nextIndent, indentSize)
 		if err != nil {
 			return "", err
 		}
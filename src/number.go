@@ -2,8 +2,10 @@ package vile
 
 import (
 	"math"
+	"math/big"
 	"math/rand"
 	"strconv"
+	"strings"
 )
 
 /*
@@ -17,6 +19,22 @@ func Integer(i int) *Test {
 }
 */
 
+// Numbers form a tower of exact integers, exact rationals, and floats, all of type <number>. Every number
+// keeps a float64 approximation of itself in fval, for code that only needs that. An exact integer that fits
+// in an int64 is a fixnum, with its value in ival, and is promoted to a *big.Int in Value when an operation
+// overflows. A rational is a *big.Rat in Value, and a float has no Value. Results are normalized: a big integer
+// that fits in an int64 becomes a fixnum, a rational with a denominator of 1 an integer, and a float with an
+// integral value small enough to be exact (up to 2^53) a fixnum.
+
+// fixnum - the Value of the numbers whose exact value is in ival
+type fixnum struct{}
+
+// the largest magnitude below which every integral float64 is exact
+const maxExactFloat = 1 << 53
+
+// the most bits a shift or a power can give an exact result, so that a large count cannot run out of memory
+const maxIntegerBits = 1 << 24
+
 // Zero is the Vile 0 value
 var Zero = Number(0)
 
@@ -28,14 +46,198 @@ var MinusOne = Number(-1)
 
 // Number - create a Number object for the given value
 func Number(f float64) *Object {
+	if f == math.Trunc(f) && math.Abs(f) <= maxExactFloat {
+		return Int(int64(f))
+	}
 	num := new(Object)
 	num.Type = NumberType
 	num.fval = f
 	return num
 }
 
+// the small integers, created once: most integers computed are counters and indices, and need not be allocated
+const minCachedInt, maxCachedInt = -128, 1023
+
+var cachedInts = func() []*Object {
+	ints := make([]*Object, maxCachedInt-minCachedInt+1)
+	for i := range ints {
+		ints[i] = newInt(int64(i + minCachedInt))
+	}
+	return ints
+}()
+
+// Int - return an exact integer Number object for the given value
 func Int(n int64) *Object {
-	return Number(float64(n))
+	if n >= minCachedInt && n <= maxCachedInt {
+		return cachedInts[n-minCachedInt]
+	}
+	return newInt(n)
+}
+
+func newInt(n int64) *Object {
+	num := new(Object)
+	num.Type = NumberType
+	num.fval = float64(n)
+	num.ival = n
+	num.Value = fixnum{}
+	return num
+}
+
+// BigInt - create an exact integer Number object for the given value, which is not modified afterwards
+func BigInt(n *big.Int) *Object {
+	if n.IsInt64() {
+		return Int(n.Int64())
+	}
+	num := new(Object)
+	num.Type = NumberType
+	num.fval, _ = new(big.Float).SetInt(n).Float64()
+	num.Value = n
+	return num
+}
+
+// Rational - create an exact Number object for the given value, which is not modified afterwards
+func Rational(r *big.Rat) *Object {
+	if r.IsInt() {
+		return BigInt(r.Num())
+	}
+	num := new(Object)
+	num.Type = NumberType
+	num.fval, _ = r.Float64()
+	num.Value = r
+	return num
+}
+
+// IsExact - return true if the object is an exact number, an integer or a rational
+func IsExact(obj *Object) bool {
+	return obj.Type == NumberType && obj.Value != nil
+}
+
+func isFixnum(obj *Object) bool {
+	_, ok := obj.Value.(fixnum)
+	return ok
+}
+
+func isRational(obj *Object) bool {
+	_, ok := obj.Value.(*big.Rat)
+	return ok
+}
+
+// bigValue - return the value of the exact integer as a *big.Int, which must not be modified
+func bigValue(obj *Object) *big.Int {
+	if b, ok := obj.Value.(*big.Int); ok {
+		return b
+	}
+	return big.NewInt(obj.ival)
+}
+
+// ratValue - return the value of the exact number as a *big.Rat, which must not be modified
+func ratValue(obj *Object) *big.Rat {
+	switch v := obj.Value.(type) {
+	case *big.Rat:
+		return v
+	case *big.Int:
+		return new(big.Rat).SetInt(v)
+	}
+	return new(big.Rat).SetInt64(obj.ival)
+}
+
+// integerValue - return the exact value of the number as a *big.Int, if it is an integer
+func integerValue(obj *Object) (*big.Int, error) {
+	switch v := obj.Value.(type) {
+	case fixnum:
+		return big.NewInt(obj.ival), nil
+	case *big.Int:
+		return v, nil
+	case nil:
+		if obj.fval == math.Trunc(obj.fval) && !math.IsInf(obj.fval, 0) {
+			n, _ := big.NewFloat(obj.fval).Int(nil)
+			return n, nil
+		}
+	}
+	return nil, Error(ArgumentErrorKey, "Expected an integer, got ", obj)
+}
+
+// numberToString - return the textual representation of the number: the digits of an integer, n/d for a rational
+func numberToString(obj *Object) string {
+	switch v := obj.Value.(type) {
+	case fixnum:
+		return strconv.FormatInt(obj.ival, 10)
+	case *big.Int:
+		return v.String()
+	case *big.Rat:
+		return v.String()
+	}
+	return strconv.FormatFloat(obj.fval, 'f', -1, 64)
+}
+
+// parseNumber - return the number the text represents, or nil if it is not a number. Integers are decimal, or
+// hex, binary or octal with a 0x, 0b or 0o prefix, and rationals are written as a ratio of decimal integers
+func parseNumber(s string) *Object {
+	if n := parseInteger(s); n != nil {
+		return n
+	}
+	if i := strings.IndexByte(s, '/'); i > 0 && isDecimal(strings.TrimLeft(s[:i], "+-")) && isDecimal(s[i+1:]) {
+		if r, ok := new(big.Rat).SetString(s); ok {
+			return Rational(r)
+		}
+		return nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return Number(f)
+	}
+	return nil
+}
+
+// parseInteger - return the integer the text represents, or nil if it is not an integer
+func parseInteger(s string) *Object {
+	digits, negative := s, false
+	if digits != "" && (digits[0] == '-' || digits[0] == '+') {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+		if base != 10 {
+			digits = digits[2:]
+		}
+	}
+	if digits == "" || digits[0] == '-' || digits[0] == '+' {
+		return nil
+	}
+	if n, err := strconv.ParseInt(digits, base, 64); err == nil {
+		if negative {
+			n = -n
+		}
+		return Int(n)
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil
+	}
+	if negative {
+		n.Neg(n)
+	}
+	return BigInt(n)
+}
+
+func isDecimal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 // Round - return the closest integer value to the float value
@@ -46,6 +248,28 @@ func Round(f float64) float64 {
 	return math.Ceil(f - 0.5)
 }
 
+// roundRat - return the closest integer to the rational, rounding halves away from zero
+func roundRat(r *big.Rat) *big.Int {
+	n := new(big.Int).Lsh(r.Num(), 1)
+	if n.Sign() >= 0 {
+		n.Add(n, r.Denom())
+	} else {
+		n.Sub(n, r.Denom())
+	}
+	return n.Quo(n, new(big.Int).Lsh(r.Denom(), 1))
+}
+
+// floorRat - return the largest integer not greater than the rational
+func floorRat(r *big.Rat) *big.Int {
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+// ceilRat - return the smallest integer not less than the rational
+func ceilRat(r *big.Rat) *big.Int {
+	n := floorRat(new(big.Rat).Neg(r))
+	return n.Neg(n)
+}
+
 // ToNumber - convert object to a number, if possible
 func ToNumber(o *Object) (*Object, error) {
 	switch o.Type {
@@ -56,37 +280,55 @@ func ToNumber(o *Object) (*Object, error) {
 	case BooleanType:
 		return Number(o.fval), nil
 	case StringType:
-		f, err := strconv.ParseFloat(o.text, 64)
-		if err == nil {
-			return Number(f), nil
+		if n := parseNumber(o.text); n != nil {
+			return n, nil
 		}
 	}
 	return nil, Error(ArgumentErrorKey, "cannot convert to an number: ", o)
 }
 
-// ToInt - convert the object to an integer number, if possible
+// ToInt - convert the object to an exact integer number, if possible. Other numbers are rounded.
 func ToInt(o *Object) (*Object, error) {
 	switch o.Type {
 	case NumberType:
-		return Number(Round(o.fval)), nil
+		switch v := o.Value.(type) {
+		case fixnum, *big.Int:
+			return o, nil
+		case *big.Rat:
+			return BigInt(roundRat(v)), nil
+		}
+		if n, err := integerValue(Number(Round(o.fval))); err == nil {
+			return BigInt(n), nil
+		}
 	case CharacterType:
 		return Number(o.fval), nil
 	case BooleanType:
 		return Number(o.fval), nil
 	case StringType:
-		n, err := strconv.ParseInt(o.text, 10, 64)
-		if err == nil {
-			return Number(float64(n)), nil
+		if n := parseInteger(o.text); n != nil {
+			return n, nil
 		}
 	}
 	return nil, Error(ArgumentErrorKey, "cannot convert to an integer: ", o)
 }
 
+// ToFloat - convert the number to a float, if it is not one already. Floats with an integral value up to 2^53
+// are represented as exact integers, so those are returned unchanged.
+func ToFloat(o *Object) (*Object, error) {
+	if o.Type != NumberType {
+		return nil, Error(ArgumentErrorKey, "Expected a <number>, got a ", o.Type)
+	}
+	return Number(o.fval), nil
+}
+
 func IsInt(obj *Object) bool {
 	if obj.Type == NumberType {
-		f := obj.fval
-		if math.Trunc(f) == f {
+		switch obj.Value.(type) {
+		case fixnum, *big.Int:
 			return true
+		case nil:
+			f := obj.fval
+			return math.Trunc(f) == f && !math.IsInf(f, 0)
 		}
 	}
 	return false
@@ -94,7 +336,7 @@ func IsInt(obj *Object) bool {
 
 func IsFloat(obj *Object) bool {
 	if obj.Type == NumberType {
-		return !IsInt(obj)
+		return obj.Value == nil
 	}
 	return false
 }
@@ -108,29 +350,47 @@ func AsFloat64Value(obj *Object) (float64, error) {
 
 func AsInt64Value(obj *Object) (int64, error) {
 	if obj.Type == NumberType {
+		switch obj.Value.(type) {
+		case fixnum:
+			return obj.ival, nil
+		case *big.Int:
+			return 0, Error(ArgumentErrorKey, "Integer too large: ", obj)
+		}
 		return int64(obj.fval), nil
 	}
 	return 0, Error(ArgumentErrorKey, "Expected a <number>, got a ", obj.Type)
 }
 
 func AsIntValue(obj *Object) (int, error) {
-	if obj.Type == NumberType {
-		return int(obj.fval), nil
+	n, err := AsInt64Value(obj)
+	if err != nil {
+		return 0, err
 	}
-	return 0, Error(ArgumentErrorKey, "Expected a <number>, got a ", obj.Type)
+	if int64(int(n)) != n {
+		return 0, Error(ArgumentErrorKey, "Integer too large: ", obj)
+	}
+	return int(n), nil
 }
 
 func AsByteValue(obj *Object) (byte, error) {
 	if obj.Type == NumberType {
-		return byte(obj.fval), nil
+		return byte(IntValue(obj)), nil
 	}
 	return 0, Error(ArgumentErrorKey, "Expected a <number>, got a ", obj.Type)
 }
 
 const epsilon = 0.000000001
 
-// Equal returns true if the object is equal to the argument, within epsilon
-func NumberEqual(f1 float64, f2 float64) bool {
+// NumberEqual - return true if the numbers are equal. Exact numbers are compared exactly, and when either is a
+// float they are compared as floats, within epsilon.
+func NumberEqual(n1 *Object, n2 *Object) bool {
+	if n1.Value != nil && n2.Value != nil {
+		if isFixnum(n1) && isFixnum(n2) {
+			return n1.ival == n2.ival
+		}
+		return ratValue(n1).Cmp(ratValue(n2)) == 0
+	}
+	f1, f2 := n1.fval, n2.fval
 	if f1 == f2 {
 		return true
 	}
@@ -140,6 +400,216 @@ func NumberEqual(f1 float64, f2 float64) bool {
 	return false
 }
 
+// numberCompare - return -1, 0 or 1 as the first number is less than, equal to, or greater than the second, and
+// false if they are not ordered, when one of them is NaN. Floats are compared exactly to exact numbers.
+func numberCompare(n1 *Object, n2 *Object) (int, bool) {
+	if isFixnum(n1) && isFixnum(n2) {
+		return compareInts(n1.ival, n2.ival), true
+	}
+	f1, f2 := n1.fval, n2.fval
+	if n1.Value == nil || n2.Value == nil {
+		if math.IsNaN(f1) || math.IsNaN(f2) {
+			return 0, false
+		}
+		if math.IsInf(f1, 0) || math.IsInf(f2, 0) || (isApproximateExactly(n1) && isApproximateExactly(n2)) {
+			return compareFloats(f1, f2), true
+		}
+	}
+	return exactValue(n1).Cmp(exactValue(n2)), true
+}
+
+// isApproximateExactly - return true if the float approximation of the number is its exact value
+func isApproximateExactly(obj *Object) bool {
+	return obj.Value == nil || (isFixnum(obj) && obj.ival >= -maxExactFloat && obj.ival <= maxExactFloat)
+}
+
+// exactValue - return the value of the finite number as a *big.Rat, which must not be modified
+func exactValue(obj *Object) *big.Rat {
+	if obj.Value == nil {
+		return new(big.Rat).SetFloat64(obj.fval)
+	}
+	return ratValue(obj)
+}
+
+func compareInts(i1 int64, i2 int64) int {
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(f1 float64, f2 float64) int {
+	switch {
+	case f1 < f2:
+		return -1
+	case f1 > f2:
+		return 1
+	}
+	return 0
+}
+
+// exactArithmetic - apply the operation to the exact numbers, as rationals if either of them is one
+func exactArithmetic(n1 *Object, n2 *Object, intOp func(z, x, y *big.Int) *big.Int, ratOp func(z, x, y *big.Rat) *big.Rat) *Object {
+	if isRational(n1) || isRational(n2) {
+		return Rational(ratOp(new(big.Rat), ratValue(n1), ratValue(n2)))
+	}
+	return BigInt(intOp(new(big.Int), bigValue(n1), bigValue(n2)))
+}
+
+// numberAdd - return the sum of the numbers
+func numberAdd(n1 *Object, n2 *Object) *Object {
+	if isFixnum(n1) && isFixnum(n2) {
+		a, b := n1.ival, n2.ival
+		if s := a + b; (a >= 0) != (b >= 0) || (s >= 0) == (a >= 0) {
+			return Int(s)
+		}
+	} else if n1.Value == nil || n2.Value == nil {
+		return Number(n1.fval + n2.fval)
+	}
+	return exactArithmetic(n1, n2, (*big.Int).Add, (*big.Rat).Add)
+}
+
+// numberSub - return the difference of the numbers
+func numberSub(n1 *Object, n2 *Object) *Object {
+	if isFixnum(n1) && isFixnum(n2) {
+		a, b := n1.ival, n2.ival
+		if s := a - b; (a >= 0) == (b >= 0) || (s >= 0) == (a >= 0) {
+			return Int(s)
+		}
+	} else if n1.Value == nil || n2.Value == nil {
+		return Number(n1.fval - n2.fval)
+	}
+	return exactArithmetic(n1, n2, (*big.Int).Sub, (*big.Rat).Sub)
+}
+
+// numberMul - return the product of the numbers
+func numberMul(n1 *Object, n2 *Object) *Object {
+	if isFixnum(n1) && isFixnum(n2) {
+		a, b := n1.ival, n2.ival
+		if a == 0 || b == 0 {
+			return Zero
+		}
+		if p := a * b; p/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64) {
+			return Int(p)
+		}
+	} else if n1.Value == nil || n2.Value == nil {
+		return Number(n1.fval * n2.fval)
+	}
+	return exactArithmetic(n1, n2, (*big.Int).Mul, (*big.Rat).Mul)
+}
+
+// numberDiv - return the quotient of the numbers. The quotient of integers is exact if the division is, and
+// otherwise a float, unless one of them is a rational. Division of exact numbers by zero is float division.
+func numberDiv(n1 *Object, n2 *Object) *Object {
+	if n1.Value == nil || n2.Value == nil || n2.fval == 0 {
+		return Number(n1.fval / n2.fval)
+	}
+	if isRational(n1) || isRational(n2) {
+		return Rational(new(big.Rat).Quo(ratValue(n1), ratValue(n2)))
+	}
+	if isFixnum(n1) && isFixnum(n2) {
+		a, b := n1.ival, n2.ival
+		if a%b == 0 && !(a == math.MinInt64 && b == -1) {
+			return Int(a / b)
+		}
+		if isApproximateExactly(n1) && isApproximateExactly(n2) {
+			return Number(n1.fval / n2.fval)
+		}
+	}
+	q := new(big.Rat).SetFrac(bigValue(n1), bigValue(n2))
+	if q.IsInt() {
+		return BigInt(q.Num())
+	}
+	f, _ := q.Float64()
+	return Number(f)
+}
+
+// numberPow - return the first number raised to the power of the second. The result is exact when the base
+// is exact and the exponent a non-negative integer, and an argument-error if that result would be too large.
+func numberPow(n1 *Object, n2 *Object) (*Object, error) {
+	if n1.Value != nil && n2.Value != nil && !isRational(n2) && bigValue(n2).Sign() >= 0 {
+		e := bigValue(n2)
+		if r, ok := n1.Value.(*big.Rat); ok {
+			if err := checkPowBits(r.Num(), e); err != nil {
+				return nil, err
+			}
+			if err := checkPowBits(r.Denom(), e); err != nil {
+				return nil, err
+			}
+			num := new(big.Int).Exp(r.Num(), e, nil)
+			return Rational(new(big.Rat).SetFrac(num, new(big.Int).Exp(r.Denom(), e, nil))), nil
+		}
+		if err := checkPowBits(bigValue(n1), e); err != nil {
+			return nil, err
+		}
+		return BigInt(new(big.Int).Exp(bigValue(n1), e, nil)), nil
+	}
+	return Number(math.Pow(n1.fval, n2.fval)), nil
+}
+
+// checkPowBits - fail if the base raised to the exponent can have more than maxIntegerBits bits. The powers of 0, 1
+// and -1 are always small.
+func checkPowBits(base *big.Int, e *big.Int) error {
+	if base.CmpAbs(big.NewInt(1)) <= 0 {
+		return nil
+	}
+	if !e.IsInt64() || e.Int64() > maxIntegerBits || int64(base.BitLen())*e.Int64() > maxIntegerBits {
+		return Error(ArgumentErrorKey, "The result of ** is too large, the exponent is ", BigInt(new(big.Int).Set(e)))
+	}
+	return nil
+}
+
+// bitwise - apply the bitwise operation to the integers
+func bitwise(n1 *Object, n2 *Object, fixOp func(a, b int64) int64, bigOp func(z, x, y *big.Int) *big.Int) (*Object, error) {
+	if isFixnum(n1) && isFixnum(n2) {
+		return Int(fixOp(n1.ival, n2.ival)), nil
+	}
+	a, err := integerValue(n1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := integerValue(n2)
+	if err != nil {
+		return nil, err
+	}
+	return BigInt(bigOp(new(big.Int), a, b)), nil
+}
+
+// shift - shift the integer left by the count of bits, or right if the count is negative. Right shifts are
+// arithmetic, rounding towards negative infinity.
+func shift(n *Object, count *Object) (*Object, error) {
+	if !isFixnum(count) {
+		return nil, Error(ArgumentErrorKey, "Expected a shift count, got ", count)
+	}
+	c := count.ival
+	if isFixnum(n) {
+		a := n.ival
+		if c <= 0 {
+			if c < -63 {
+				c = -63
+			}
+			return Int(a >> uint(-c)), nil
+		}
+		if c < 63 && (a<<uint(c))>>uint(c) == a {
+			return Int(a << uint(c)), nil
+		}
+	}
+	a, err := integerValue(n)
+	if err != nil {
+		return nil, err
+	}
+	if c < 0 {
+		return BigInt(new(big.Int).Rsh(a, uint(-c))), nil
+	}
+	if a.Sign() != 0 && c > maxIntegerBits-int64(a.BitLen()) {
+		return nil, Error(ArgumentErrorKey, "The result of the shift is too large, the count is ", count)
+	}
+	return BigInt(new(big.Int).Lsh(a, uint(c))), nil
+}
+
 var randomGenerator = rand.New(rand.NewSource(1))
 
 func RandomSeed(n int64) {
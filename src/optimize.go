@@ -0,0 +1,356 @@
+package vile
+
+// The optimizer rewrites the instructions of compiled code when the runtime is in optimize mode (the -optimize
+// flag). It folds calls of pure primitives on literals, drops the branch of an if whose predicate is a literal,
+// removes instructions whose result is popped right away, collapses chains of jumps, and calls the primitives
+// known at compile time directly, rather than through their globals, from tail position. The code is then stripped
+// of the instructions that can no longer be reached, and the common pairs of instructions are fused into the
+// superinstructions globalcall and localjumpfalse.
+//
+// Like the rest of optimize mode, this assumes correct code: a global bound to a primitive when a function is
+// compiled is taken to stay bound to it.
+
+// the primitives whose result only depends on their arguments, and can be computed at compile time. ** and << are
+// not folded: their result can be much larger than their arguments, and is better computed when the code runs.
+var pureFunctions = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "inc": true, "dec": true,
+	"=": true, "equal?": true, "<": true, "<=": true, ">": true, ">=": true, "compare": true,
+	"&": true, "|": true, "^": true, ">>": true,
+	"round": true, "ceil": true, "floor": true, "to_int": true, "to_float": true,
+	"log": true, "log10": true, "sin": true, "cos": true,
+	"integer?": true, "exact?": true, "float?": true, "type": true,
+	"len": true, "string-ref": true, "string-index": true, "string-contains?": true, "string-prefix?": true,
+	"string-suffix?": true, "string-upcase": true, "string-downcase": true,
+}
+
+// instruction - a decoded instruction. The targets of jumps and handlers are indices of instructions, not offsets.
+type instruction struct {
+	op       int
+	args     []int
+	target   int   // for jump, jumpfalse and localjumpfalse
+	handlers []int // for pushhandler, the target of each entry of its table
+	loc      *location
+}
+
+// optimizeCode - optimize the code, and the code of the functions it creates
+func (rt *Runtime) optimizeCode(code *Code) {
+	instrs, ok := decodeInstructions(code)
+	if !ok {
+		return
+	}
+	for _, in := range instrs {
+		if in.op == opcodeClosure {
+			rt.optimizeCode(rt.getConstant(in.args[0]).code)
+		}
+	}
+	// a rewrite, or the removal of the code it made unreachable, can make another one possible
+	for {
+		changed := false
+		for i := 0; i < len(instrs); i++ {
+			if rewritten, ok := rt.rewriteAt(instrs, i); ok {
+				instrs = rewritten
+				changed = true
+			}
+		}
+		count := len(instrs)
+		instrs = removeUnreachable(instrs)
+		if !changed && len(instrs) == count {
+			break
+		}
+	}
+	code.encodeInstructions(fuseInstructions(instrs))
+}
+
+// jumpOperand - return the index of the offset in the operands of the instruction, or -1 if it doesn't jump
+func jumpOperand(op int) int {
+	switch op {
+	case opcodeJump, opcodeJumpFalse:
+		return 0
+	case opcodeLocalJumpFalse:
+		return 2
+	}
+	return -1
+}
+
+// fuseInstructions - replace global then call with globalcall, and local then jumpfalse with localjumpfalse,
+// unless something jumps to the second instruction of the pair
+func fuseInstructions(instrs []*instruction) []*instruction {
+	for i := 0; i+1 < len(instrs); i++ {
+		in, next := instrs[i], instrs[i+1]
+		if isTarget(instrs, i+1) {
+			continue
+		}
+		if in.op == opcodeGlobal && next.op == opcodeCall {
+			instrs = splice(instrs, i, 2, &instruction{op: opcodeGlobalCall, args: []int{in.args[0], next.args[0]}, loc: next.loc})
+		} else if in.op == opcodeLocal && next.op == opcodeJumpFalse {
+			fused := &instruction{op: opcodeLocalJumpFalse, args: []int{in.args[0], in.args[1], 0}, target: next.target, loc: in.loc}
+			instrs = splice(instrs, i, 2, fused)
+		}
+	}
+	return instrs
+}
+
+func decodeInstructions(code *Code) ([]*instruction, bool) {
+	var instrs []*instruction
+	index := make(map[int]int) // pc -> instruction
+	for pc := 0; pc < len(code.ops); {
+		op := code.ops[pc]
+		n := instructionLength(op)
+		if pc+n > len(code.ops) {
+			return nil, false
+		}
+		index[pc] = len(instrs)
+		args := append([]int(nil), code.ops[pc+1:pc+n]...)
+		instrs = append(instrs, &instruction{op: op, args: args, loc: code.locationOf(pc)})
+		pc += n
+	}
+	for pc, i := range index {
+		in := instrs[i]
+		if k := jumpOperand(in.op); k >= 0 {
+			t, ok := index[pc+in.args[k]]
+			if !ok {
+				return nil, false
+			}
+			in.target = t
+		}
+		switch in.op {
+		case opcodePushHandler:
+			table := code.rt.getConstant(in.args[0])
+			for k := 1; k < len(table.elements); k += 2 {
+				t, ok := index[pc+int(table.elements[k].fval)]
+				if !ok {
+					return nil, false
+				}
+				in.handlers = append(in.handlers, t)
+			}
+		}
+	}
+	return instrs, true
+}
+
+// encodeInstructions - replace the ops and locations of the code with the instructions
+func (code *Code) encodeInstructions(instrs []*instruction) {
+	pcs := make([]int, len(instrs))
+	pc := 0
+	for i, in := range instrs {
+		pcs[i] = pc
+		pc += instructionLength(in.op)
+	}
+	ops := make([]int, 0, pc)
+	var locations []codeLocation
+	var prev *location
+	for i, in := range instrs {
+		if in.loc != nil && in.loc != prev {
+			locations = append(locations, codeLocation{pcs[i], in.loc})
+			prev = in.loc
+		}
+		ops = append(ops, in.op)
+		switch in.op {
+		case opcodeJump, opcodeJumpFalse, opcodeLocalJumpFalse:
+			ops = append(ops, in.args[:jumpOperand(in.op)]...)
+			ops = append(ops, pcs[in.target]-pcs[i])
+		case opcodePushHandler:
+			table := code.rt.getConstant(in.args[0])
+			entries := make([]*Object, len(table.elements))
+			for k := 0; k < len(entries); k += 2 {
+				entries[k] = table.elements[k]
+				entries[k+1] = Number(float64(pcs[in.handlers[k/2]] - pcs[i]))
+			}
+			ops = append(ops, code.rt.putConstant(VectorFromElementsNoCopy(entries)))
+		default:
+			ops = append(ops, in.args...)
+		}
+	}
+	code.ops = ops
+	code.locations = locations
+}
+
+// splice - replace the count instructions at i with the replacement. The jumps to any of the replaced instructions
+// go to the first instruction of the replacement, or to the one after it if the replacement is empty.
+func splice(instrs []*instruction, i int, count int, replacement ...*instruction) []*instruction {
+	delta := len(replacement) - count
+	remap := func(t int) int {
+		if t >= i+count {
+			return t + delta
+		}
+		if t > i {
+			return i
+		}
+		return t
+	}
+	for _, in := range instrs {
+		in.target = remap(in.target)
+		for k, t := range in.handlers {
+			in.handlers[k] = remap(t)
+		}
+	}
+	for _, in := range replacement {
+		in.target = remap(in.target)
+		if in.loc == nil && i < len(instrs) {
+			in.loc = instrs[i].loc
+		}
+	}
+	result := make([]*instruction, 0, len(instrs)+delta)
+	result = append(result, instrs[:i]...)
+	result = append(result, replacement...)
+	return append(result, instrs[i+count:]...)
+}
+
+// isTarget - return true if some instruction jumps to the one at i
+func isTarget(instrs []*instruction, i int) bool {
+	for _, in := range instrs {
+		if jumpOperand(in.op) >= 0 && in.target == i {
+			return true
+		}
+		for _, t := range in.handlers {
+			if t == i {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyTarget - return true if some instruction jumps into the instructions from i to j, not including i
+func anyTarget(instrs []*instruction, i int, j int) bool {
+	for k := i + 1; k <= j; k++ {
+		if isTarget(instrs, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// knownPrimitive - return the primitive the global is bound to, if it is one that can be found by its name
+func (rt *Runtime) knownPrimitive(sym *Object) *primitive {
+	return rt.primitiveNamed(sym.text)
+}
+
+// primitiveNamed - return the primitive bound to the global of its name in the runtime, or nil if there is none.
+// Each runtime defines primitives of its own, so they are looked up in its globals.
+func (rt *Runtime) primitiveNamed(name string) *primitive {
+	val := rt.GetGlobal(Intern(name))
+	if val == nil || val.primitive == nil || val.primitive.name != name {
+		return nil
+	}
+	return val.primitive
+}
+
+// rewriteAt - apply the first rewrite that matches the instructions at i, returning the rewritten instructions
+func (rt *Runtime) rewriteAt(instrs []*instruction, i int) ([]*instruction, bool) {
+	in := instrs[i]
+	var next *instruction
+	if i+1 < len(instrs) {
+		next = instrs[i+1]
+	}
+	switch in.op {
+	case opcodeLiteral, opcodeLocal, opcodeClosure:
+		if next == nil || isTarget(instrs, i+1) {
+			break
+		}
+		if next.op == opcodePop {
+			return splice(instrs, i, 2), true
+		}
+		if in.op == opcodeLiteral && next.op == opcodeJumpFalse {
+			if rt.getConstant(in.args[0]) == False {
+				return splice(instrs, i, 2, &instruction{op: opcodeJump, args: []int{0}, target: next.target}), true
+			}
+			return splice(instrs, i, 2), true
+		}
+	case opcodeJump, opcodeJumpFalse:
+		// follow the chain of jumps to its end, stopping at a loop
+		for seen := 0; instrs[in.target].op == opcodeJump && instrs[in.target] != in && seen < len(instrs); seen++ {
+			in.target = instrs[in.target].target
+		}
+		if in.op == opcodeJump && instrs[in.target].op == opcodeReturn {
+			return splice(instrs, i, 1, &instruction{op: opcodeReturn}), true
+		}
+		if in.target == i+1 {
+			if in.op == opcodeJump {
+				return splice(instrs, i, 1), true
+			}
+			return splice(instrs, i, 1, &instruction{op: opcodePop}), true
+		}
+	case opcodeCall, opcodeTailCall:
+		if i == 0 || instrs[i-1].op != opcodeGlobal || isTarget(instrs, i) {
+			break
+		}
+		prim := rt.knownPrimitive(rt.getConstant(instrs[i-1].args[0]))
+		if prim == nil {
+			break
+		}
+		argc := in.args[0]
+		if val, ok := rt.fold(instrs, i-1, argc, prim); ok {
+			first := i - 1 - argc
+			replacement := []*instruction{{op: opcodeLiteral, args: []int{rt.putConstant(val)}}}
+			if in.op == opcodeTailCall {
+				replacement = append(replacement, &instruction{op: opcodeReturn})
+			}
+			return splice(instrs, first, argc+2, replacement...), true
+		}
+		if in.op == opcodeTailCall {
+			fun := &Object{Type: FunctionType, primitive: prim}
+			return splice(instrs, i-1, 2,
+				&instruction{op: opcodeLiteral, args: []int{rt.putConstant(fun)}},
+				&instruction{op: opcodeCall, args: []int{argc}},
+				&instruction{op: opcodeReturn}), true
+		}
+	}
+	return instrs, false
+}
+
+// fold - compute the call of the pure primitive, whose global is at i, if its arguments are all literals
+func (rt *Runtime) fold(instrs []*instruction, i int, argc int, prim *primitive) (*Object, bool) {
+	first := i - argc
+	if !pureFunctions[prim.name] || first < 0 || anyTarget(instrs, first, i) {
+		return nil, false
+	}
+	// the arguments are pushed last to first, the first one is next to the function
+	args := make([]*Object, argc)
+	for k := 0; k < argc; k++ {
+		lit := instrs[i-1-k]
+		if lit.op != opcodeLiteral {
+			return nil, false
+		}
+		args[k] = rt.getConstant(lit.args[0])
+	}
+	val, err := rt.newVM(0).callPrimitive(prim, args)
+	if err != nil {
+		// the error is left to happen when the code is run
+		return nil, false
+	}
+	return val, true
+}
+
+// removeUnreachable - remove the instructions that cannot be reached from the first one
+func removeUnreachable(instrs []*instruction) []*instruction {
+	reached := make([]bool, len(instrs))
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i >= len(instrs) || reached[i] {
+			continue
+		}
+		reached[i] = true
+		in := instrs[i]
+		switch in.op {
+		case opcodeReturn, opcodeThrow, opcodeTailCall:
+		case opcodeJump:
+			work = append(work, in.target)
+		case opcodeJumpFalse, opcodeLocalJumpFalse:
+			work = append(work, i+1, in.target)
+		case opcodePushHandler:
+			work = append(work, i+1)
+			work = append(work, in.handlers...)
+		default:
+			work = append(work, i+1)
+		}
+	}
+	for i := len(instrs) - 1; i >= 0; i-- {
+		if !reached[i] {
+			instrs = splice(instrs, i, 1)
+		}
+	}
+	return instrs
+}
@@ -0,0 +1,63 @@
+package vile
+
+import (
+	"strings"
+	"testing"
+)
+
+// compileOptimized - macroexpand and compile the source in the runtime with the optimizer on
+func compileOptimized(t *testing.T, rt *Runtime, src string) *Object {
+	t.Helper()
+	expr, err := Read(String(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expanded, err := rt.Macroexpand(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := rt.Compile(expanded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// TestOptimizeSeveralRuntimes - the primitives of every runtime are known to the optimizer, not only those of the
+// newest one, and a compiled module is bound to the primitives of the runtime that loads it
+func TestOptimizeSeveralRuntimes(t *testing.T) {
+	older := newTestRuntime(t)
+	newer := newTestRuntime(t)
+	for _, rt := range []*Runtime{older, newer} {
+		rt.SetFlags(true, false, false, false, false)
+		lap := compileOptimized(t, rt, "(+ 1 2)").code.decompile(true)
+		if !strings.Contains(lap, "(literal 3)") {
+			t.Errorf("(+ 1 2) was not folded:\n%s", lap)
+		}
+	}
+	thunk := compileOptimized(t, older, "(func (x) (car x))")
+	data, err := EncodeLVM([]*Object{thunk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thunks, err := newer.DecodeLVM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the literal primitive is listed as its global only if it is the one of the runtime
+	if lap := thunks[0].code.decompile(true); !strings.Contains(lap, "(global car)") {
+		t.Errorf("the decoded module does not use the car primitive of its runtime:\n%s", lap)
+	}
+}
+
+// TestOptimizeLargeResults - the primitives whose result can be much larger than their arguments are not folded, so
+// compiling them is quick whatever their arguments
+func TestOptimizeLargeResults(t *testing.T) {
+	rt := newTestRuntime(t)
+	rt.SetFlags(true, false, false, false, false)
+	for _, src := range []string{"(<< 1 100000000000)", "(** 2 1000000000)"} {
+		if lap := compileOptimized(t, rt, src).code.decompile(true); !strings.Contains(lap, "call") {
+			t.Errorf("%s was folded:\n%s", src, lap)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package vile
+
+import (
+	"hash/fnv"
+)
+
+// The persistent structs and vectors are immutable: assoc, dissoc and conj return new ones, which share all
+// but the changed path of their tree with the original. They can be shared by spawned code without locking.
+
+// PersistentStructType is the type of persistent structs, hash array mapped tries with the keys of <struct>
+var PersistentStructType = Intern("<persistent-struct>")
+
+// PersistentVectorType is the type of persistent vectors, tries of 32 elements wide nodes
+var PersistentVectorType = Intern("<persistent-vector>")
+
+const (
+	trieBits  = 5
+	trieWidth = 1 << trieBits
+	trieMask  = trieWidth - 1
+)
+
+type pstruct struct {
+	count int
+	root  *hamtNode
+}
+
+// hamtNode - a node of the trie. The bitmap has a bit set for each of the 32 hash slices present, and the
+// entries are in slice order. Below the last level of hash bits, the node is a list of colliding entries,
+// and the bitmap is unused.
+type hamtNode struct {
+	bitmap  uint32
+	entries []hamtEntry
+}
+
+// hamtEntry - either a key and its value, or a subtrie
+type hamtEntry struct {
+	hash  uint32
+	key   structKey
+	value *Object
+	node  *hamtNode
+}
+
+func keyHash(k structKey) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(k.keyType))
+	h.Write([]byte{0})
+	h.Write([]byte(k.keyValue))
+	return h.Sum32()
+}
+
+func bitIndex(bitmap uint32, bit uint32) int {
+	n := 0
+	for b := bitmap & (bit - 1); b != 0; b &= b - 1 {
+		n++
+	}
+	return n
+}
+
+func (node *hamtNode) get(hash uint32, key structKey, shift uint) (*Object, bool) {
+	for node != nil {
+		if shift >= 32 {
+			for _, e := range node.entries {
+				if e.key == key {
+					return e.value, true
+				}
+			}
+			return nil, false
+		}
+		bit := uint32(1) << ((hash >> shift) & trieMask)
+		if node.bitmap&bit == 0 {
+			return nil, false
+		}
+		e := node.entries[bitIndex(node.bitmap, bit)]
+		if e.node == nil {
+			if e.key == key {
+				return e.value, true
+			}
+			return nil, false
+		}
+		node, shift = e.node, shift+trieBits
+	}
+	return nil, false
+}
+
+// assoc - return a copy of the node with the key bound to the value, and whether the key is new
+func (node *hamtNode) assoc(hash uint32, key structKey, val *Object, shift uint) (*hamtNode, bool) {
+	if shift >= 32 {
+		entries := append([]hamtEntry(nil), node.entries...)
+		for i, e := range entries {
+			if e.key == key {
+				entries[i].value = val
+				return &hamtNode{entries: entries}, false
+			}
+		}
+		return &hamtNode{entries: append(entries, hamtEntry{hash: hash, key: key, value: val})}, true
+	}
+	bit := uint32(1) << ((hash >> shift) & trieMask)
+	idx := bitIndex(node.bitmap, bit)
+	if node.bitmap&bit == 0 {
+		entries := make([]hamtEntry, len(node.entries)+1)
+		copy(entries, node.entries[:idx])
+		entries[idx] = hamtEntry{hash: hash, key: key, value: val}
+		copy(entries[idx+1:], node.entries[idx:])
+		return &hamtNode{bitmap: node.bitmap | bit, entries: entries}, true
+	}
+	entries := append([]hamtEntry(nil), node.entries...)
+	e := entries[idx]
+	added := false
+	switch {
+	case e.node != nil:
+		entries[idx].node, added = e.node.assoc(hash, key, val, shift+trieBits)
+	case e.key == key:
+		entries[idx].value = val
+	default: // another key in the same slot: push both down a level
+		sub, _ := (&hamtNode{}).assoc(e.hash, e.key, e.value, shift+trieBits)
+		sub, _ = sub.assoc(hash, key, val, shift+trieBits)
+		entries[idx] = hamtEntry{node: sub}
+		added = true
+	}
+	return &hamtNode{bitmap: node.bitmap, entries: entries}, added
+}
+
+// dissoc - return a copy of the node without the key, or the node itself if it does not have the key. The
+// result is nil if the node is left empty.
+func (node *hamtNode) dissoc(hash uint32, key structKey, shift uint) *hamtNode {
+	if shift >= 32 {
+		for i, e := range node.entries {
+			if e.key == key {
+				if len(node.entries) == 1 {
+					return nil
+				}
+				entries := append(append([]hamtEntry(nil), node.entries[:i]...), node.entries[i+1:]...)
+				return &hamtNode{entries: entries}
+			}
+		}
+		return node
+	}
+	bit := uint32(1) << ((hash >> shift) & trieMask)
+	if node.bitmap&bit == 0 {
+		return node
+	}
+	idx := bitIndex(node.bitmap, bit)
+	e := node.entries[idx]
+	if e.node != nil {
+		sub := e.node.dissoc(hash, key, shift+trieBits)
+		if sub == e.node {
+			return node
+		}
+		if sub != nil {
+			entries := append([]hamtEntry(nil), node.entries...)
+			entries[idx].node = sub
+			return &hamtNode{bitmap: node.bitmap, entries: entries}
+		}
+	} else if e.key != key {
+		return node
+	}
+	if len(node.entries) == 1 {
+		return nil
+	}
+	entries := append(append([]hamtEntry(nil), node.entries[:idx]...), node.entries[idx+1:]...)
+	return &hamtNode{bitmap: node.bitmap &^ bit, entries: entries}
+}
+
+func (node *hamtNode) each(fn func(key structKey, val *Object)) {
+	if node == nil {
+		return
+	}
+	for _, e := range node.entries {
+		if e.node != nil {
+			e.node.each(fn)
+		} else {
+			fn(e.key, e.value)
+		}
+	}
+}
+
+func (s *pstruct) get(key structKey) (*Object, bool) {
+	return s.root.get(keyHash(key), key, 0)
+}
+
+func (s *pstruct) assoc(key structKey, val *Object) *pstruct {
+	root := s.root
+	if root == nil {
+		root = &hamtNode{}
+	}
+	root, added := root.assoc(keyHash(key), key, val, 0)
+	if added {
+		return &pstruct{s.count + 1, root}
+	}
+	return &pstruct{s.count, root}
+}
+
+func (s *pstruct) dissoc(key structKey) *pstruct {
+	if s.root == nil {
+		return s
+	}
+	root := s.root.dissoc(keyHash(key), key, 0)
+	if root == s.root {
+		return s
+	}
+	return &pstruct{s.count - 1, root}
+}
+
+// toStruct - return a <struct> with the bindings of the persistent struct
+func (s *pstruct) toStruct() *Object {
+	strct := MakeStruct(s.count)
+	s.root.each(func(key structKey, val *Object) {
+		strct.bindings.put(key, val)
+	})
+	return strct
+}
+
+func (s *pstruct) String() string {
+	return "#" + PersistentStructType.text + structToString(s.toStruct())
+}
+
+type pvector struct {
+	count int
+	shift uint // the bits of the index below the root
+	root  *pvNode
+}
+
+// pvNode - a node of a persistent vector: the leaves have values, the others children
+type pvNode struct {
+	children []*pvNode
+	values   []*Object
+}
+
+func (v *pvector) get(i int) *Object {
+	node := v.root
+	for level := v.shift; level > 0; level -= trieBits {
+		node = node.children[(i>>level)&trieMask]
+	}
+	return node.values[i&trieMask]
+}
+
+func (v *pvector) set(i int, val *Object) *pvector {
+	return &pvector{v.count, v.shift, v.root.set(v.shift, i, val)}
+}
+
+func (node *pvNode) set(level uint, i int, val *Object) *pvNode {
+	if level == 0 {
+		values := append([]*Object(nil), node.values...)
+		values[i&trieMask] = val
+		return &pvNode{values: values}
+	}
+	children := append([]*pvNode(nil), node.children...)
+	idx := (i >> level) & trieMask
+	children[idx] = children[idx].set(level-trieBits, i, val)
+	return &pvNode{children: children}
+}
+
+func (v *pvector) conj(val *Object) *pvector {
+	switch {
+	case v.root == nil:
+		return &pvector{1, 0, &pvNode{values: []*Object{val}}}
+	case v.count == 1<<(v.shift+trieBits): // the trie is full, add a level
+		root := &pvNode{children: []*pvNode{v.root, newPath(v.shift, val)}}
+		return &pvector{v.count + 1, v.shift + trieBits, root}
+	}
+	return &pvector{v.count + 1, v.shift, v.root.push(v.shift, v.count, val)}
+}
+
+// push - return a copy of the node with the value added at index i, the first free one
+func (node *pvNode) push(level uint, i int, val *Object) *pvNode {
+	if level == 0 {
+		return &pvNode{values: append(append([]*Object(nil), node.values...), val)}
+	}
+	idx := (i >> level) & trieMask
+	children := append([]*pvNode(nil), node.children...)
+	if idx < len(children) {
+		children[idx] = children[idx].push(level-trieBits, i, val)
+	} else {
+		children = append(children, newPath(level-trieBits, val))
+	}
+	return &pvNode{children: children}
+}
+
+func newPath(level uint, val *Object) *pvNode {
+	if level == 0 {
+		return &pvNode{values: []*Object{val}}
+	}
+	return &pvNode{children: []*pvNode{newPath(level-trieBits, val)}}
+}
+
+func (v *pvector) elements() []*Object {
+	elements := make([]*Object, 0, v.count)
+	var walk func(node *pvNode)
+	walk = func(node *pvNode) {
+		if node.children == nil {
+			elements = append(elements, node.values...)
+			return
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	if v.root != nil {
+		walk(v.root)
+	}
+	return elements
+}
+
+func (v *pvector) String() string {
+	return "#" + PersistentVectorType.text + vectorToString(VectorFromElementsNoCopy(v.elements()))
+}
+
+func persistentStruct(s *pstruct) *Object {
+	return NewObject(PersistentStructType, s)
+}
+
+func persistentVector(v *pvector) *Object {
+	return NewObject(PersistentVectorType, v)
+}
+
+func IsPersistentStruct(obj *Object) bool {
+	return obj.Type == PersistentStructType
+}
+
+func IsPersistentVector(obj *Object) bool {
+	return obj.Type == PersistentVectorType
+}
+
+// ToPersistent - return a persistent struct with the bindings of a <struct>, or a persistent vector with the
+// elements of a <vector> or <list>. Persistent structs and vectors are returned as they are.
+func ToPersistent(obj *Object) (*Object, error) {
+	switch obj.Type {
+	case PersistentStructType, PersistentVectorType:
+		return obj, nil
+	case StructType:
+		s := &pstruct{}
+		for _, e := range obj.bindings.entries {
+			s = s.assoc(e.key, e.value)
+		}
+		return persistentStruct(s), nil
+	case VectorType, ListType:
+		elements, err := sequenceElements(obj)
+		if err != nil {
+			return nil, err
+		}
+		return persistentVector(pvectorOf(elements)), nil
+	}
+	return nil, Error(ArgumentErrorKey, "Expected a <struct>, <vector> or <list> to make persistent, got a ", obj.Type)
+}
+
+func pvectorOf(elements []*Object) *pvector {
+	v := &pvector{}
+	for _, o := range elements {
+		v = v.conj(o)
+	}
+	return v
+}
+
+// vectorIndex - return the index as an int, if it is in the range of a vector of the length
+func vectorIndex(idx *Object, length int) (int, error) {
+	i, err := AsIntValue(idx)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i >= length {
+		return 0, Error(ArgumentErrorKey, "Vector index out of range: ", idx)
+	}
+	return i, nil
+}
+
+// Assoc - return a copy of the struct with the key bound to the value, or of the vector with the element at the
+// index replaced by it. An index equal to the length of the vector appends the value. Persistent structs and
+// vectors share their structure with the copy; other structs and vectors are copied in full.
+func Assoc(coll *Object, key *Object, val *Object) (*Object, error) {
+	switch coll.Type {
+	case StructType, PersistentStructType:
+		if !IsValidStructKey(key) {
+			return nil, Error(ArgumentErrorKey, "Bad struct key: ", key)
+		}
+		if coll.Type == StructType {
+			strct := copyStruct(coll)
+			Put(strct, key, val)
+			return strct, nil
+		}
+		return persistentStruct(coll.Value.(*pstruct).assoc(newStructKey(key), val)), nil
+	case VectorType:
+		if i, err := AsIntValue(key); err == nil && i == len(coll.elements) {
+			return Conj(coll, val)
+		}
+		i, err := vectorIndex(key, len(coll.elements))
+		if err != nil {
+			return nil, err
+		}
+		vec := CopyVector(coll)
+		vec.elements[i] = val
+		return vec, nil
+	case PersistentVectorType:
+		v := coll.Value.(*pvector)
+		if i, err := AsIntValue(key); err == nil && i == v.count {
+			return persistentVector(v.conj(val)), nil
+		}
+		i, err := vectorIndex(key, v.count)
+		if err != nil {
+			return nil, err
+		}
+		return persistentVector(v.set(i, val)), nil
+	}
+	return nil, Error(ArgumentErrorKey, "assoc expected a struct or vector, got a ", coll.Type)
+}
+
+// Dissoc - return a copy of the struct without the key
+func Dissoc(coll *Object, key *Object) (*Object, error) {
+	switch coll.Type {
+	case StructType:
+		strct := copyStruct(coll)
+		if IsValidStructKey(key) {
+			Unput(strct, key)
+		}
+		return strct, nil
+	case PersistentStructType:
+		if !IsValidStructKey(key) {
+			return coll, nil
+		}
+		s := coll.Value.(*pstruct)
+		if d := s.dissoc(newStructKey(key)); d != s {
+			return persistentStruct(d), nil
+		}
+		return coll, nil
+	}
+	return nil, Error(ArgumentErrorKey, "dissoc expected a struct, got a ", coll.Type)
+}
+
+// Conj - return the collection with the value added: at the end of a vector, at the front of a list, and, for
+// a [key value] entry, to a struct. Lists and persistent collections share their structure with the result.
+func Conj(coll *Object, val *Object) (*Object, error) {
+	switch coll.Type {
+	case ListType:
+		return Cons(val, coll), nil
+	case VectorType:
+		elements := make([]*Object, len(coll.elements), len(coll.elements)+1)
+		copy(elements, coll.elements)
+		return VectorFromElementsNoCopy(append(elements, val)), nil
+	case PersistentVectorType:
+		return persistentVector(coll.Value.(*pvector).conj(val)), nil
+	case StructType, PersistentStructType:
+		if !IsVector(val) || len(val.elements) != 2 {
+			return nil, Error(ArgumentErrorKey, "conj expected a [key value] entry for a struct, got ", val)
+		}
+		return Assoc(coll, val.elements[0], val.elements[1])
+	}
+	return nil, Error(ArgumentErrorKey, "conj expected a list, vector or struct, got a ", coll.Type)
+}
+
+func persistentEqual(o1 *Object, o2 *Object) bool {
+	if o1.Type == PersistentStructType {
+		s1, s2 := o1.Value.(*pstruct), o2.Value.(*pstruct)
+		if s1.count != s2.count {
+			return false
+		}
+		equal := true
+		s1.root.each(func(key structKey, val *Object) {
+			if val2, ok := s2.get(key); !ok || !Equal(val, val2) {
+				equal = false
+			}
+		})
+		return equal
+	}
+	v1, v2 := o1.Value.(*pvector), o2.Value.(*pvector)
+	if v1.count != v2.count {
+		return false
+	}
+	for i := 0; i < v1.count; i++ {
+		if !Equal(v1.get(i), v2.get(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func copyStruct(s *Object) *Object {
+	strct := MakeStruct(s.bindings.size())
+	for _, e := range s.bindings.entries {
+		strct.bindings.put(e.key, e.value)
+	}
+	return strct
+}
@@ -0,0 +1,209 @@
+package vile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Profiles are written in the gzipped protocol buffer format of pprof, as described by profile.proto in
+// github.com/google/pprof, so that `go tool pprof` can read them. Each vile function is a pprof function, with its
+// source file and first line, and each line of it seen in a sample is a location. The samples have three values:
+// their count, their time in nanoseconds, and the objects allocated.
+
+// the field numbers of the messages of profile.proto
+const (
+	pprofSampleType  = 1
+	pprofSample      = 2
+	pprofLocation    = 4
+	pprofFunction    = 5
+	pprofStringTable = 6
+	pprofTimeNanos   = 9
+	pprofDuration    = 10
+	pprofPeriodType  = 11
+	pprofPeriod      = 12
+	pprofDefaultType = 14
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocation = 1
+	pprofSampleValue    = 2
+
+	pprofLocationID   = 1
+	pprofLocationLine = 4
+
+	pprofLineFunction = 1
+	pprofLineLine     = 2
+
+	pprofFunctionID        = 1
+	pprofFunctionName      = 2
+	pprofFunctionSystem    = 3
+	pprofFunctionFilename  = 4
+	pprofFunctionStartLine = 5
+)
+
+// protobuf - an encoder of protocol buffer messages
+type protobuf struct {
+	data []byte
+}
+
+func (pb *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		pb.data = append(pb.data, byte(x)|0x80)
+		x >>= 7
+	}
+	pb.data = append(pb.data, byte(x))
+}
+
+// uint64 - encode a varint field. Zero values are left out, as they are the default.
+func (pb *protobuf) uint64(field int, x uint64) {
+	if x != 0 {
+		pb.varint(uint64(field) << 3)
+		pb.varint(x)
+	}
+}
+
+func (pb *protobuf) int64(field int, x int64) {
+	pb.uint64(field, uint64(x))
+}
+
+func (pb *protobuf) bytes(field int, b []byte) {
+	pb.varint(uint64(field)<<3 | 2)
+	pb.varint(uint64(len(b)))
+	pb.data = append(pb.data, b...)
+}
+
+func (pb *protobuf) message(field int, msg *protobuf) {
+	pb.bytes(field, msg.data)
+}
+
+// packed - encode a repeated varint field
+func (pb *protobuf) packed(field int, xs []uint64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	pb.bytes(field, packed.data)
+}
+
+// pprofWriter - the state of the encoding of a profile: the string table, and the ids of functions and locations
+type pprofWriter struct {
+	out       protobuf
+	strings   map[string]int64
+	functions map[*Code]uint64
+	locations map[profileLocation]uint64
+	profiles  map[*Code]*FunctionProfile
+}
+
+func (w *pprofWriter) string(s string) int64 {
+	idx, ok := w.strings[s]
+	if !ok {
+		idx = int64(len(w.strings))
+		w.strings[s] = idx
+		w.out.bytes(pprofStringTable, []byte(s))
+	}
+	return idx
+}
+
+func (w *pprofWriter) valueType(field int, typ string, unit string) {
+	var vt protobuf
+	vt.int64(pprofValueTypeType, w.string(typ))
+	vt.int64(pprofValueTypeUnit, w.string(unit))
+	w.out.message(field, &vt)
+}
+
+func (w *pprofWriter) function(code *Code) uint64 {
+	id, ok := w.functions[code]
+	if !ok {
+		id = uint64(len(w.functions) + 1)
+		w.functions[code] = id
+		name, file, line := code.name, "", 0
+		if fp := w.profiles[code]; fp != nil {
+			name, file, line = fp.Name, fp.File, fp.Line
+			if fp.Name == anonymousName && file != "" {
+				// anonymous functions are told apart by where they are
+				name = fmt.Sprintf("%s %s:%d", name, file, line)
+			}
+		}
+		var fn protobuf
+		fn.uint64(pprofFunctionID, id)
+		fn.int64(pprofFunctionName, w.string(name))
+		fn.int64(pprofFunctionSystem, w.string(name))
+		fn.int64(pprofFunctionFilename, w.string(file))
+		fn.int64(pprofFunctionStartLine, int64(line))
+		w.out.message(pprofFunction, &fn)
+	}
+	return id
+}
+
+func (w *pprofWriter) location(loc profileLocation) uint64 {
+	id, ok := w.locations[loc]
+	if !ok {
+		fid := w.function(loc.code)
+		id = uint64(len(w.locations) + 1)
+		w.locations[loc] = id
+		var line protobuf
+		line.uint64(pprofLineFunction, fid)
+		line.int64(pprofLineLine, int64(loc.line))
+		var l protobuf
+		l.uint64(pprofLocationID, id)
+		l.message(pprofLocationLine, &line)
+		w.out.message(pprofLocation, &l)
+	}
+	return id
+}
+
+// WritePprof - write the profile in the gzipped protocol buffer format read by pprof
+func (prof *Profile) WritePprof(out io.Writer) error {
+	w := &pprofWriter{
+		strings:   make(map[string]int64),
+		functions: make(map[*Code]uint64),
+		locations: make(map[profileLocation]uint64),
+		profiles:  make(map[*Code]*FunctionProfile),
+	}
+	for _, fp := range prof.Functions {
+		w.profiles[fp.code] = fp
+	}
+	w.string("") // the first string of the table must be empty
+	w.valueType(pprofSampleType, "samples", "count")
+	w.valueType(pprofSampleType, "time", "nanoseconds")
+	w.valueType(pprofSampleType, "allocations", "count")
+	for _, n := range prof.nodes {
+		var ids []uint64
+		for node := n; node != nil; node = node.caller {
+			ids = append(ids, w.location(node.loc))
+		}
+		var sample protobuf
+		sample.packed(pprofSampleLocation, ids)
+		sample.packed(pprofSampleValue, []uint64{uint64(n.samples), uint64(n.time), uint64(n.allocations)})
+		w.out.message(pprofSample, &sample)
+	}
+	w.out.int64(pprofTimeNanos, prof.Start.UnixNano())
+	w.out.int64(pprofDuration, int64(prof.Duration))
+	w.valueType(pprofPeriodType, "instructions", "count")
+	w.out.int64(pprofPeriod, profileInterval)
+	w.out.int64(pprofDefaultType, w.string("time"))
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(w.out.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// WriteFile - write the profile to the file, in the format read by pprof
+func (prof *Profile) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return Error(IOErrorKey, filename, ": ", err.Error())
+	}
+	if err := prof.WritePprof(f); err != nil {
+		f.Close()
+		return Error(IOErrorKey, filename, ": ", err.Error())
+	}
+	if err := f.Close(); err != nil {
+		return Error(IOErrorKey, filename, ": ", err.Error())
+	}
+	return nil
+}
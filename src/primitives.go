@@ -5,96 +5,219 @@ package vile
 import (
 	"fmt"
 	"math"
+	"math/big"
 	"os"
+	"strings"
 )
 
-func InitPrimitives() {
+// InitPrimitives - define the primitive functions and macros in the runtime
+func (rt *Runtime) InitPrimitives() {
 	/* Whene we define a primitive more than one we got *warning*, e.g
 		*** Warning: redefining  +  with a primitive
-		DefineFunction("+", vileAdd, NumberType, NumberType, NumberType) // +
-		DefineFunction("+", vileAdd, NumberType, NumberType, AnyType)    // +
+		rt.DefineFunction("+", vileAdd, NumberType, NumberType, NumberType) // +
+		rt.DefineFunction("+", vileAdd, NumberType, NumberType, AnyType)    // +
 	*/
 
-	DefineMacro("quasiquote", vileQuasiquote)
-
-	DefineGlobal("apply", Apply)
-	DefineGlobal("callcc", CallCC)
-	DefineGlobal("spawn", Spawn)
-
-	DefineFunction("eval", vileEval, NullType, StringType)
-	DefineFunction("exit", vileExit, NullType, NumberType)
-
-	DefineFunction("type", vileType, TypeType, AnyType)
-
-	DefineFunction("+", vileAdd, NumberType, NumberType, NumberType) // +
-	DefineFunction("-", vileSub, NumberType, NumberType, NumberType) // -
-	DefineFunction("*", vileMul, NumberType, NumberType, NumberType) // *
-	DefineFunction("/", vileDiv, NumberType, NumberType, NumberType) // /
-	DefineFunction("=", vileNumEqual, BooleanType, NumberType, NumberType) // =
-	DefineFunction("<=", vileNumLessEqual, BooleanType, NumberType, NumberType) // <=
-	DefineFunction(">=", vileNumGreaterEqual, BooleanType, NumberType, NumberType) // >=
-	DefineFunction(">", vileNumGreater, BooleanType, NumberType, NumberType) // >
-	DefineFunction("<", vileNumLess, BooleanType, NumberType, NumberType) // <
-
-	DefineFunction("&", vileBinaryAndOperator, NumberType, NumberType, NumberType)
-	DefineFunction("|", vileBinaryOrOperator, NumberType, NumberType, NumberType)
-	DefineFunction("^", vileBinaryXorOperator, NumberType, NumberType, NumberType)
-	DefineFunction("<<", vileBinaryLeftShiftOperator, NumberType, NumberType, NumberType)
-	DefineFunction(">>", vileBinaryRightShiftOperator, NumberType, NumberType, NumberType)
-
-	DefineFunction("len", vileLen, NumberType, StringType)
-
-	DefineFunction("cons", vileCons, ListType, AnyType, ListType)
-	DefineFunction("car", vileCar, AnyType, ListType)
-	DefineFunction("cdr", vileCdr, ListType, ListType)
-
-	DefineFunction("round", vileRound, NumberType, NumberType)
-	DefineFunction("ceil", vileCeil, NumberType, NumberType)
-	DefineFunction("floor", vileFloor, NumberType, NumberType)
-
-	DefineFunction("log", vileLog, NumberType, NumberType)
-	DefineFunction("sin", vileSin, NumberType, NumberType)
-	DefineFunction("cos", vileCos, NumberType, NumberType)
-
-	DefineFunction("inc", vileInc, NumberType, NumberType)
-	DefineFunction("dec", vileDec, NumberType, NumberType)
-
-	DefineFunction("compile", vileCompile, CodeType, AnyType)
-
-	DefineFunctionRestArgs("puts", vilePuts, NullType, AnyType)
-	DefineFunctionRestArgs("put", vilePut, NullType, AnyType)
-	DefineFunctionRestArgs("list", vileList, ListType, AnyType)
-	DefineFunctionRestArgs("concat", vileConcat, ListType, ListType)
-
-	DefineFunction("load", vileLoad, StringType, AnyType)
+	rt.defineRuntimeMacro("quasiquote", vileQuasiquote)
+	rt.DefineMacro("define-syntax", vileDefineSyntax)
+	rt.defineRuntimeMacro("syntax-rules", vileSyntaxRules)
+	rt.defineRuntimeFunction("syntax-expand", vileSyntaxExpand, AnyType, ListType, ListType)
+	rt.defineRuntimeFunction("macroexpand", vileMacroexpand, AnyType, AnyType)
+	rt.defineRuntimeFunction("macroexpand-1", vileMacroexpand1, AnyType, AnyType)
+	rt.defineRuntimeFunction("macroexpand-trace", vileMacroexpandTrace, ListType, AnyType)
+
+	rt.DefineGlobal("apply", Apply)
+	rt.DefineGlobal("callcc", CallCC)
+	rt.DefineGlobal("spawn", Spawn)
+
+	rt.defineRuntimeFunction("eval", vileEval, NullType, StringType)
+	rt.DefineFunction("exit", vileExit, NullType, NumberType)
+
+	rt.DefineFunction("type", vileType, TypeType, AnyType)
+	rt.DefineFunctionOptionalArgs("gensym", vileGensym, SymbolType, []*Object{AnyType}, Null)
+
+	rt.DefineFunctionRestArgs("+", vileAdd, NumberType, NumberType) // +
+	rt.DefineFunctionRestArgs("-", vileSub, NumberType, NumberType, NumberType) // -
+	rt.DefineFunctionRestArgs("*", vileMul, NumberType, NumberType) // *
+	rt.DefineFunctionRestArgs("/", vileDiv, NumberType, NumberType, NumberType) // /
+	rt.DefineFunctionRestArgs("=", vileEqual, BooleanType, AnyType, AnyType) // =
+	rt.DefineFunctionRestArgs("equal?", vileEqual, BooleanType, AnyType, AnyType)
+	rt.DefineFunctionRestArgs("<=", vileNumLessEqual, BooleanType, NumberType, NumberType) // <=
+	rt.DefineFunctionRestArgs(">=", vileNumGreaterEqual, BooleanType, NumberType, NumberType) // >=
+	rt.DefineFunctionRestArgs(">", vileNumGreater, BooleanType, NumberType, NumberType) // >
+	rt.DefineFunctionRestArgs("<", vileNumLess, BooleanType, NumberType, NumberType) // <
+	rt.DefineFunction("compare", vileCompare, NumberType, AnyType, AnyType)
+
+	rt.DefineFunction("&", vileBinaryAndOperator, NumberType, NumberType, NumberType)
+	rt.DefineFunction("|", vileBinaryOrOperator, NumberType, NumberType, NumberType)
+	rt.DefineFunction("^", vileBinaryXorOperator, NumberType, NumberType, NumberType)
+	rt.DefineFunction("<<", vileBinaryLeftShiftOperator, NumberType, NumberType, NumberType)
+	rt.DefineFunction(">>", vileBinaryRightShiftOperator, NumberType, NumberType, NumberType)
+
+	rt.DefineFunction("len", vileLen, NumberType, StringType)
+
+	rt.DefineFunction("string-ref", vileStringRef, CharacterType, StringType, NumberType)
+	rt.DefineFunctionOptionalArgs("substring", vileSubstring, StringType, []*Object{StringType, NumberType, AnyType}, Null)
+	rt.DefineFunction("string-index", vileStringIndex, NumberType, StringType, StringType)
+	rt.DefineFunction("string-contains?", vileStringContainsP, BooleanType, StringType, StringType)
+	rt.DefineFunction("string-prefix?", vileStringPrefixP, BooleanType, StringType, StringType)
+	rt.DefineFunction("string-suffix?", vileStringSuffixP, BooleanType, StringType, StringType)
+	rt.DefineFunction("string-upcase", vileStringUpcase, StringType, StringType)
+	rt.DefineFunction("string-downcase", vileStringDowncase, StringType, StringType)
+	rt.DefineFunctionOptionalArgs("string-trim", vileStringTrim, StringType, []*Object{StringType, AnyType}, Null)
+	rt.DefineFunctionOptionalArgs("string-replace", vileStringReplace, StringType, []*Object{StringType, StringType, StringType, NumberType}, MinusOne)
+	rt.DefineFunction("string-split", vileStringSplit, ListType, StringType, StringType)
+	rt.DefineFunctionOptionalArgs("string-join", vileStringJoin, StringType, []*Object{AnyType, StringType}, EmptyString)
+	rt.DefineFunction("to_string", vileToString, StringType, AnyType)
+	rt.DefineFunctionRestArgs("format", vileFormat, StringType, AnyType, StringType)
+	rt.DefineFunctionRestArgs("sprintf", vileFormat, StringType, AnyType, StringType)
+
+	rt.DefineFunction("string-builder", vileStringBuilder, StringBuilderType)
+	rt.DefineFunctionRestArgs("string-builder-append", vileStringBuilderAppend, StringBuilderType, AnyType, StringBuilderType)
+
+	rt.DefineFunction("regex", vileRegex, RegexType, StringType)
+	rt.DefineFunction("regex-match", vileRegexMatch, AnyType, AnyType, StringType)
+	rt.DefineFunction("regex-find-all", vileRegexFindAll, ListType, AnyType, StringType)
+	rt.DefineFunction("regex-split", vileRegexSplit, ListType, AnyType, StringType)
+	rt.defineRuntimeFunction("regex-replace", vileRegexReplace, StringType, AnyType, StringType, AnyType)
+
+	rt.defineRuntimeFunctionRestArgs("map", vileMap, AnyType, AnyType, AnyType, AnyType)
+	rt.defineRuntimeFunction("filter", vileFilter, AnyType, AnyType, AnyType)
+	rt.defineRuntimeFunction("reduce", vileReduce, AnyType, AnyType, AnyType, AnyType)
+	rt.defineRuntimeFunction("for-each", vileForEach, NullType, AnyType, AnyType)
+	rt.defineRuntimeFunctionKeyArgs("sort", vileSort, AnyType, []*Object{AnyType, AnyType, BooleanType}, []*Object{Null, False}, []*Object{Intern("comparator:"), Intern("stable:")})
+	rt.DefineFunction("take", vileTake, AnyType, NumberType, AnyType)
+	rt.DefineFunction("drop", vileDrop, AnyType, NumberType, AnyType)
+	rt.DefineFunctionRestArgs("zip", vileZip, ListType, AnyType, AnyType)
+	rt.defineRuntimeFunctionOptionalArgs("range", vileRange, ListType, []*Object{NumberType, AnyType, NumberType}, Null, One)
+	rt.DefineFunction("nth", vileNth, AnyType, AnyType, NumberType)
+	rt.defineRuntimeFunction("position", vilePosition, NumberType, AnyType, AnyType)
+
+	rt.DefineFunction("cons", vileCons, ListType, AnyType, ListType)
+	rt.DefineFunction("car", vileCar, AnyType, ListType)
+	rt.DefineFunction("cdr", vileCdr, ListType, ListType)
+
+	rt.DefineFunction("round", vileRound, NumberType, NumberType)
+	rt.DefineFunction("ceil", vileCeil, NumberType, NumberType)
+	rt.DefineFunction("floor", vileFloor, NumberType, NumberType)
+
+	rt.DefineFunction("integer?", vileIntegerP, BooleanType, AnyType)
+	rt.DefineFunction("exact?", vileExactP, BooleanType, AnyType)
+	rt.DefineFunction("float?", vileFloatP, BooleanType, AnyType)
+	rt.DefineFunction("to_int", vileToInt, NumberType, AnyType)
+	rt.DefineFunction("to_float", vileToFloat, NumberType, NumberType)
+	rt.DefineFunction("rational", vileRational, NumberType, NumberType, NumberType)
+
+	rt.DefineFunction("log", vileLog, NumberType, NumberType)
+	rt.DefineFunction("sin", vileSin, NumberType, NumberType)
+	rt.DefineFunction("cos", vileCos, NumberType, NumberType)
+
+	rt.DefineFunction("inc", vileInc, NumberType, NumberType)
+	rt.DefineFunction("dec", vileDec, NumberType, NumberType)
+
+	rt.defineRuntimeFunction("compile", vileCompile, CodeType, AnyType)
+
+	rt.DefineFunctionRestArgs("puts", vilePuts, NullType, AnyType)
+	rt.DefineFunctionRestArgs("put", vilePut, NullType, AnyType)
+	rt.DefineFunctionRestArgs("list", vileList, ListType, AnyType)
+	rt.DefineFunctionRestArgs("concat", vileConcat, ListType, ListType)
+
+	rt.defineRuntimeFunction("load", vileLoad, StringType, AnyType)
 
 	/* TESTS */
-	DefineFunctionRestArgs("struct", vileStruct, StructType, AnyType)
-	DefineFunction("make_struct", vileMakeStruct, StructType, NumberType)
+	rt.DefineFunctionRestArgs("struct", vileStruct, StructType, AnyType)
+	rt.defineRuntimeFunction("make_struct", vileMakeStruct, StructType, NumberType)
+	rt.DefineFunctionOptionalArgs("get", vileGet, AnyType, []*Object{AnyType, AnyType, AnyType}, Null)
+	rt.DefineFunction("has?", vileHasP, BooleanType, AnyType, AnyType)
+	rt.DefineFunction("put!", vilePutBang, StructType, StructType, AnyType, AnyType)
+	rt.DefineFunction("unput!", vileUnputBang, StructType, StructType, AnyType)
+	rt.DefineFunction("keys", vileKeys, ListType, AnyType)
+	rt.DefineFunction("values", vileValues, ListType, AnyType)
+	rt.DefineFunction("to_struct", vileToStruct, StructType, AnyType)
+
+	rt.DefineFunction("char?", vileCharP, BooleanType, AnyType)
+	rt.DefineFunction("to_char", vileToChar, CharacterType, AnyType)
+
+	rt.DefineFunction("reverse_list", vileReverseList, ListType, ListType)
+	rt.DefineFunction("reverse_string", vileReverseString, StringType, StringType)
+
+	rt.DefineFunction("**", vileExponentiation, NumberType, NumberType, NumberType)
+
+	rt.DefineFunction("log10", vileLog10, NumberType, NumberType)
+
+	rt.DefineFunction("vector_length", vileVectorLength, NumberType, AnyType)
+	rt.DefineFunction("vector-set!", vileVectorSetBang, VectorType, VectorType, NumberType, AnyType)
+	rt.DefineFunctionRestArgs("vector-append!", vileVectorAppendBang, VectorType, AnyType, VectorType)
+	rt.DefineFunction("to_vector", vileToVector, VectorType, AnyType)
+
+	rt.DefineFunction("persistent", vilePersistent, AnyType, AnyType)
+	rt.DefineFunctionRestArgs("assoc", vileAssoc, AnyType, AnyType, AnyType, AnyType, AnyType)
+	rt.DefineFunctionRestArgs("dissoc", vileDissoc, AnyType, AnyType, AnyType)
+	rt.DefineFunctionRestArgs("conj", vileConj, AnyType, AnyType, AnyType)
+
+	rt.DefineFunctionOptionalArgs("chan", vileChan, ChannelType, []*Object{NumberType}, Zero)
+	rt.defineRuntimeFunctionOptionalArgs("send", vileSend, BooleanType, []*Object{ChannelType, AnyType, AnyType}, Null)
+	rt.defineRuntimeFunctionOptionalArgs("recv", vileRecv, ListType, []*Object{ChannelType, AnyType}, Null)
+	rt.DefineFunction("close", vileClose, NullType, ChannelType)
+	rt.defineRuntimeFunction("select-channels", vileSelectChannels, ListType, VectorType, AnyType)
+	rt.DefineMacro("select", vileSelect)
+
+	rt.DefineMacro("defrecord", vileDefrecord)
+	rt.DefineFunction("record-type", vileRecordType, TypeType, TypeType, ListType, ListType)
+	rt.DefineFunction("record-constructor", vileRecordConstructor, FunctionType, TypeType)
+	rt.DefineFunction("record-predicate", vileRecordPredicate, FunctionType, TypeType)
+	rt.DefineFunction("record-accessor", vileRecordAccessor, FunctionType, TypeType, KeywordType)
+	rt.defineRuntimeFunctionOptionalArgs("await", vileAwait, AnyType, []*Object{TaskType, AnyType}, Null)
+
+	rt.DefineFunctionRestArgs("error", vileError, ErrorType, AnyType, AnyType)
+	rt.DefineFunctionRestArgs("throw", vileThrow, NullType, AnyType, AnyType)
+	rt.DefineFunction("error?", vileErrorP, BooleanType, AnyType)
+	rt.DefineFunction("error-key", vileErrorKey, KeywordType, ErrorType)
+	rt.DefineFunction("error-data", vileErrorData, AnyType, ErrorType)
+	rt.DefineFunction("error-message", vileErrorMessage, StringType, ErrorType)
+	rt.DefineFunction("error-context", vileErrorContext, AnyType, ErrorType)
+
+	rt.DefineFunctionOptionalArgs("json-read", vileJSONRead, AnyType, []*Object{StringType, TypeType}, KeywordType)
+	rt.defineRuntimeFunctionOptionalArgs("json-read-file", vileJSONReadFile, AnyType, []*Object{StringType, AnyType, TypeType}, Null, KeywordType)
+	rt.DefineFunctionOptionalArgs("json-write", vileJSONWrite, StringType, []*Object{AnyType, StringType, BooleanType}, EmptyString, False)
+}
 
-	DefineFunction("char?", vileCharP, BooleanType, AnyType)
-	DefineFunction("to_char", vileToChar, CharacterType, AnyType)
+func vileQuasiquote(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.expandQuasiquote(argv[0])
+}
 
-	DefineFunction("reverse_list", vileReverseList, ListType, ListType)
-	DefineFunction("reverse_string", vileReverseString, StringType, StringType)
+func vileDefineSyntax(argv []*Object) (*Object, error) {
+	return expandDefineSyntax(argv[0])
+}
 
-	DefineFunction("**", vileExponentiation, NumberType, NumberType, NumberType)
+func vileSyntaxRules(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.expandSyntaxRules(argv[0])
+}
 
-	DefineFunction("log10", vileLog10, NumberType, NumberType)
+func vileSyntaxExpand(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.SyntaxExpand(argv[0], argv[1])
+}
 
-//	DefineFunction("vector_length", vileVectorLength, NumberType, VectorType)
+func vileMacroexpand(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Macroexpand(argv[0])
+}
 
-	DefineFunction("to_vector", vileToVector, VectorType, AnyType)
+func vileMacroexpand1(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Macroexpand1(argv[0])
 }
 
-func vileQuasiquote(argv []*Object) (*Object, error) {
-	return expandQuasiquote(argv[0])
+func vileMacroexpandTrace(rt *Runtime, argv []*Object) (*Object, error) {
+	_, steps, err := rt.MacroexpandTrace(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	return ListFromValues(steps), nil
 }
 
-func vileEval(argv []*Object) (*Object, error) {
-	err := RunStringEval(argv[0].text)
+func vileEval(rt *Runtime, argv []*Object) (*Object, error) {
+	err := rt.RunStringEval(argv[0].text)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return argv[0], nil
@@ -112,90 +235,323 @@ func vileType(argv []*Object) (*Object, error) {
 	return argv[0].Type, nil
 }
 
-func vileAdd(argv []*Object) (*Object, error) {
-	return Number(argv[0].fval + argv[1].fval), nil
+func vileGensym(argv []*Object) (*Object, error) {
+	switch prefix := argv[0]; prefix.Type {
+	case NullType:
+		return Gensym("G"), nil
+	case StringType, SymbolType:
+		return Gensym(prefix.text), nil
+	}
+	return nil, Error(ArgumentErrorKey, "gensym expected a <string> or <symbol>, got a ", argv[0].Type)
 }
 
+func vileAdd(argv []*Object) (*Object, error) {
+	if len(argv) == 2 {
+		return numberAdd(argv[0], argv[1]), nil
+	}
+	sum := Zero
+	for _, n := range argv {
+		sum = numberAdd(sum, n)
+	}
+	return sum, nil
+}
 
 func vileBinaryAndOperator(argv []*Object) (*Object, error) {
-	x := int(argv[0].fval) & int(argv[1].fval)
-	return Number(float64(x)), nil
+	return bitwise(argv[0], argv[1], func(a, b int64) int64 { return a & b }, (*big.Int).And)
 }
 
 func vileBinaryOrOperator(argv []*Object) (*Object, error) {
-	x := int(argv[0].fval) | int(argv[1].fval)
-	return Number(float64(x)), nil
+	return bitwise(argv[0], argv[1], func(a, b int64) int64 { return a | b }, (*big.Int).Or)
 }
 
 func vileBinaryXorOperator(argv []*Object) (*Object, error) {
-	x := int(argv[0].fval) ^ int(argv[1].fval)
-	return Number(float64(x)), nil
+	return bitwise(argv[0], argv[1], func(a, b int64) int64 { return a ^ b }, (*big.Int).Xor)
 }
 
 func vileBinaryLeftShiftOperator(argv []*Object) (*Object, error) {
-	x := int(argv[0].fval) << int(argv[1].fval)
-	return Number(float64(x)), nil
+	return shift(argv[0], argv[1])
 }
 
 func vileBinaryRightShiftOperator(argv []*Object) (*Object, error) {
-	x := int(argv[0].fval) >> int(argv[1].fval)
-	return Number(float64(x)), nil
+	return shift(argv[0], numberSub(Zero, argv[1]))
 }
 
+// vileSub - subtract the rest of the numbers from the first, or negate it when there are no others
 func vileSub(argv []*Object) (*Object, error) {
-	return Number(argv[0].fval - argv[1].fval), nil
+	if len(argv) == 1 {
+		return numberSub(Zero, argv[0]), nil
+	}
+	diff := argv[0]
+	for _, n := range argv[1:] {
+		diff = numberSub(diff, n)
+	}
+	return diff, nil
 }
 
 func vileMul(argv []*Object) (*Object, error) {
-	return Number(argv[0].fval * argv[1].fval), nil
+	if len(argv) == 2 {
+		return numberMul(argv[0], argv[1]), nil
+	}
+	product := One
+	for _, n := range argv {
+		product = numberMul(product, n)
+	}
+	return product, nil
 }
 
+// vileDiv - divide the first number by the rest of them, or return its reciprocal when there are no others
 func vileDiv(argv []*Object) (*Object, error) {
-	return Number(argv[0].fval / argv[1].fval), nil
+	if len(argv) == 1 {
+		return numberDiv(One, argv[0]), nil
+	}
+	quotient := argv[0]
+	for _, n := range argv[1:] {
+		quotient = numberDiv(quotient, n)
+	}
+	return quotient, nil
 }
 
-func vileNumEqual(argv []*Object) (*Object, error) {
-	if NumberEqual(argv[0].fval, argv[1].fval) {
-		return True, nil
+// vileEqual - true if each object is Equal to the next one
+func vileEqual(argv []*Object) (*Object, error) {
+	for i := 1; i < len(argv); i++ {
+		if !Equal(argv[i-1], argv[i]) {
+			return False, nil
+		}
 	}
+	return True, nil
+}
 
-	return False, nil
+// numberChain - true if the test holds for the comparison of each number to the next one
+func numberChain(argv []*Object, test func(c int) bool) *Object {
+	for i := 1; i < len(argv); i++ {
+		if c, ok := numberCompare(argv[i-1], argv[i]); !ok || !test(c) {
+			return False
+		}
+	}
+	return True
 }
 
 func vileNumLess(argv []*Object) (*Object, error) {
-	if argv[0].fval < argv[1].fval {
-		return True, nil
+	return numberChain(argv, func(c int) bool { return c < 0 }), nil
+}
+
+func vileNumLessEqual(argv []*Object) (*Object, error) {
+	return numberChain(argv, func(c int) bool { return c <= 0 }), nil
+}
+
+func vileNumGreater(argv []*Object) (*Object, error) {
+	return numberChain(argv, func(c int) bool { return c > 0 }), nil
+}
+
+func vileNumGreaterEqual(argv []*Object) (*Object, error) {
+	return numberChain(argv, func(c int) bool { return c >= 0 }), nil
+}
+
+func vileCompare(argv []*Object) (*Object, error) {
+	c, err := Compare(argv[0], argv[1])
+	if err != nil {
+		return nil, err
 	}
+	return Int(int64(c)), nil
+}
 
-	return False, nil
+func vileLen(argv []*Object) (*Object, error) {
+	return Number(float64(StringLength(argv[0].text))), nil
 }
 
-func vileNumLessEqual(argv []*Object) (*Object, error) {
-	if argv[0].fval <= argv[1].fval {
-		return True, nil
+func vileStringRef(argv []*Object) (*Object, error) {
+	c := StringRef(argv[0], IntValue(argv[1]))
+	if c == Null {
+		return nil, Error(ArgumentErrorKey, "string-ref: index out of range: ", argv[1])
 	}
+	return c, nil
+}
 
-	return False, nil
+func vileSubstring(argv []*Object) (*Object, error) {
+	end := StringLength(argv[0].text)
+	if argv[2] != Null {
+		if !IsNumber(argv[2]) {
+			return nil, Error(ArgumentErrorKey, "substring expected a <number> for argument 3, got a ", argv[2].Type)
+		}
+		end = IntValue(argv[2])
+	}
+	return Substring(argv[0], IntValue(argv[1]), end)
 }
 
-func vileNumGreater(argv []*Object) (*Object, error) {
-	if argv[0].fval > argv[1].fval {
+func vileStringIndex(argv []*Object) (*Object, error) {
+	return Number(float64(StringIndex(argv[0], argv[1]))), nil
+}
+
+func vileStringContainsP(argv []*Object) (*Object, error) {
+	if strings.Contains(argv[0].text, argv[1].text) {
 		return True, nil
 	}
-
 	return False, nil
 }
 
-func vileNumGreaterEqual(argv []*Object) (*Object, error) {
-	if argv[0].fval >= argv[1].fval {
+func vileStringPrefixP(argv []*Object) (*Object, error) {
+	if strings.HasPrefix(argv[0].text, argv[1].text) {
 		return True, nil
 	}
+	return False, nil
+}
 
+func vileStringSuffixP(argv []*Object) (*Object, error) {
+	if strings.HasSuffix(argv[0].text, argv[1].text) {
+		return True, nil
+	}
 	return False, nil
 }
 
-func vileLen(argv []*Object) (*Object, error) {
-	return Number(float64(len(argv[0].text))), nil
+func vileStringUpcase(argv []*Object) (*Object, error) {
+	return String(strings.ToUpper(argv[0].text)), nil
+}
+
+func vileStringDowncase(argv []*Object) (*Object, error) {
+	return String(strings.ToLower(argv[0].text)), nil
+}
+
+/* (string-trim s [cutset]) - without a cutset, white space is trimmed */
+func vileStringTrim(argv []*Object) (*Object, error) {
+	if argv[1] == Null {
+		return String(strings.TrimSpace(argv[0].text)), nil
+	}
+	if !IsString(argv[1]) {
+		return nil, Error(ArgumentErrorKey, "string-trim expected a <string> for argument 2, got a ", argv[1].Type)
+	}
+	return String(strings.Trim(argv[0].text, argv[1].text)), nil
+}
+
+/* (string-replace s old new [count]) - without a count, every occurrence is replaced */
+func vileStringReplace(argv []*Object) (*Object, error) {
+	return String(strings.Replace(argv[0].text, argv[1].text, argv[2].text, IntValue(argv[3]))), nil
+}
+
+func vileStringSplit(argv []*Object) (*Object, error) {
+	return StringSplit(argv[0], argv[1])
+}
+
+func vileStringJoin(argv []*Object) (*Object, error) {
+	return StringJoin(argv[0], argv[1])
+}
+
+func vileToString(argv []*Object) (*Object, error) {
+	return ToString(argv[0])
+}
+
+func vileFormat(argv []*Object) (*Object, error) {
+	s, err := Format(argv[0].text, argv[1:])
+	if err != nil {
+		return nil, err
+	}
+	return String(s), nil
+}
+
+func vileRegex(argv []*Object) (*Object, error) {
+	return Regex(argv[0].text)
+}
+
+func vileRegexMatch(argv []*Object) (*Object, error) {
+	return RegexMatch(argv[0], argv[1])
+}
+
+func vileRegexFindAll(argv []*Object) (*Object, error) {
+	return RegexFindAll(argv[0], argv[1])
+}
+
+func vileRegexSplit(argv []*Object) (*Object, error) {
+	return RegexSplit(argv[0], argv[1])
+}
+
+func vileRegexReplace(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.RegexReplace(argv[0], argv[1], argv[2])
+}
+
+func vileMap(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Map(argv[0], argv[1:]...)
+}
+
+func vileFilter(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Filter(argv[0], argv[1])
+}
+
+func vileReduce(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Reduce(argv[0], argv[1], argv[2])
+}
+
+func vileForEach(rt *Runtime, argv []*Object) (*Object, error) {
+	err := rt.ForEach(argv[0], argv[1])
+	if err != nil {
+		return nil, err
+	}
+	return Null, nil
+}
+
+func vileSort(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Sort(argv[0], argv[1], argv[2] == True)
+}
+
+func vileTake(argv []*Object) (*Object, error) {
+	n, err := AsIntValue(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	return Take(n, argv[1])
+}
+
+func vileDrop(argv []*Object) (*Object, error) {
+	n, err := AsIntValue(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	return Drop(n, argv[1])
+}
+
+func vileZip(argv []*Object) (*Object, error) {
+	return Zip(argv...)
+}
+
+func vileRange(rt *Runtime, argv []*Object) (*Object, error) {
+	start, end := argv[0], argv[1]
+	if end == Null {
+		start, end = Zero, argv[0]
+	} else if !IsNumber(end) {
+		return nil, Error(ArgumentErrorKey, "range expected a <number> for argument 2, got a ", end.Type)
+	}
+	if n := math.Ceil((end.fval - start.fval) / argv[2].fval); n > 0 {
+		if err := rt.allocate(int64(math.Min(n, math.MaxInt64/2))); err != nil {
+			return nil, err
+		}
+	}
+	return Range(start, end, argv[2])
+}
+
+func vileNth(argv []*Object) (*Object, error) {
+	n, err := AsIntValue(argv[1])
+	if err != nil {
+		return nil, err
+	}
+	return Nth(argv[0], n)
+}
+
+func vilePosition(rt *Runtime, argv []*Object) (*Object, error) {
+	n, err := rt.Position(argv[0], argv[1])
+	if err != nil {
+		return nil, err
+	}
+	return Number(float64(n)), nil
+}
+
+func vileStringBuilder(argv []*Object) (*Object, error) {
+	return StringBuilder(), nil
+}
+
+func vileStringBuilderAppend(argv []*Object) (*Object, error) {
+	err := StringBuilderAppend(argv[0], argv[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return argv[0], nil
 }
 
 func vileCons(argv []*Object) (*Object, error) {
@@ -221,17 +577,79 @@ func vileCdr(argv []*Object) (*Object, error) {
 }
 
 func vileRound(argv []*Object) (*Object, error) {
+	if r, ok := argv[0].Value.(*big.Rat); ok {
+		return BigInt(roundRat(r)), nil
+	}
+	if IsExact(argv[0]) {
+		return argv[0], nil
+	}
 	return Number(math.Round(argv[0].fval)), nil
 }
 
 func vileCeil(argv []*Object) (*Object, error) {
+	if r, ok := argv[0].Value.(*big.Rat); ok {
+		return BigInt(ceilRat(r)), nil
+	}
+	if IsExact(argv[0]) {
+		return argv[0], nil
+	}
 	return Number(math.Ceil(argv[0].fval)), nil
 }
 
 func vileFloor(argv []*Object) (*Object, error) {
+	if r, ok := argv[0].Value.(*big.Rat); ok {
+		return BigInt(floorRat(r)), nil
+	}
+	if IsExact(argv[0]) {
+		return argv[0], nil
+	}
 	return Number(math.Floor(argv[0].fval)), nil
 }
 
+func vileIntegerP(argv []*Object) (*Object, error) {
+	if IsInt(argv[0]) {
+		return True, nil
+	}
+	return False, nil
+}
+
+func vileExactP(argv []*Object) (*Object, error) {
+	if IsExact(argv[0]) {
+		return True, nil
+	}
+	return False, nil
+}
+
+func vileFloatP(argv []*Object) (*Object, error) {
+	if IsFloat(argv[0]) {
+		return True, nil
+	}
+	return False, nil
+}
+
+func vileToInt(argv []*Object) (*Object, error) {
+	return ToInt(argv[0])
+}
+
+func vileToFloat(argv []*Object) (*Object, error) {
+	return ToFloat(argv[0])
+}
+
+func vileRational(argv []*Object) (*Object, error) {
+	num, err := integerValue(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	den, err := integerValue(argv[1])
+	if err != nil {
+		return nil, err
+	}
+	if den.Sign() == 0 {
+		return nil, Error(ArgumentErrorKey, "Rational with a zero denominator: ", argv[0], "/", argv[1])
+	}
+	return Rational(new(big.Rat).SetFrac(num, den)), nil
+}
+
 func vileLog(argv []*Object) (*Object, error) {
 	return Number(math.Log(argv[0].fval)), nil
 }
@@ -245,11 +663,11 @@ func vileCos(argv []*Object) (*Object, error) {
 }
 
 func vileInc(argv []*Object) (*Object, error) {
-	return Number(argv[0].fval + 1), nil
+	return numberAdd(argv[0], One), nil
 }
 
 func vileDec(argv []*Object) (*Object, error) {
-	return Number(argv[0].fval - 1), nil
+	return numberSub(argv[0], One), nil
 }
 
 /* FIXED: check the type and convert it to String and print it */
@@ -306,17 +724,17 @@ func vileConcat(argv []*Object) (*Object, error) {
 	return result, nil
 }
 
-func vileCompile(argv []*Object) (*Object, error) {
-	expanded, err := Macroexpand(argv[0])
+func vileCompile(rt *Runtime, argv []*Object) (*Object, error) {
+	expanded, err := rt.Macroexpand(argv[0])
 	if err != nil {
 		return nil, err
 	}
 
-	return Compile(expanded)
+	return rt.Compile(expanded)
 }
 
-func vileLoad(argv []*Object) (*Object, error) {
-	err := Load(argv[0].text)
+func vileLoad(rt *Runtime, argv []*Object) (*Object, error) {
+	err := rt.Load(argv[0].text)
 	return argv[0], err
 }
 
@@ -325,7 +743,10 @@ func vileStruct(argv []*Object) (*Object, error) {
 	return Struct(argv)
 }
 
-func vileMakeStruct(argv []*Object) (*Object, error) {
+func vileMakeStruct(rt *Runtime, argv []*Object) (*Object, error) {
+	if err := rt.allocate(int64(argv[0].fval)); err != nil {
+		return nil, err
+	}
 	return MakeStruct(int(argv[0].fval)), nil
 }
 
@@ -349,20 +770,285 @@ func vileReverseString(argv []*Object) (*Object, error) {
 }
 
 func vileExponentiation(argv []*Object) (*Object, error) {
-	return Number(math.Pow(argv[0].fval, argv[1].fval)), nil
+	return numberPow(argv[0], argv[1])
 }
 
 func vileLog10(argv []*Object) (*Object, error) {
 	return Number(math.Log10(argv[0].fval)), nil
 }
 
-/*
-func vileVectorLength(argv []Value) (Value, error) {
-	vec, _ := argv[0].(*Vector)
-	return Integer(len(vec.Elements)), nil
+func vileGet(argv []*Object) (*Object, error) {
+	coll := argv[0]
+	switch coll.Type {
+	case VectorType, PersistentVectorType:
+		if i, err := AsIntValue(argv[1]); err == nil {
+			if val, err := Nth(coll, i); err == nil {
+				return val, nil
+			}
+		}
+		return argv[2], nil
+	}
+	val, err := Get(coll, argv[1])
+	if err != nil || val != Null {
+		return val, err
+	}
+	if ok, _ := Has(coll, argv[1]); ok {
+		return Null, nil
+	}
+	return argv[2], nil
+}
+
+func vileHasP(argv []*Object) (*Object, error) {
+	ok, err := Has(argv[0], argv[1])
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return True, nil
+	}
+	return False, nil
+}
+
+func vilePutBang(argv []*Object) (*Object, error) {
+	if !IsValidStructKey(argv[1]) {
+		return nil, Error(ArgumentErrorKey, "Bad struct key: ", argv[1])
+	}
+	Put(argv[0], argv[1], argv[2])
+	return argv[0], nil
+}
+
+func vileUnputBang(argv []*Object) (*Object, error) {
+	if IsValidStructKey(argv[1]) {
+		Unput(argv[0], argv[1])
+	}
+	return argv[0], nil
+}
+
+func vileKeys(argv []*Object) (*Object, error) {
+	switch argv[0].Type {
+	case StructType:
+		return StructKeys(argv[0]), nil
+	case PersistentStructType:
+		return StructKeys(argv[0].Value.(*pstruct).toStruct()), nil
+	}
+	return nil, Error(ArgumentErrorKey, "keys expected a <struct> argument, got a ", argv[0].Type)
+}
+
+func vileValues(argv []*Object) (*Object, error) {
+	switch argv[0].Type {
+	case StructType:
+		return StructValues(argv[0]), nil
+	case PersistentStructType:
+		return StructValues(argv[0].Value.(*pstruct).toStruct()), nil
+	}
+	return nil, Error(ArgumentErrorKey, "values expected a <struct> argument, got a ", argv[0].Type)
+}
+
+func vileToStruct(argv []*Object) (*Object, error) {
+	return ToStruct(argv[0])
+}
+
+func vileVectorLength(argv []*Object) (*Object, error) {
+	switch argv[0].Type {
+	case VectorType:
+		return Number(float64(len(argv[0].elements))), nil
+	case PersistentVectorType:
+		return Number(float64(argv[0].Value.(*pvector).count)), nil
+	}
+	return nil, Error(ArgumentErrorKey, "vector_length expected a <vector> argument, got a ", argv[0].Type)
+}
+
+func vileVectorSetBang(argv []*Object) (*Object, error) {
+	i, err := vectorIndex(argv[1], len(argv[0].elements))
+	if err != nil {
+		return nil, err
+	}
+	argv[0].elements[i] = argv[2]
+	return argv[0], nil
+}
+
+func vileVectorAppendBang(argv []*Object) (*Object, error) {
+	argv[0].elements = append(argv[0].elements, argv[1:]...)
+	return argv[0], nil
+}
+
+func vilePersistent(argv []*Object) (*Object, error) {
+	return ToPersistent(argv[0])
+}
+
+func vileAssoc(argv []*Object) (*Object, error) {
+	if len(argv)%2 == 0 {
+		return nil, Error(ArgumentErrorKey, "assoc expected keys and values in pairs")
+	}
+	coll := argv[0]
+	for i := 1; i < len(argv); i += 2 {
+		var err error
+		if coll, err = Assoc(coll, argv[i], argv[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	return coll, nil
+}
+
+func vileDissoc(argv []*Object) (*Object, error) {
+	coll := argv[0]
+	for _, key := range argv[1:] {
+		var err error
+		if coll, err = Dissoc(coll, key); err != nil {
+			return nil, err
+		}
+	}
+	return coll, nil
+}
+
+func vileConj(argv []*Object) (*Object, error) {
+	coll := argv[0]
+	for _, val := range argv[1:] {
+		var err error
+		if coll, err = Conj(coll, val); err != nil {
+			return nil, err
+		}
+	}
+	return coll, nil
 }
-*/
 
 func vileToVector(argv []*Object) (*Object, error) {
 	return ToVector(argv[0])
 }
+
+/* (error key: data...) - the key is optional, and defaults to error:. More than one datum is joined into a message string */
+func vileError(argv []*Object) (*Object, error) {
+	key := ErrorKey
+	if IsKeyword(argv[0]) {
+		key = argv[0]
+		argv = argv[1:]
+	}
+	switch len(argv) {
+	case 0:
+		return MakeError(key), nil
+	case 1:
+		return MakeError(key, argv[0]), nil
+	default:
+		msg := ""
+		for _, o := range argv {
+			msg += o.String()
+		}
+		return MakeError(key, String(msg)), nil
+	}
+}
+
+func vileThrow(argv []*Object) (*Object, error) {
+	if len(argv) == 1 && IsError(argv[0]) {
+		if _, ok := argv[0].Value.(*Object); ok {
+			return nil, withContext(argv[0], nil) // the context of where it is thrown again replaces the old one
+		}
+		return nil, argv[0]
+	}
+	err, _ := vileError(argv)
+	return nil, err
+}
+
+func vileErrorP(argv []*Object) (*Object, error) {
+	if IsError(argv[0]) {
+		return True, nil
+	}
+	return False, nil
+}
+
+func vileErrorKey(argv []*Object) (*Object, error) {
+	return errorKey(argv[0]), nil
+}
+
+func vileErrorData(argv []*Object) (*Object, error) {
+	return errorPayload(argv[0]), nil
+}
+
+func vileErrorMessage(argv []*Object) (*Object, error) {
+	return String(errorMessage(argv[0])), nil
+}
+
+func vileErrorContext(argv []*Object) (*Object, error) {
+	return ErrorContext(argv[0]), nil
+}
+
+func vileJSONRead(argv []*Object) (*Object, error) {
+	keys := argv[1]
+	if keys != KeywordType && keys != StringType {
+		return nil, Error(ArgumentErrorKey, "json-read expected <keyword> or <string> for the keys, got ", keys)
+	}
+	return ReadJSON(strings.NewReader(argv[0].text), keys)
+}
+
+func vileJSONReadFile(rt *Runtime, argv []*Object) (*Object, error) {
+	keys := argv[2]
+	if keys != KeywordType && keys != StringType {
+		return nil, Error(ArgumentErrorKey, "json-read-file expected <keyword> or <string> for the keys, got ", keys)
+	}
+	return rt.ReadJSONFile(argv[0].text, argv[1], keys)
+}
+
+func vileJSONWrite(argv []*Object) (*Object, error) {
+	var buf strings.Builder
+	var options []WriteOption
+	if argv[2] == True {
+		options = append(options, SortKeys)
+	}
+	err := WriteJSON(&buf, argv[0], argv[1].text, options...)
+	if err != nil {
+		return nil, err
+	}
+	return String(buf.String()), nil
+}
+
+func vileChan(argv []*Object) (*Object, error) {
+	return Channel(int(argv[0].fval)), nil
+}
+
+func vileSend(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.ChannelSend(argv[0], argv[1], argv[2])
+}
+
+func vileRecv(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.ChannelReceive(argv[0], argv[1])
+}
+
+func vileClose(argv []*Object) (*Object, error) {
+	return Null, ChannelClose(argv[0])
+}
+
+func vileSelectChannels(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.ChannelSelect(argv[0], argv[1])
+}
+
+func vileSelect(argv []*Object) (*Object, error) {
+	return expandSelect(argv[0])
+}
+
+func vileDefrecord(argv []*Object) (*Object, error) {
+	return expandDefrecord(argv[0])
+}
+
+func vileRecordType(argv []*Object) (*Object, error) {
+	fields := listToVector(argv[1]).elements
+	types := listToVector(argv[2]).elements
+	if err := DefineRecord(argv[0], fields, types); err != nil {
+		return nil, err
+	}
+	return argv[0], nil
+}
+
+func vileRecordConstructor(argv []*Object) (*Object, error) {
+	return RecordConstructor(argv[0])
+}
+
+func vileRecordPredicate(argv []*Object) (*Object, error) {
+	return RecordPredicate(argv[0])
+}
+
+func vileRecordAccessor(argv []*Object) (*Object, error) {
+	return RecordAccessor(argv[0], argv[1])
+}
+
+func vileAwait(rt *Runtime, argv []*Object) (*Object, error) {
+	return rt.Await(argv[0], argv[1])
+}
@@ -0,0 +1,281 @@
+package vile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The profiler counts the calls of each function, and samples the vile call stack every profileInterval
+// instructions: the time elapsed and the objects allocated since the previous sample are charged to the function
+// executing (its self cost) and to every function with a call pending under it (their cumulative cost). The
+// samples keep the stacks they were taken in, with the source line of each call, for the pprof output.
+//
+// While profiling, the vm runs the instrumented loop even in optimize mode. Functions called back by primitives,
+// as by map or sort, are sampled with the calls pending in the code that called the primitive.
+
+// the number of instructions a vm executes between samples, when profiling
+const profileInterval = 256
+
+// the names given to the code of the top level forms, and to anonymous functions
+const (
+	topLevelName  = "(top level)"
+	anonymousName = "(anonymous code)"
+)
+
+// the number of calls kept in the stack of a sample, the outermost ones are dropped from deeper stacks
+const maxProfileDepth = 256
+
+type profiler struct {
+	lock      sync.Mutex
+	start     time.Time
+	functions map[*Code]*FunctionProfile
+	nodes     map[profileNodeKey]*profileNode
+	samples   int64
+}
+
+// profileLocation - a line in the code of a function
+type profileLocation struct {
+	code *Code
+	line int
+}
+
+// profileNode - a call stack, as a location and the stack of its caller. The samples taken in it are counted in
+// the node, so that the stacks seen during profiling are recorded once.
+type profileNode struct {
+	loc         profileLocation
+	caller      *profileNode
+	samples     int64
+	time        time.Duration
+	allocations int64
+}
+
+type profileNodeKey struct {
+	caller *profileNode
+	loc    profileLocation
+}
+
+// profileCaller - where the code calling a primitive was, kept for the vms created by the primitive
+type profileCaller struct {
+	env   *frame
+	pc    int
+	clock *time.Time     // the clock of the vm calling the primitive
+	outer *profileCaller // where the primitive that created the vm of the code was called, if it was
+}
+
+// FunctionProfile - the costs of a function, as measured by the profiler
+type FunctionProfile struct {
+	Name                  string
+	File                  string
+	Line                  int // the first line of the function
+	Calls                 int64
+	Self                  time.Duration
+	Cumulative            time.Duration
+	SelfAllocations       int64
+	CumulativeAllocations int64
+
+	code    *Code
+	sampled int64 // the last sample that counted the function in its cumulative cost
+}
+
+// Profile - the results of profiling a runtime
+type Profile struct {
+	Start     time.Time
+	Duration  time.Duration
+	Samples   int64
+	Sampled   time.Duration      // the time charged to the samples, the total of the self times
+	Functions []*FunctionProfile // sorted by self time, highest first
+
+	nodes []*profileNode
+}
+
+// StartProfiling - start counting calls and sampling the execution of the runtime, discarding any previous results
+func (rt *Runtime) StartProfiling() {
+	rt.profiler.Store(&profiler{
+		start:     time.Now(),
+		functions: make(map[*Code]*FunctionProfile),
+		nodes:     make(map[profileNodeKey]*profileNode),
+	})
+}
+
+// StopProfiling - stop profiling the runtime, and return the results, or nil if it wasn't profiling
+func (rt *Runtime) StopProfiling() *Profile {
+	p := rt.profiler.Swap(nil)
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	prof := &Profile{Start: p.start, Duration: time.Since(p.start), Samples: p.samples}
+	for _, fp := range p.functions {
+		prof.Functions = append(prof.Functions, fp)
+	}
+	sort.Slice(prof.Functions, func(i, j int) bool {
+		a, b := prof.Functions[i], prof.Functions[j]
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Cumulative != b.Cumulative {
+			return a.Cumulative > b.Cumulative
+		}
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return a.Name < b.Name
+	})
+	for _, n := range p.nodes {
+		if n.samples > 0 {
+			prof.nodes = append(prof.nodes, n)
+			prof.Sampled += n.time
+		}
+	}
+	return prof
+}
+
+// function - return the profile of the function whose code it is, creating it if needed. The lock must be held.
+func (p *profiler) function(code *Code, topLevel bool) *FunctionProfile {
+	fp, ok := p.functions[code]
+	if !ok {
+		fp = &FunctionProfile{Name: code.name, code: code}
+		if loc := code.definition(); loc != nil {
+			fp.File, fp.Line = loc.file, loc.line
+		}
+		if fp.Name == "" {
+			if topLevel {
+				fp.Name = topLevelName
+			} else {
+				fp.Name = anonymousName
+			}
+		}
+		p.functions[code] = fp
+	}
+	return fp
+}
+
+// called - count a call of the function whose code it is
+func (p *profiler) called(code *Code) {
+	p.lock.Lock()
+	p.function(code, false).Calls++
+	p.lock.Unlock()
+}
+
+// sample - charge the time and allocations since the previous sample of the vm to the stack at the pc
+func (p *profiler) sample(vm *vm, env *frame, pc int) {
+	now := time.Now()
+	elapsed := now.Sub(*vm.clock)
+	*vm.clock = now
+	allocations := vm.allocations
+	stack := vm.profileStack(env, pc)
+	if len(stack) == 0 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.samples++
+	var node *profileNode
+	for i := len(stack) - 1; i >= 0; i-- {
+		key := profileNodeKey{node, stack[i]}
+		n, ok := p.nodes[key]
+		if !ok {
+			n = &profileNode{loc: stack[i], caller: node}
+			p.nodes[key] = n
+		}
+		node = n
+	}
+	node.samples++
+	node.time += elapsed
+	node.allocations += allocations
+	complete := len(stack) < maxProfileDepth // then the outermost location is in the top level code
+	for i, loc := range stack {
+		fp := p.function(loc.code, complete && i == len(stack)-1)
+		if i == 0 {
+			fp.Self += elapsed
+			fp.SelfAllocations += allocations
+		}
+		if fp.sampled != p.samples {
+			fp.sampled = p.samples
+			fp.Cumulative += elapsed
+			fp.CumulativeAllocations += allocations
+		}
+	}
+}
+
+// profile - take a sample. Without limits to account for them, the allocations charged to the sample are then
+// reset.
+func (vm *vm) profile(executed int, env *frame, pc int) {
+	if vm.clock.IsZero() {
+		*vm.clock = time.Now()
+		return
+	}
+	if executed == 0 {
+		// no instruction was executed since the last sample: the time was spent in the primitive that created the
+		// vm, if it was, and not in its code
+		env = nil
+	}
+	vm.profiler.sample(vm, env, pc)
+	if vm.limiter == nil {
+		vm.allocations = 0
+	}
+}
+
+// profileStack - return the locations of the calls pending at the pc, innermost first, followed by those pending
+// where the primitives that created the vm were called
+func (vm *vm) profileStack(env *frame, pc int) []profileLocation {
+	stack := vm.profiled[:0]
+	caller := vm.rt.caller
+	for len(stack) < maxProfileDepth {
+		for f := env; f != nil && f.code != nil && len(stack) < maxProfileDepth; f = f.previous {
+			line := 0
+			if loc := f.code.locationOf(pc); loc != nil {
+				line = loc.line
+			}
+			stack = append(stack, profileLocation{f.code, line})
+			pc = f.pc - 1
+		}
+		if caller == nil {
+			break
+		}
+		env, pc = caller.env, caller.pc
+		caller = caller.outer
+	}
+	vm.profiled = stack
+	return stack
+}
+
+// Report - write a report of the profile, one function per line, sorted by self time
+func (prof *Profile) Report(w io.Writer) error {
+	percent := func(d time.Duration) float64 {
+		if prof.Sampled == 0 {
+			return 0
+		}
+		return 100 * float64(d) / float64(prof.Sampled)
+	}
+	_, err := fmt.Fprintf(w, "; profiled %v, %d samples totalling %v\n", prof.Duration.Round(time.Microsecond),
+		prof.Samples, prof.Sampled.Round(time.Microsecond))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%12s %6s %12s %6s %10s %10s %10s  %s\n", "self", "self%", "cum", "cum%", "calls", "allocs", "cum allocs", "function")
+	if err != nil {
+		return err
+	}
+	for _, fp := range prof.Functions {
+		name := fp.Name
+		if fp.File != "" {
+			name += " (" + fp.File + ":" + fmt.Sprint(fp.Line) + ")"
+		}
+		_, err = fmt.Fprintf(w, "%10.2fms %5.1f%% %10.2fms %5.1f%% %10d %10d %10d  %s\n",
+			milliseconds(fp.Self), percent(fp.Self), milliseconds(fp.Cumulative), percent(fp.Cumulative),
+			fp.Calls, fp.SelfAllocations, fp.CumulativeAllocations, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,74 @@
+package vile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestProfileSpawnedTasks - profiling is started and stopped while spawned tasks run. Run it with -race.
+func TestProfileSpawnedTasks(t *testing.T) {
+	rt := newTestRuntime(t)
+	_, err := evalString(rt, `
+		(fn fib (n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))
+		(fn spawn-each (n total) (if (= n 0) total (spawn-each (- n 1) (+ total (await (spawn fib 10))))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		total, err := evalString(rt, `(spawn-each 500 0)`)
+		if err == nil && !Equal(total, Number(500*55)) {
+			t.Errorf("the tasks returned %v", total)
+		}
+		done <- err
+	}()
+	var prof *Profile
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+			rt.StartProfiling()
+			_, err := evalString(rt, `(fib 12)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prof = rt.StopProfiling()
+		}
+	}
+	if prof == nil || len(prof.Functions) == 0 {
+		t.Fatal("nothing was profiled")
+	}
+	if rt.StopProfiling() != nil {
+		t.Error("the profiler was not stopped")
+	}
+}
+
+// TestProfileDefinitionLine - a function is listed at the line of its definition, not that of its body
+func TestProfileDefinitionLine(t *testing.T) {
+	rt := newTestRuntime(t)
+	file := filepath.Join(t.TempDir(), "fib.vl")
+	src := "# fib\n(fn fib (n)\n  (if (< n 2)\n      n\n      (+ (fib (- n 1)) (fib (- n 2)))))\n(fib 15)\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rt.StartProfiling()
+	err := rt.LoadFile(file)
+	prof := rt.StopProfiling()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fp := range prof.Functions {
+		if fp.Name == "fib" {
+			if fp.File != file || fp.Line != 2 {
+				t.Errorf("fib is listed at %s:%d", fp.File, fp.Line)
+			}
+			return
+		}
+	}
+	t.Error("fib was not profiled")
+}
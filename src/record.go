@@ -0,0 +1,208 @@
+package vile
+
+import (
+	"strings"
+	"sync"
+)
+
+// Records are instances of a declared type whose value is a <struct> with the declared fields, in order:
+//
+//	(defrecord point (x (y <number>)))
+//
+// defines the type <point>, the constructor (make-point x y), the predicate (point? obj), and the accessors
+// (point-x p) and (point-y p). A field given as (name <type>) only accepts values of that type. A record is
+// written as #<point>{x: 1 y: 2}, and read back with the ;<point>{x: 1 y: 2} reader syntax. Keywords access
+// the fields too, as for any struct instance.
+
+type recordType struct {
+	fields []*Object // the field keywords
+	types  []*Object
+}
+
+// the declared records, shared by all runtimes like the type symbols they are keyed by
+var records = struct {
+	sync.RWMutex
+	types map[*Object]*recordType
+}{types: make(map[*Object]*recordType)}
+
+func lookupRecord(tag *Object) *recordType {
+	records.RLock()
+	defer records.RUnlock()
+	return records.types[tag]
+}
+
+// DefineRecord - declare the record type, with the fields (keywords) and the types of their values
+func DefineRecord(tag *Object, fields []*Object, types []*Object) error {
+	if !IsType(tag) || IsPrimitiveType(tag) {
+		return Error(ArgumentErrorKey, "Bad record type: ", tag)
+	}
+	if len(fields) != len(types) {
+		return Error(ArgumentErrorKey, "A record needs a type for each of its fields")
+	}
+	for i, f := range fields {
+		if !IsKeyword(f) || sliceContains(fields[:i], f) {
+			return Error(ArgumentErrorKey, "Bad record field: ", f)
+		}
+		if !IsType(types[i]) {
+			return Error(ArgumentErrorKey, "Bad type for record field ", f, ": ", types[i])
+		}
+	}
+	records.Lock()
+	records.types[tag] = &recordType{fields, types}
+	records.Unlock()
+	return nil
+}
+
+// Record - create an instance of the record type, with the values of its fields in order
+func Record(tag *Object, values []*Object) (*Object, error) {
+	rec := lookupRecord(tag)
+	if rec == nil {
+		return nil, Error(ArgumentErrorKey, "Not a record type: ", tag)
+	}
+	if len(values) != len(rec.fields) {
+		return nil, Error(ArgumentErrorKey, tag, " expected ", len(rec.fields), " field values, got ", len(values))
+	}
+	strct := MakeStruct(len(values))
+	for i, v := range values {
+		if t := rec.types[i]; t != AnyType && v.Type != t {
+			return nil, Error(ArgumentErrorKey, tag, " expected a ", t, " for ", rec.fields[i], " got a ", v.Type)
+		}
+		Put(strct, rec.fields[i], v)
+	}
+	return &Object{Type: tag, car: strct}, nil
+}
+
+// recordInstance - create an instance of the record type from a struct, as read from ;<type>{...}. The struct
+// must have exactly the fields of the record.
+func recordInstance(tag *Object, rec *recordType, val *Object) (*Object, error) {
+	if !IsStruct(val) || StructLength(val) != len(rec.fields) {
+		return nil, Error(ArgumentErrorKey, "Expected a <struct> with the fields ", rec.fields, " for a ", tag, ", got ", val)
+	}
+	values := make([]*Object, len(rec.fields))
+	for i, f := range rec.fields {
+		v, ok := val.bindings.get(newStructKey(f))
+		if !ok {
+			return nil, Error(ArgumentErrorKey, "Missing field ", f, " for a ", tag)
+		}
+		values[i] = v
+	}
+	return Record(tag, values)
+}
+
+// recordName - return the name of the record type, without the angle brackets
+func recordName(tag *Object) string {
+	return strings.TrimSuffix(strings.TrimPrefix(tag.text, "<"), ">")
+}
+
+// RecordConstructor - return a primitive function that creates records of the type from its arguments
+func RecordConstructor(tag *Object) (*Object, error) {
+	rec := lookupRecord(tag)
+	if rec == nil {
+		return nil, Error(ArgumentErrorKey, "Not a record type: ", tag)
+	}
+	fun := func(argv []*Object) (*Object, error) {
+		return Record(tag, argv)
+	}
+	return Primitive("make-"+recordName(tag), fun, tag, rec.types, nil, nil, nil), nil
+}
+
+// RecordPredicate - return a primitive function that tells if its argument is a record of the type
+func RecordPredicate(tag *Object) (*Object, error) {
+	if lookupRecord(tag) == nil {
+		return nil, Error(ArgumentErrorKey, "Not a record type: ", tag)
+	}
+	fun := func(argv []*Object) (*Object, error) {
+		if argv[0].Type == tag {
+			return True, nil
+		}
+		return False, nil
+	}
+	return Primitive(recordName(tag)+"?", fun, BooleanType, []*Object{AnyType}, nil, nil, nil), nil
+}
+
+// RecordAccessor - return a primitive function that gets the field of a record of the type
+func RecordAccessor(tag *Object, field *Object) (*Object, error) {
+	rec := lookupRecord(tag)
+	if rec == nil {
+		return nil, Error(ArgumentErrorKey, "Not a record type: ", tag)
+	}
+	if !sliceContains(rec.fields, field) {
+		return nil, Error(ArgumentErrorKey, tag, " has no field ", field)
+	}
+	key := newStructKey(field)
+	fun := func(argv []*Object) (*Object, error) {
+		if !IsStruct(argv[0].car) {
+			return nil, Error(ArgumentErrorKey, "Bad record: ", argv[0])
+		}
+		v, _ := argv[0].car.bindings.get(key)
+		return v, nil
+	}
+	name := recordName(tag) + "-" + strings.TrimSuffix(field.text, ":")
+	return Primitive(name, fun, rec.types[indexOf(rec.fields, field)], []*Object{tag}, nil, nil, nil), nil
+}
+
+func indexOf(slice []*Object, obj *Object) int {
+	for i, o := range slice {
+		if o == obj {
+			return i
+		}
+	}
+	return -1
+}
+
+// expandDefrecord - expand (defrecord name (field...)) into the declaration of the record type and the
+// definitions of its functions. A field is a symbol, or a list of a symbol and the type of its values.
+func expandDefrecord(expr *Object) (*Object, error) {
+	if ListLength(expr) != 3 {
+		return nil, Error(SyntaxErrorKey, expr)
+	}
+	name := Cadr(expr)
+	var tag *Object
+	switch {
+	case IsSymbol(name):
+		tag = Intern("<" + name.text + ">")
+	case IsType(name):
+		tag = name
+		name = Intern(recordName(tag))
+	default:
+		return nil, Error(SyntaxErrorKey, expr)
+	}
+	specs := Caddr(expr)
+	if IsVector(specs) {
+		specs, _ = ToList(specs)
+	}
+	if !IsList(specs) {
+		return nil, Error(SyntaxErrorKey, expr)
+	}
+	var fields, types, accessors []*Object
+	for ; specs != EmptyList; specs = Cdr(specs) {
+		spec := Car(specs)
+		sym, typ := spec, AnyType
+		if IsList(spec) {
+			if ListLength(spec) != 2 {
+				return nil, Error(SyntaxErrorKey, spec)
+			}
+			sym, typ = Car(spec), Cadr(spec)
+		}
+		if !IsSymbol(sym) || !IsType(typ) {
+			return nil, Error(SyntaxErrorKey, spec)
+		}
+		field := Intern(sym.text + ":")
+		fields = append(fields, field)
+		types = append(types, typ)
+		accessor := Intern(name.text + "-" + sym.text)
+		accessors = append(accessors, List(Intern("var"), accessor, List(Intern("record-accessor"), tag, field)))
+	}
+	quote := func(o *Object) *Object {
+		return List(Intern("quote"), o)
+	}
+	body := []*Object{
+		Intern("do"),
+		List(Intern("record-type"), tag, quote(ListFromValues(fields)), quote(ListFromValues(types))),
+		List(Intern("var"), Intern("make-"+name.text), List(Intern("record-constructor"), tag)),
+		List(Intern("var"), Intern(name.text+"?"), List(Intern("record-predicate"), tag)),
+	}
+	body = append(body, accessors...)
+	body = append(body, tag)
+	return ListFromValues(body), nil
+}
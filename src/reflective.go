@@ -1,6 +1,6 @@
 package vile
 
-func RunStringEval(vileCode string) error {
+func (rt *Runtime) RunStringEval(vileCode string) error {
 	vileCodeC := String(string(vileCode))
 
 	x, err := ReadAll(vileCodeC, nil)
@@ -10,7 +10,7 @@ func RunStringEval(vileCode string) error {
 
 	for x != EmptyList {
                 expr := Car(x)
-                _, err := Eval(expr)
+                _, err := rt.Eval(expr)
                 if err != nil {
                         return err
                 }
@@ -0,0 +1,177 @@
+package vile
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RegexType is the type of compiled regular expressions, whose syntax is that of Go's regexp package. They are
+// written as instances of the type, i.e. ;<regex>"a+b", and the reader compiles them through Instance.
+var RegexType = Intern("<regex>")
+
+type regex struct {
+	re *regexp.Regexp
+}
+
+func (r *regex) String() string {
+	return "#<regex>" + EncodeString(r.re.String())
+}
+
+// the number of compiled patterns kept. The cache is cleared when it is full, rather than evicting one by one.
+const regexCacheSize = 256
+
+// the compiled patterns, shared by all runtimes: a compiled regexp is safe for concurrent use
+var regexCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// compileRegex - return the compiled pattern, from the cache if it was compiled before
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	regexCache.Lock()
+	defer regexCache.Unlock()
+	if re, ok := regexCache.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, Error(SyntaxErrorKey, "Bad regex ", EncodeString(pattern), ": ", err.Error())
+	}
+	if len(regexCache.patterns) >= regexCacheSize {
+		regexCache.patterns = make(map[string]*regexp.Regexp)
+	}
+	regexCache.patterns[pattern] = re
+	return re, nil
+}
+
+// Regex - create a new <regex> object for the pattern
+func Regex(pattern string) (*Object, error) {
+	re, err := compileRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	obj := NewObject(RegexType, &regex{re})
+	obj.car = String(pattern) // so that it is an instance of <regex>, encoded as its pattern
+	return obj, nil
+}
+
+func IsRegex(obj *Object) bool {
+	return obj.Type == RegexType
+}
+
+// theRegex - return the compiled regexp of a <regex>, or of a <string> taken as a pattern
+func theRegex(obj *Object) (*regexp.Regexp, error) {
+	switch obj.Type {
+	case RegexType:
+		if r, ok := obj.Value.(*regex); ok {
+			return r.re, nil
+		}
+	case StringType:
+		return compileRegex(obj.text)
+	}
+	return nil, Error(ArgumentErrorKey, "Expected a <regex> or a <string>, got a ", obj.Type)
+}
+
+// regexMatch - return the match of the regexp in s, at loc as returned by FindStringSubmatchIndex. That is the
+// matched string if the regexp has no groups, a struct of the named groups if it names them, and otherwise a
+// vector of the match and its groups. Groups that did not take part in the match are null.
+func regexMatch(re *regexp.Regexp, s string, loc []int) *Object {
+	if re.NumSubexp() == 0 {
+		return String(s[loc[0]:loc[1]])
+	}
+	group := func(i int) *Object {
+		if loc[2*i] < 0 {
+			return Null
+		}
+		return String(s[loc[2*i]:loc[2*i+1]])
+	}
+	names := re.SubexpNames()
+	named := false
+	for _, name := range names {
+		named = named || name != ""
+	}
+	if named {
+		strct := MakeStruct(len(names))
+		for i, name := range names {
+			if name != "" {
+				Put(strct, Intern(name+":"), group(i))
+			}
+		}
+		return strct
+	}
+	groups := make([]*Object, len(names))
+	for i := range groups {
+		groups[i] = group(i)
+	}
+	return Vector(groups...)
+}
+
+// RegexMatch - return the first match of the pattern in the string, as described by regexMatch, or null
+func RegexMatch(pattern *Object, s *Object) (*Object, error) {
+	re, err := theRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	loc := re.FindStringSubmatchIndex(s.text)
+	if loc == nil {
+		return Null, nil
+	}
+	return regexMatch(re, s.text, loc), nil
+}
+
+// RegexFindAll - return a list of the successive matches of the pattern in the string
+func RegexFindAll(pattern *Object, s *Object) (*Object, error) {
+	re, err := theRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Object
+	for _, loc := range re.FindAllStringSubmatchIndex(s.text, -1) {
+		matches = append(matches, regexMatch(re, s.text, loc))
+	}
+	return ListFromValues(matches), nil
+}
+
+// RegexSplit - return a list of the parts of the string between the matches of the pattern
+func RegexSplit(pattern *Object, s *Object) (*Object, error) {
+	re, err := theRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var parts []*Object
+	for _, part := range re.Split(s.text, -1) {
+		parts = append(parts, String(part))
+	}
+	return ListFromValues(parts), nil
+}
+
+// RegexReplace - replace the matches of the pattern in the string. A <string> replacement can refer to groups
+// as $1 or ${name}. Otherwise the replacement is a function, called with each match as described by regexMatch,
+// whose result replaces it: a string as it is, anything else as it is displayed.
+func (rt *Runtime) RegexReplace(pattern *Object, s *Object, replacement *Object) (*Object, error) {
+	re, err := theRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if IsString(replacement) {
+		return String(re.ReplaceAllString(s.text, replacement.text)), nil
+	}
+	var buf strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s.text, -1) {
+		val, err := rt.Call(replacement, regexMatch(re, s.text, loc))
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(s.text[last:loc[0]])
+		if IsString(val) {
+			buf.WriteString(val.text)
+		} else {
+			buf.WriteString(val.String())
+		}
+		last = loc[1]
+	}
+	buf.WriteString(s.text[last:])
+	return String(buf.String()), nil
+}
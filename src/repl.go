@@ -15,14 +15,13 @@ import (
 )
 
 type vileHandler struct {
+	rt  *Runtime
 	buf string
 }
 
 func (vile *vileHandler) Eval(expr string) (string, bool, error) {
 	// return result, needMore, error
-	for checkInterrupt() {
-	} // to clear out any that happened while sitting in getc
-	interrupted = false
+	setInterrupted(false) // to clear out any that happened while sitting in getc
 	whole := strings.Trim(vile.buf+expr, " ")
 	opens := len(strings.Split(whole, "("))
 	closes := len(strings.Split(whole, ")"))
@@ -37,10 +36,14 @@ func (vile *vileHandler) Eval(expr string) (string, bool, error) {
 		if whole == "" {
 			return "", false, nil
 		}
+		if strings.HasPrefix(whole, ":expand ") {
+			vile.buf = ""
+			return vile.expand(whole[len(":expand "):])
+		}
 		lexpr, err := Read(String(whole), AnyType)
 		vile.buf = ""
 		if err == nil {
-			val, err := Eval(lexpr)
+			val, err := vile.rt.Eval(lexpr)
 			if err == nil {
 				result := ""
 				if val == nil {
@@ -51,12 +54,33 @@ func (vile *vileHandler) Eval(expr string) (string, bool, error) {
 				}
 				return result, false, nil
 			}
-			return "", false, err
+			return "", false, errors.New(ErrorReport(err))
 		}
 		return "", false, err
 	}
 }
 
+// expand - the :expand command, which shows each macro application in the expansion of the expression, and the
+// form it finally expands to
+func (vile *vileHandler) expand(expr string) (string, bool, error) {
+	lexpr, err := Read(String(expr), AnyType)
+	if err != nil {
+		return "", false, err
+	}
+	expanded, steps, err := vile.rt.MacroexpandTrace(lexpr)
+	if err != nil {
+		return "", false, errors.New(ErrorReport(err))
+	}
+	var buf strings.Builder
+	for i, step := range steps {
+		input, _ := Get(step, Intern("input:"))
+		output, _ := Get(step, Intern("output:"))
+		fmt.Fprintf(&buf, "; %d. %s\n;    => %s\n", i+1, Write(input), Write(output))
+	}
+	buf.WriteString("= " + Write(expanded))
+	return buf.String(), false, nil
+}
+
 func (vile *vileHandler) Reset() {
 	vile.buf = ""
 }
@@ -139,7 +163,7 @@ func (vile *vileHandler) Complete(expr string) (string, []string) {
 				candidates[sym] = true
 			}
 		}
-		for _, sym := range Macros() {
+		for _, sym := range vile.rt.Macros() {
 			_, ok := candidates[sym]
 			if !ok {
 				str := sym.String()
@@ -149,7 +173,7 @@ func (vile *vileHandler) Complete(expr string) (string, []string) {
 			}
 		}
 	}
-	for _, sym := range Globals() {
+	for _, sym := range vile.rt.Globals() {
 		_, ok := candidates[sym]
 		if !ok {
 			_, ok := candidates[sym]
@@ -157,7 +181,7 @@ func (vile *vileHandler) Complete(expr string) (string, []string) {
 				str := sym.String()
 				if strings.HasPrefix(str, prefix) {
 					if funPosition {
-						val := GetGlobal(sym)
+						val := vile.rt.GetGlobal(sym)
 						if IsFunction(val) {
 							candidates[sym] = true
 						}
@@ -186,7 +210,7 @@ func (vile *vileHandler) Prompt() string {
 		panic(err)
 	}
 
-	prompt := GetGlobal(Intern("*prompt*"))
+	prompt := vile.rt.GetGlobal(Intern("*prompt*"))
 	if prompt != nil {
 		return prompt.String()
 	}
@@ -224,11 +248,20 @@ func (vile *vileHandler) Stop(history []string) {
 	}
 }
 
-func ReadEvalPrintLoop() {
-	interrupts = make(chan os.Signal, 1)
+func (rt *Runtime) ReadEvalPrintLoop() {
+	interrupts := make(chan os.Signal, 1)
 	signal.Notify(interrupts, os.Interrupt)
-	defer signal.Stop(interrupts)
-	handler := vileHandler{""}
+	defer func() {
+		signal.Stop(interrupts)
+		close(interrupts)
+	}()
+	go func() {
+		// the running vms only check the flag, so that calls need not poll the channel
+		for range interrupts {
+			setInterrupted(true)
+		}
+	}()
+	handler := vileHandler{rt, ""}
 	err := repl.REPL(&handler)
 	if err != nil {
 		println("REPL error: ", err)
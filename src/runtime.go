@@ -3,7 +3,7 @@ package vile
 import (
 	"bytes"
 	"fmt"
-	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,25 +18,18 @@ import (
  * TailCall => Wikipedia
  */
 
-var trace bool
-var optimize bool
+var interrupted int32 // accessed atomically, all running vms check it
 
-var interrupted = false
-var interrupts chan os.Signal
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) != 0
+}
 
-func checkInterrupt() bool {
-	if interrupts != nil {
-		select {
-		case msg := <-interrupts:
-			if msg != nil {
-				interrupted = true
-				return true
-			}
-		default:
-			return false
-		}
+func setInterrupted(b bool) {
+	var i int32
+	if b {
+		i = 1
 	}
-	return false
+	atomic.StoreInt32(&interrupted, i)
 }
 
 func str(o interface{}) string {
@@ -70,9 +63,22 @@ func Fatal(args ...interface{}) {
 
 // Continuation -
 type continuation struct {
-	ops   []int
-	stack []*Object
-	pc    int
+	ops      []int
+	stack    []*Object
+	pc       int
+	handlers []handler // the handlers active when the continuation was captured, their sp counted from the stack end
+	vm       *vm // the vm it was captured in
+}
+
+// escape - the error that carries a continuation called in a vm other than the one it was captured in, such as
+// the vm of a primitive's callback, back to that vm. The calls in between are abandoned.
+type escape struct {
+	cont *Object
+	arg  *Object
+}
+
+func (e *escape) Error() string {
+	return "#[continuation] called after the call it was captured in returned"
 }
 
 func Closure(code *Code, frame *frame) *Object {
@@ -98,13 +104,154 @@ func Continuation(frame *frame, ops []int, pc int, stack []*Object) *Object {
 
 const defaultStackSize = 1000
 
+// the default for the largest size a vm's stack can grow to, and for the depth of nested calls
+const defaultMaxStackSize = 1 << 20
+
+// StackOverflowKey - for code that needs more stack than the runtime's limit allows
+var StackOverflowKey = Intern("stack-overflow:")
+
 // VM - the Vile VM
 type vm struct {
-	stackSize int
+	rt          *Runtime
+	stackSize   int
+	maxStack    int       // the size the stack may grow to, also the limit of the depth of nested calls
+	stack       []*Object // the operand stack. It is replaced by a larger one when it runs out of room
+	handlers    []handler
+	constants   []*Object
+	cells       []*binding // a snapshot of the cached global bindings, see globalCell
+	limiter     *limiter   // the limits of the runtime view the vm was created for, if any
+	allocations int64      // allocations since the limits were last checked
+	budget      int        // the instructions to execute before the limits are checked again
+	granted     int        // the budget given at the last check
+	captured    bool       // set once a continuation is captured in the vm, which then maintains running
+	running     int32      // set while the vm executes code, if captured, so its continuations can escape to it
+
+	// while profiling
+	profiler *profiler
+	clock    *time.Time        // when the last sample was taken, shared with the vms nested in this one
+	calling  profileCaller     // where the last call instruction was executed
+	profiled []profileLocation // the stack of the last sample
+}
+
+// constant - return the constant at idx. The vm keeps a snapshot of the constants, refreshed only when code
+// compiled after it was taken refers to a newer one.
+func (vm *vm) constant(idx int) *Object {
+	if idx >= len(vm.constants) {
+		vm.constants = vm.rt.constantsSnapshot()
+	}
+	return vm.constants[idx]
 }
 
+// globalCell - return the binding of the global whose symbol is the constant at idx
+func (vm *vm) globalCell(idx int) *binding {
+	if idx < len(vm.cells) {
+		if b := vm.cells[idx]; b != nil {
+			return b
+		}
+	}
+	b := vm.rt.globalCell(idx)
+	vm.cells = vm.rt.cellsSnapshot()
+	return b
+}
+
+// VM - create a vm for the default runtime
 func VM(stackSize int) *vm {
-	return &vm{stackSize}
+	return defaultRuntime.newVM(stackSize)
+}
+
+func (rt *Runtime) newVM(stackSize int) *vm {
+	vm := &vm{rt: rt, stackSize: stackSize, maxStack: rt.maxStackSize, limiter: rt.limiter, profiler: rt.profiler.Load()}
+	if vm.profiler != nil {
+		// a vm created by a primitive runs in turn with the vm that called the primitive, their samples are timed
+		// by the same clock
+		if rt.caller != nil {
+			vm.clock = rt.caller.clock
+		} else {
+			vm.clock = new(time.Time)
+		}
+	}
+	return vm
+}
+
+// ensureStack - make sure there are at least n free slots below sp. The stack grows downward, so a larger stack
+// gets the contents of the old one at its top, and the returned sp and vm.stack must be used from then on.
+func (vm *vm) ensureStack(sp int, n int) (int, error) {
+	if sp >= n {
+		return sp, nil
+	}
+	stack := vm.stack
+	size := len(stack) * 2
+	for size-len(stack)+sp < n {
+		size *= 2
+	}
+	if size > vm.maxStack {
+		size = vm.maxStack
+	}
+	delta := size - len(stack)
+	if sp+delta < n {
+		return sp, Error(StackOverflowKey, "the stack cannot grow beyond ", vm.maxStack, " slots")
+	}
+	newStack := make([]*Object, size)
+	copy(newStack[delta:], stack)
+	for i := range vm.handlers {
+		vm.handlers[i].sp += delta
+	}
+	vm.stack = newStack
+	return sp + delta, nil
+}
+
+// checkStackDepth - fail if calls are nested deeper than the stack limit
+func (vm *vm) checkStackDepth(f *frame) error {
+	if f.depth >= vm.maxStack {
+		return Error(StackOverflowKey, "calls nested deeper than ", vm.maxStack)
+	}
+	return nil
+}
+
+// handler - an active try block, as pushed by the pushhandler instruction
+type handler struct {
+	table *Object // the catch keys and handler offsets
+	ops   []int
+	pc    int
+	sp    int
+	env   *frame
+}
+
+// the key of the handler of a try with a finally block. It catches every error, runs the finally block and throws
+// the error again.
+var finallyKey = Intern("finally:")
+
+// finally - return the offset of the finally block of the handler, if it has one
+func (h *handler) finally() (int, bool) {
+	table := h.table.elements
+	if len(table) == 2 && table[0] == finallyKey {
+		return int(table[1].fval), true
+	}
+	return 0, false
+}
+
+// match - return the offset of the first handler in the table that catches errors with the key. Limit errors are
+// never caught.
+func (h *handler) match(key *Object) (int, bool) {
+	if key == LimitErrorKey {
+		return 0, false
+	}
+	table := h.table.elements
+	for i := 0; i < len(table); i += 2 {
+		k := table[i]
+		matched := k == Null || k == finallyKey || k == key
+		if IsVector(k) {
+			for _, k2 := range k.elements {
+				if k2 == key {
+					matched = true
+				}
+			}
+		}
+		if matched {
+			return int(table[i+1].fval), true
+		}
+	}
+	return 0, false
 }
 
 // Apply is a primitive instruction to apply a function to a list of arguments
@@ -113,7 +260,7 @@ var Apply = &Object{Type: FunctionType}
 // CallCC is a primitive instruction to executable (restore) a continuation
 var CallCC = &Object{Type: FunctionType}
 
-// Apply is a primitive instruction to apply a function to a list of arguments
+// Spawn is a primitive instruction to call a function in a new goroutine, returning a <task> to await
 var Spawn = &Object{Type: FunctionType}
 
 func functionSignature(f *Object) string {
@@ -133,7 +280,7 @@ func functionSignature(f *Object) string {
 		return "(<function>) <any>"
 	}
 	if f == Spawn {
-		return "(<function> <any>*) <null>"
+		return "(<function> <any>*) <task>"
 	}
 	panic("Bad function")
 }
@@ -171,14 +318,34 @@ type PrimitiveFunction func(argv []*Object) (*Object, error)
 type primitive struct { // <function>
 	name      string
 	fun       PrimitiveFunction
+	rtfun     runtimeFunction // used instead of fun, for primitives that need the runtime they are called in
 	signature string
-	idx       int
 	argc      int       // -1 means the primitive itself checks the args (legacy mode)
 	result    *Object   // if set the type of the result
 	args      []*Object // if set, the length must be for total args (both required and optional). The type (or <any>) for each
 	rest      *Object   // if set, then any number of this type can follow the normal args. Mutually incompatible with defaults/keys
 	defaults  []*Object // if set, then that many optional args beyond argc have these default values
 	keys      []*Object // if set, then it must match the size of defaults, and these are the keys
+	builds    bool      // if set, the result is new, and each of its elements is charged to the allocation limit
+}
+
+// runtimeFunction - a primitive function that is passed the runtime it is called in, for example to evaluate code
+type runtimeFunction func(rt *Runtime, argv []*Object) (*Object, error)
+
+func (prim *primitive) call(rt *Runtime, argv []*Object) (*Object, error) {
+	var val *Object
+	var err error
+	if prim.rtfun != nil {
+		val, err = prim.rtfun(rt, argv)
+	} else {
+		val, err = prim.fun(argv)
+	}
+	if err == nil && prim.builds && rt.limiter != nil {
+		if err := rt.allocate(elementCount(val)); err != nil {
+			return nil, err
+		}
+	}
+	return val, err
 }
 
 func functionSignatureFromTypes(result *Object, args []*Object, rest *Object) string {
@@ -213,7 +380,6 @@ func functionSignatureFromTypes(result *Object, args []*Object, rest *Object) st
 func Primitive(name string, fun PrimitiveFunction, result *Object, args []*Object, rest *Object, defaults []*Object, keys []*Object) *Object {
 	// the rest type indicates arguments past the end of args will all have the given type. the length must be checked by primitive
 	// -> they are all optional, then. So, (<any>+) must be expressed as (<any> <any>*)
-	idx := len(primitives)
 	argc := len(args)
 	if defaults != nil {
 		defc := len(defaults)
@@ -238,8 +404,7 @@ func Primitive(name string, fun PrimitiveFunction, result *Object, args []*Objec
 		}
 	}
 	signature := functionSignatureFromTypes(result, args, rest) // functionSignatureFromTypes was defined in runtime.go - 184 line
-	prim := &primitive{name, fun, signature, idx, argc, result, args, rest, defaults, keys}
-	primitives = append(primitives, prim)
+	prim := &primitive{name, fun, nil, signature, argc, result, args, rest, defaults, keys, buildingPrimitives[name]}
 	return &Object{Type: FunctionType, primitive: prim}
 }
 
@@ -256,6 +421,7 @@ type frame struct { /* If you want to know more about this structure read about
 	elements  []*Object
 	firstfive [5]*Object
 	pc        int
+	depth     int // the number of frames below this one
 }
 
 func (frame *frame) String() string {
@@ -278,6 +444,9 @@ func (frame *frame) String() string {
 func buildFrame(env *frame, pc int, ops []int, fun *Object, argc int, stack []*Object, sp int) (*frame, error) {
 	f := new(frame)
 	f.previous = env
+	if env != nil {
+		f.depth = env.depth + 1
+	}
 	f.pc = pc
 	f.ops = ops
 	f.locals = fun.frame
@@ -353,31 +522,39 @@ func buildFrame(env *frame, pc int, ops []int, fun *Object, argc int, stack []*O
 	return f, nil
 }
 
-func addContext(env *frame, err error) error {
-	if e, ok := err.(*Object); ok {
+// addContext - record where the error occurred, as a struct in a copy of the error: the function, the location
+// in the source of the instruction at pc (unless pc is negative), and the calls that were pending. Once recorded,
+// the context is kept as the error propagates. The throw function drops it, so an error thrown again gets the
+// context of where it was thrown.
+func addContext(env *frame, pc int, err error) error {
+	if e, ok := err.(*Object); ok && e.Type == ErrorType && e.Value == nil && env != nil {
+		if env.code != nil && env.code.name == "throw" && env.previous != nil {
+			env, pc = env.previous, env.pc-1 // report where throw was called
+		}
+		name := ""
+		var loc *location
 		if env.code != nil {
-			if env.code.name != "throw" {
-				e.text = env.code.name
-			} else if env.previous != nil {
-				if env.previous.code != nil {
-					e.text = env.previous.code.name
-				}
+			name = env.code.name
+			if pc >= 0 {
+				loc = env.code.locationOf(pc)
 			}
 		}
+		return withContext(e, errorContext(name, loc, stackTrace(env)))
 	}
 	return err
 }
 
+// keywordCall - call the keyword at pc, returning the pc of the next instruction, or of the call if it failed
 func (vm *vm) keywordCall(fun *Object, argc int, pc int, stack []*Object, sp int) (int, int, error) {
 	if argc != 1 {
-		return 0, 0, Error(ArgumentErrorKey, fun.text, " expected 1 argument, got ", argc)
+		return pc, sp, Error(ArgumentErrorKey, fun.text, " expected 1 argument, got ", argc)
 	}
 	v, err := Get(stack[sp], fun)
 	if err != nil {
-		return 0, 0, err
+		return pc, sp, err
 	}
 	stack[sp] = v
-	return pc, sp, nil
+	return pc + 2, sp, nil
 }
 
 func argcError(name string, min int, max int, provided int) error {
@@ -394,8 +571,22 @@ func argcError(name string, min int, max int, provided int) error {
 	return Error(ArgumentErrorKey, fmt.Sprintf("%s expected %s, got %d", name, s, provided))
 }
 
+// runtime - return the runtime given to the primitives the vm calls. While profiling, it is a view of the runtime
+// that tells the vms created by the primitive where the primitive was called, so that the functions they run are
+// sampled with the calls pending in the vm.
+func (vm *vm) runtime() *Runtime {
+	if vm.profiler == nil || vm.calling.env == nil {
+		return vm.rt
+	}
+	caller := vm.calling
+	caller.clock = vm.clock
+	caller.outer = vm.rt.caller
+	return &Runtime{environment: vm.rt.environment, limiter: vm.rt.limiter, caller: &caller}
+}
+
 func (vm *vm) callPrimitive(prim *primitive, argv []*Object) (*Object, error) {
 	// println(prim.defaults)
+	vm.allocations++
 	if prim.defaults != nil {
 		return vm.callPrimitiveWithDefaults(prim, argv)
 	}
@@ -409,7 +600,7 @@ func (vm *vm) callPrimitive(prim *primitive, argv []*Object) (*Object, error) {
 			return nil, Error(ArgumentErrorKey, fmt.Sprintf("%s expected a %s for argument %d, got a %s", prim.name, prim.args[i].text, i+1, argv[i].Type.text))
 		}
 	}
-	return prim.fun(argv)
+	return prim.call(vm.runtime(), argv)
 }
 
 func (vm *vm) callPrimitiveWithDefaults(prim *primitive, argv []*Object) (*Object, error) {
@@ -435,7 +626,7 @@ func (vm *vm) callPrimitiveWithDefaults(prim *primitive, argv []*Object) (*Objec
 				}
 			}
 		}
-		return prim.fun(argv)
+		return prim.call(vm.runtime(), argv)
 	}
 	maxargc := len(prim.args)
 	if provided < minargc {
@@ -479,7 +670,7 @@ func (vm *vm) callPrimitiveWithDefaults(prim *primitive, argv []*Object) (*Objec
 			return nil, argcError(prim.name, minargc, maxargc, provided)
 		}
 		copy(newargs, argv)
-		j := 0
+		j := provided - minargc // the defaults of the args not provided
 		for i := provided; i < maxargc; i++ {
 			newargs[i] = prim.defaults[j]
 			j++
@@ -492,26 +683,32 @@ func (vm *vm) callPrimitiveWithDefaults(prim *primitive, argv []*Object) (*Objec
 			return nil, Error(ArgumentErrorKey, fmt.Sprintf("%s expected a %s for argument %d, got a %s", prim.name, prim.args[i].text, i+1, argv[i].Type.text))
 		}
 	}
-	return prim.fun(argv)
+	return prim.call(vm.runtime(), argv)
 }
 
 func (vm *vm) funcall(fun *Object, argc int, ops []int, savedPc int, stack []*Object, sp int, env *frame) ([]int, int, int, *frame, error) {
 opcodeCallAgain: // If you don't know about this line or code read about Label in Go
 	if fun.Type == FunctionType {
 		if fun.code != nil {
-			if interrupted || checkInterrupt() {
-				return nil, 0, 0, nil, addContext(env, Error(InterruptKey)) // not catchable
+			if vm.profiler != nil {
+				vm.profiler.called(fun.code)
+			}
+			if isInterrupted() {
+				return nil, 0, 0, nil, addContext(env, savedPc-1, Error(InterruptKey)) // not catchable
 			}
 			if fun.code.defaults == nil { // IMPORTANT - read about subroutine in Wikipedia
 				f := new(frame)
 				f.previous = env
+				if env != nil {
+					f.depth = env.depth + 1
+				}
 				f.pc = savedPc // savedPc = `saved program counter`
 				f.ops = ops
 				f.locals = fun.frame
 				f.code = fun.code
 				expectedArgc := fun.code.argc
 				if argc != expectedArgc {
-					return nil, 0, 0, nil, Error(ArgumentErrorKey, "Wrong number of args to ", fun, " (expected ", expectedArgc, ", got ", argc, ")")
+					return vm.catch(Error(ArgumentErrorKey, "Wrong number of args to ", fun, " (expected ", expectedArgc, ", got ", argc, ")"), stack, env, savedPc-1)
 				}
 				if argc <= 5 {
 					f.elements = f.firstfive[:argc]
@@ -520,13 +717,34 @@ opcodeCallAgain: // If you don't know about this line or code read about Label i
 				}
 				endSp := sp + argc
 				copy(f.elements, stack[sp:endSp])
+				vm.allocations++
+				if err := vm.checkDepth(f, env, savedPc-1); err != nil {
+					return nil, 0, 0, nil, err
+				}
+				if err := vm.checkStackDepth(f); err != nil {
+					return vm.catch(err, stack, env, savedPc-1)
+				}
+				endSp, err := vm.ensureStack(endSp, len(fun.code.ops))
+				if err != nil {
+					return vm.catch(err, vm.stack, env, savedPc-1)
+				}
 				return fun.code.ops, 0, endSp, f, nil
 			}
 			f, err := buildFrame(env, savedPc, ops, fun, argc, stack, sp)
 			if err != nil {
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
+			}
+			vm.allocations++
+			if err := vm.checkDepth(f, env, savedPc-1); err != nil {
+				return nil, 0, 0, nil, err
+			}
+			if err := vm.checkStackDepth(f); err != nil {
+				return vm.catch(err, stack, env, savedPc-1)
+			}
+			sp, err = vm.ensureStack(sp+argc, len(fun.code.ops))
+			if err != nil {
+				return vm.catch(err, vm.stack, env, savedPc-1)
 			}
-			sp += argc
 			env = f
 			ops = fun.code.ops
 			return ops, 0, sp, env, err
@@ -534,7 +752,7 @@ opcodeCallAgain: // If you don't know about this line or code read about Label i
 		if fun.primitive != nil {
 			val, err := vm.callPrimitive(fun.primitive, stack[sp:sp+argc])
 			if err != nil {
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
 			}
 			sp = sp + argc - 1
 			stack[sp] = val
@@ -543,13 +761,13 @@ opcodeCallAgain: // If you don't know about this line or code read about Label i
 		if fun == Apply {
 			if argc < 2 {
 				err := Error(ArgumentErrorKey, "apply expected at least 2 arguments, got ", argc)
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
 			}
 			fun = stack[sp]
 			args := stack[sp+argc-1]
 			if !IsList(args) {
 				err := Error(ArgumentErrorKey, "apply expected a <list> as its final argument")
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
 			}
 			arglist := args
 			for i := argc - 2; i > 0; i-- {
@@ -557,6 +775,11 @@ opcodeCallAgain: // If you don't know about this line or code read about Label i
 			}
 			sp += argc
 			argc = ListLength(arglist)
+			newSp, err := vm.ensureStack(sp, argc)
+			sp, stack = newSp, vm.stack
+			if err != nil {
+				return vm.catch(err, stack, env, savedPc-1)
+			}
 			i := 0
 			sp -= argc
 			for arglist != EmptyList {
@@ -569,32 +792,37 @@ opcodeCallAgain: // If you don't know about this line or code read about Label i
 		if fun == CallCC {
 			if argc != 1 {
 				err := Error(ArgumentErrorKey, "callcc expected 1 argument, got ", argc)
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
 			}
 			fun = stack[sp]
 			stack[sp] = Continuation(env, ops, savedPc, stack[sp+1:])
+			stack[sp].continuation.handlers = vm.saveHandlers()
+			stack[sp].continuation.vm = vm
+			vm.captured = true
+			atomic.StoreInt32(&vm.running, 1)
 			goto opcodeCallAgain
 		}
 		if fun.continuation != nil {
 			if argc != 1 {
 				err := Error(ArgumentErrorKey, "#[continuation] expected 1 argument, got ", argc)
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
 			}
-			arg := stack[sp]
-			sp = len(stack) - len(fun.continuation.stack)
-			segment := stack[sp:]
-			copy(segment, fun.continuation.stack)
-			sp--
-			stack[sp] = arg
-			return fun.continuation.ops, fun.continuation.pc, sp, fun.frame, nil
+			if cont := fun.continuation; cont.vm != vm && cont.vm != nil && atomic.LoadInt32(&cont.vm.running) != 0 {
+				return vm.catch(&escape{fun, stack[sp]}, stack, env, savedPc-1)
+			}
+			contOps, contPc, contSp, contEnv, err := vm.resume(fun, stack[sp])
+			if err != nil {
+				return vm.catch(err, stack, env, savedPc-1)
+			}
+			return contOps, contPc, contSp, contEnv, nil
 		}
 		if fun == Spawn {
-			err := vm.spawn(stack[sp], argc-1, stack, sp+1)
+			t, err := vm.spawn(stack[sp], argc-1, stack, sp+1)
 			if err != nil {
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, savedPc-1)
 			}
 			sp = sp + argc - 1
-			stack[sp] = Null
+			stack[sp] = t
 			return ops, savedPc, sp, env, err
 		}
 		panic("unsupported instruction")
@@ -602,27 +830,30 @@ opcodeCallAgain: // If you don't know about this line or code read about Label i
 	if fun.Type == KeywordType {
 		if argc != 1 {
 			err := Error(ArgumentErrorKey, fun.text, " expected 1 argument, got ", argc)
-			return vm.catch(err, stack, env)
+			return vm.catch(err, stack, env, savedPc-1)
 		}
 		v, err := Get(stack[sp], fun)
 		if err != nil {
-			return vm.catch(err, stack, env)
+			return vm.catch(err, stack, env, savedPc-1)
 		}
 		stack[sp] = v
 		return ops, savedPc, sp, env, err
 	}
 	err := Error(ArgumentErrorKey, "Not a function: ", fun)
-	return vm.catch(err, stack, env)
+	return vm.catch(err, stack, env, savedPc-1)
 }
 
-func (vm *vm) tailcall(fun *Object, argc int, ops []int, stack []*Object, sp int, env *frame) ([]int, int, int, *frame, error) {
+func (vm *vm) tailcall(fun *Object, argc int, ops []int, pc int, stack []*Object, sp int, env *frame) ([]int, int, int, *frame, error) {
 opcodeTailCallAgain: // opcodeTailCallAgain label
 	if fun.Type == FunctionType {
 		if fun.code != nil {
+			if vm.profiler != nil {
+				vm.profiler.called(fun.code)
+			}
 			if fun.code.defaults == nil && fun.code == env.code { // self-tail-call - we can reuse the frame.
 				expectedArgc := fun.code.argc
 				if argc != expectedArgc {
-					return nil, 0, 0, nil, Error(ArgumentErrorKey, "Wrong number of args to ", fun, " (expected ", expectedArgc, ", got ", argc, ")")
+					return vm.catch(Error(ArgumentErrorKey, "Wrong number of args to ", fun, " (expected ", expectedArgc, ", got ", argc, ")"), stack, env, pc)
 				}
 				endSp := sp + argc
 				copy(env.elements, stack[sp:endSp])
@@ -630,15 +861,19 @@ opcodeTailCallAgain: // opcodeTailCallAgain label
 			}
 			f, err := buildFrame(env.previous, env.pc, env.ops, fun, argc, stack, sp) // make a frame
 			if err != nil {
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
+			}
+			vm.allocations++
+			sp, err = vm.ensureStack(sp+argc, len(fun.code.ops))
+			if err != nil {
+				return vm.catch(err, vm.stack, env, pc)
 			}
-			sp += argc
 			return fun.code.ops, 0, sp, f, nil
 		}
 		if fun.primitive != nil {
 			val, err := vm.callPrimitive(fun.primitive, stack[sp:sp+argc])
 			if err != nil {
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
 			}
 			sp = sp + argc - 1
 			stack[sp] = val
@@ -647,13 +882,13 @@ opcodeTailCallAgain: // opcodeTailCallAgain label
 		if fun == Apply {
 			if argc < 2 {
 				err := Error(ArgumentErrorKey, "apply expected at least 2 arguments, got ", argc)
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
 			}
 			fun = stack[sp]
 			args := stack[sp+argc-1]
 			if !IsList(args) {
 				err := Error(ArgumentErrorKey, "apply expected its last argument to be a <list>")
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
 			}
 			arglist := args
 			for i := argc - 2; i > 0; i-- {
@@ -661,6 +896,11 @@ opcodeTailCallAgain: // opcodeTailCallAgain label
 			}
 			sp += argc
 			argc = ListLength(arglist)
+			newSp, err := vm.ensureStack(sp, argc)
+			sp, stack = newSp, vm.stack
+			if err != nil {
+				return vm.catch(err, stack, env, pc)
+			}
 			i := 0
 			sp -= argc
 			for arglist != EmptyList {
@@ -673,32 +913,37 @@ opcodeTailCallAgain: // opcodeTailCallAgain label
 		if fun.continuation != nil {
 			if argc != 1 {
 				err := Error(ArgumentErrorKey, "#[continuation] expected 1 argument, got ", argc)
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
 			}
-			arg := stack[sp]
-			sp = len(stack) - len(fun.continuation.stack)
-			segment := stack[sp:]
-			copy(segment, fun.continuation.stack)
-			sp--
-			stack[sp] = arg
-			return fun.continuation.ops, fun.continuation.pc, sp, fun.frame, nil
+			if cont := fun.continuation; cont.vm != vm && cont.vm != nil && atomic.LoadInt32(&cont.vm.running) != 0 {
+				return vm.catch(&escape{fun, stack[sp]}, stack, env, pc)
+			}
+			contOps, contPc, contSp, contEnv, err := vm.resume(fun, stack[sp])
+			if err != nil {
+				return vm.catch(err, stack, env, pc)
+			}
+			return contOps, contPc, contSp, contEnv, nil
 		}
 		if fun == CallCC {
 			if argc != 1 {
 				err := Error(ArgumentErrorKey, "callcc expected 1 argument, got ", argc)
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
 			}
 			fun = stack[sp]
 			stack[sp] = Continuation(env.previous, env.ops, env.pc, stack[sp:])
+			stack[sp].continuation.handlers = vm.saveHandlers()
+			stack[sp].continuation.vm = vm
+			vm.captured = true
+			atomic.StoreInt32(&vm.running, 1)
 			goto opcodeTailCallAgain
 		}
 		if fun == Spawn {
-			err := vm.spawn(stack[sp], argc-1, stack, sp+1)
+			t, err := vm.spawn(stack[sp], argc-1, stack, sp+1)
 			if err != nil {
-				return vm.catch(err, stack, env)
+				return vm.catch(err, stack, env, pc)
 			}
 			sp = sp + argc - 1
-			stack[sp] = Null
+			stack[sp] = t
 			return env.ops, env.pc, sp, env.previous, nil
 		}
 		panic("Bad function")
@@ -706,85 +951,235 @@ opcodeTailCallAgain: // opcodeTailCallAgain label
 	if fun.Type == KeywordType {
 		if argc != 1 {
 			err := Error(ArgumentErrorKey, fun.text, " expected 1 argument, got ", argc)
-			return vm.catch(err, stack, env)
+			return vm.catch(err, stack, env, pc)
 		}
 		v, err := Get(stack[sp], fun)
 		if err != nil {
-			return vm.catch(err, stack, env)
+			return vm.catch(err, stack, env, pc)
 		}
 		stack[sp] = v
 		return env.ops, env.pc, sp, env.previous, nil
 	}
 	err := Error(ArgumentErrorKey, "Not a function:", fun)
-	return vm.catch(err, stack, env)
+	return vm.catch(err, stack, env, pc)
 }
 
-func (vm *vm) keywordTailcall(fun *Object, argc int, ops []int, stack []*Object, sp int, env *frame) ([]int, int, int, *frame, error) {
+func (vm *vm) keywordTailcall(fun *Object, argc int, ops []int, pc int, stack []*Object, sp int, env *frame) ([]int, int, int, *frame, error) {
 	if argc != 1 {
 		err := Error(ArgumentErrorKey, fun.text, " expected 1 argument, got ", argc)
-		return vm.catch(err, stack, env)
+		return vm.catch(err, stack, env, pc)
 	}
 	v, err := Get(stack[sp], fun)
 	if err != nil {
-		return vm.catch(err, stack, env)
+		return vm.catch(err, stack, env, pc)
 	}
 	stack[sp] = v
 	return env.ops, env.pc, sp, env.previous, nil
 }
 
-func execCompileTime(code *Code, arg *Object) (*Object, error) {
+func (rt *Runtime) execCompileTime(code *Code, arg *Object) (*Object, error) {
 	args := []*Object{arg}
-	prev := verbose
-	verbose = false
-	res, err := exec(code, args)
-	verbose = prev
-	return res, err
+	return rt.execute(code, args, false)
+}
+
+// unwind - abandon the handlers of the vm above the depth, for the escape, until one with a finally block is found.
+// The finally block is then run, with the escape as the error it throws again once it is done, so that the
+// unwinding goes on from there. The result is false if no finally block is left to run.
+func (vm *vm) unwind(e *escape, depth int) ([]int, int, int, *frame, bool) {
+	for len(vm.handlers) > depth {
+		h := vm.handlers[len(vm.handlers)-1]
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		if offset, ok := h.finally(); ok {
+			sp := h.sp - 1
+			vm.stack[sp] = &Object{Type: ErrorType, car: Vector(ErrorKey, String(e.Error())), Value: e}
+			return h.ops, h.pc + offset, sp, h.env, true
+		}
+	}
+	return nil, 0, 0, nil, false
+}
+
+// saveHandlers - return a copy of the active handlers for a continuation. Their sp is made relative to the end of
+// the stack, where the stack of the continuation is put back when it is resumed.
+func (vm *vm) saveHandlers() []handler {
+	saved := make([]handler, len(vm.handlers))
+	copy(saved, vm.handlers)
+	for i := range saved {
+		saved[i].sp -= len(vm.stack)
+	}
+	return saved
+}
+
+// sharedHandlers - return the number of active handlers that are also handlers of the continuation: those of the
+// try forms that it does not escape from
+func (vm *vm) sharedHandlers(cont *continuation) int {
+	n := 0
+	for n < len(vm.handlers) && n < len(cont.handlers) {
+		h, saved := vm.handlers[n], cont.handlers[n]
+		if h.table != saved.table || h.pc != saved.pc || h.env != saved.env || h.sp-len(vm.stack) != saved.sp {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// resume - continue the computation of the continuation, with arg as the result of the callcc that captured it. The
+// finally blocks of the try forms it escapes from are run first, then the handlers of the try forms it was
+// captured in are active again, including those that had been exited since.
+func (vm *vm) resume(cont *Object, arg *Object) ([]int, int, int, *frame, error) {
+	if ops, pc, sp, env, ok := vm.unwind(&escape{cont, arg}, vm.sharedHandlers(cont.continuation)); ok {
+		return ops, pc, sp, env, nil
+	}
+	if _, err := vm.ensureStack(len(vm.stack), len(cont.continuation.stack)+1); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	vm.handlers = vm.handlers[:0]
+	for _, h := range cont.continuation.handlers {
+		h.sp += len(vm.stack)
+		vm.handlers = append(vm.handlers, h)
+	}
+	stack := vm.stack
+	sp := len(stack) - len(cont.continuation.stack)
+	copy(stack[sp:], cont.continuation.stack)
+	sp--
+	stack[sp] = arg
+	return cont.continuation.ops, cont.continuation.pc, sp, cont.frame, nil
 }
 
-func (vm *vm) catch(err error, stack []*Object, env *frame) ([]int, int, int, *frame, error) {
+func (vm *vm) catch(err error, stack []*Object, env *frame, pc int) ([]int, int, int, *frame, error) {
+	if e, ok := err.(*escape); ok {
+		if e.cont.continuation.vm == vm {
+			// the stack never needs to grow for this: the continuation was captured from it
+			return vm.resume(e.cont, e.arg)
+		}
+		if ops, pc, sp, env, ok := vm.unwind(e, 0); ok {
+			return ops, pc, sp, env, nil
+		}
+		return nil, 0, 0, nil, err
+	}
 	errobj, ok := err.(*Object)
 	if !ok {
 		errobj = MakeError(ErrorKey, String(err.Error()))
 	}
-	handler := GetGlobal(Intern("*top-handler*"))
-	if handler != nil && handler.Type == FunctionType {
+	for len(vm.handlers) > 0 {
+		h := vm.handlers[len(vm.handlers)-1]
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		if offset, ok := h.match(errorKey(errobj)); ok {
+			errobj = addContext(env, pc, errobj).(*Object)
+			sp := h.sp - 1
+			stack[sp] = errobj
+			return h.ops, h.pc + offset, sp, h.env, nil
+		}
+	}
+	handler := vm.rt.GetGlobal(Intern("*top-handler*"))
+	if handler != nil && handler.Type == FunctionType && errorKey(errobj) != LimitErrorKey {
 		if handler.code != nil {
-			if handler.code.argc == 1 {
+			if handler.code.argc == 1 && len(handler.code.ops) < len(stack) {
 				sp := len(stack) - 1
 				stack[sp] = errobj
 				return vm.funcall(handler, 1, nil, 0, stack, sp, nil)
 			}
 		}
 	}
-	return nil, 0, 0, nil, addContext(env, err)
+	return nil, 0, 0, nil, addContext(env, pc, err)
 }
 
-func (vm *vm) spawn(fun *Object, argc int, stack []*Object, sp int) error {
+func (vm *vm) spawn(fun *Object, argc int, stack []*Object, sp int) (*Object, error) {
 	if fun.Type == FunctionType {
 		if fun.code != nil {
 			env, err := buildFrame(nil, 0, nil, fun, argc, stack, sp)
 			if err != nil {
-				return err
-			}
-			go func(code *Code, env *frame) {
-				vm := VM(defaultStackSize)
-				_, err := vm.exec(code, env)
-				if err != nil {
-//					println("; [*** error in spawned function '", code.name, "': ", err, "]")
-				} else if verbose {
-//					println("; [spawned function '", code.name, "' exited cleanly]")
+				return nil, err
+			}
+			handle := newTask()
+			go func(code *Code, env *frame, t *task) {
+				var result *Object
+				var err error
+				defer func() {
+					if r := recover(); r != nil {
+						err = Error(ErrorKey, "spawned function '", code.name, "' crashed: ", fmt.Sprint(r))
+					}
+					if err != nil && vm.rt.verbose {
+						println("; [*** error in spawned function '", code.name, "': ", err.Error(), "]")
+					}
+					t.finish(result, err)
+				}()
+				spawned := vm.rt.newVM(defaultStackSize)
+				if spawned.profiler != nil {
+					spawned.clock = new(time.Time) // it runs beside the vm
 				}
-			}(fun.code, env)
-			return nil
+				result, err = spawned.exec(code, env)
+			}(fun.code, env, handle.Value.(*task))
+			return handle, nil
 		}
 		// spawning callcc, apply, and spawn instructions not supported.
 		//? spawning primitives not supported. Is that important?
 	}
-	return Error(ArgumentErrorKey, "Bad function for spawn: ", fun)
+	return nil, Error(ArgumentErrorKey, "Bad function for spawn: ", fun)
+}
+
+// Call - call the function with the arguments and return its result. The function can be a closure, a
+// primitive, a keyword (which gets its value in its argument), a continuation, or one of apply, callcc and spawn.
+// It is safe to use from a primitive, while the vm that called the primitive is running: the call is made in a
+// vm of its own. Errors thrown and not caught by the function are returned.
+func (rt *Runtime) Call(fun *Object, args ...*Object) (*Object, error) {
+	return rt.newVM(defaultStackSize).call(fun, args)
+}
+
+// call - call the function with the arguments from Go. This is how primitives call back into vile code: the vm
+// runs the call to completion, and can then make another one, reusing its stack. Calls made while it runs (from
+// primitives called by the function) use vms of their own, so continuations captured in a call cannot escape it.
+// Keywords are called as accessors of their argument.
+func (vm *vm) call(fun *Object, args []*Object) (*Object, error) {
+	switch {
+	case fun.primitive != nil:
+		return vm.callPrimitive(fun.primitive, args)
+	case fun.Type == FunctionType && fun.code != nil:
+		if vm.profiler != nil {
+			vm.profiler.called(fun.code)
+		}
+		env, err := buildFrame(nil, 0, nil, fun, len(args), args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return vm.exec(fun.code, env)
+	case fun.Type == FunctionType:
+		// continuations and the apply, callcc and spawn instructions only work from code, so run a stub that
+		// calls the function with the arguments, all passed to the stub as its locals
+		code := trampoline(vm.rt, len(args))
+		env := &frame{code: code}
+		env.elements = make([]*Object, len(args)+1)
+		env.elements[0] = fun
+		copy(env.elements[1:], args)
+		return vm.exec(code, env)
+	case fun.Type == KeywordType:
+		if len(args) != 1 {
+			return nil, argcError(fun.text, 1, 1, len(args))
+		}
+		return Get(args[0], fun)
+	}
+	return nil, Error(ArgumentErrorKey, "Not a function: ", fun)
 }
 
-func exec(code *Code, args []*Object) (*Object, error) {
-	vm := VM(defaultStackSize) // virtual machine
+// trampoline - return the code of a function of argc+1 arguments that calls its first argument with the others
+func trampoline(rt *Runtime, argc int) *Code {
+	code := rt.makeCode(argc+1, nil, nil, "").code
+	for i := argc; i > 0; i-- {
+		code.emitLocal(0, i)
+	}
+	code.emitLocal(0, 0)
+	code.emitCall(argc)
+	code.emitReturn()
+	return code
+}
+
+func (rt *Runtime) exec(code *Code, args []*Object) (*Object, error) {
+	return rt.execute(code, args, rt.verbose)
+}
+
+// execute - run the code in a new vm, reporting its execution time and result if report is true
+func (rt *Runtime) execute(code *Code, args []*Object, report bool) (*Object, error) {
+	vm := rt.newVM(defaultStackSize) // virtual machine
 	if len(args) != code.argc {
 		return nil, Error(ArgumentErrorKey, "Wrong number of arguments")
 	}
@@ -801,27 +1196,65 @@ func exec(code *Code, args []*Object) (*Object, error) {
 	if result == nil {
 		panic("result should never be nil if no error")
 	}
-	if verbose { // verbose mode in Vile dialect
+	if report { // verbose mode in Vile dialect
 		println("; executed in ", dur)
-		if !interactive {
+		if !rt.interactive {
 			println("; => ", result)
 		}
 	}
 	return result, err
 }
 
+// exec - execute the code in the frame. The instructions are counted, and the limits checked, only when the vm
+// has limits or is profiling: otherwise its budget of instructions never runs out.
 func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
-	if !optimize || verbose || trace { // check optimize and verbose and trace booleans
-		return vm.instrumentedExec(code, env)
+	if vm.captured {
+		atomic.StoreInt32(&vm.running, 1)
+	}
+	var result *Object
+	var err error
+	vm.granted, vm.budget = 0, 0
+	if err = vm.checkLimits(env, 0); err == nil {
+		if !vm.rt.optimize || vm.rt.verbose || vm.rt.trace || vm.profiler != nil { // check optimize and verbose and trace booleans
+			result, err = vm.instrumentedExec(code, env)
+		} else {
+			result, err = vm.fastExec(code, env)
+		}
+		vm.countInstructions(env)
+	}
+	if vm.captured {
+		atomic.StoreInt32(&vm.running, 0)
+	}
+	return result, err
+}
+
+// fastExec - execute the code, with none of the checks and tracing of instrumentedExec
+func (vm *vm) fastExec(code *Code, env *frame) (*Object, error) {
+	if vm.stack == nil { // else the stack of a previous call, see call
+		vm.stack = make([]*Object, vm.stackSize)
+	}
+	sp, err := vm.ensureStack(len(vm.stack), len(code.ops)) // the sp is the `stack pointer`
+	if err != nil {
+		return nil, err
 	}
-	stack := make([]*Object, vm.stackSize) // stack
-	sp := vm.stackSize // the sp is the `stack pointer`
+	stack := vm.stack // the stack, reloaded from vm.stack whenever a call may have grown it
 	ops := code.ops // `operation codes`
 	pc := 0 // `program `counter`
-	var err error
 	for { // until exec all ops
+		if vm.budget == 0 {
+			if err = vm.checkLimits(env, pc); err != nil {
+				return nil, err
+			}
+		}
+		vm.budget--
 		op := ops[pc]
-		if op == opcodeCall { // CALL
+		switch op {
+		case opcodeGlobalCall:
+			sp--
+			stack[sp] = vm.globalCell(ops[pc+1]).get()
+			pc++ // the rest is a call instruction
+			fallthrough
+		case opcodeCall: // CALL
 //			println("opcodeCall")
 			argc := ops[pc+1]
 			fun := stack[sp]
@@ -831,39 +1264,40 @@ func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
 				nextSp := sp + argc
 				val, err := vm.callPrimitive(fun.primitive, stack[sp+1:nextSp+1])
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
+					ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 					if err != nil {
 						return nil, err
 					}
+				} else {
+					stack[nextSp] = val
+					sp = nextSp
+					pc += 2
 				}
-				stack[nextSp] = val
-				sp = nextSp
-				pc += 2
 			} else if fun.Type == FunctionType { // defined in data.go
 				ops, pc, sp, env, err = vm.funcall(fun, argc, ops, pc+2, stack, sp+1, env) // call function
+				stack = vm.stack
 				if err != nil {
 					return nil, err
 				}
 			} else if fun.Type == KeywordType { // defined in data.go
-				pc, sp, err = vm.keywordCall(fun, argc, pc+2, stack, sp+1) // call keyword
+				pc, sp, err = vm.keywordCall(fun, argc, pc, stack, sp+1) // call keyword
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
+					ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 					if err != nil {
 						return nil, err
 					}
 				}
 			} else { // e.g: (1)
-				ops, pc, sp, env, err = vm.catch(Error(ArgumentErrorKey, "Not callable: ", fun), stack, env) // catch
+				ops, pc, sp, env, err = vm.catch(Error(ArgumentErrorKey, "Not callable: ", fun), stack, env, pc) // catch
 				if err != nil { // log err
 					return nil, err
 				}
 			}
-		} else if op == opcodeGlobal { // GObjectAL
-			sym := constants[ops[pc+1]]
+		case opcodeGlobal: // GObjectAL
 			sp--
-			stack[sp] = sym.car
+			stack[sp] = vm.globalCell(ops[pc+1]).get()
 			pc += 2
-		} else if op == opcodeLocal {
+		case opcodeLocal:
 //			println("opcodelocal")
 			tmpEnv := env
 			i := ops[pc+1]
@@ -876,7 +1310,7 @@ func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
 			sp--
 			stack[sp] = val
 			pc += 3
-		} else if op == opcodeJumpFalse {
+		case opcodeJumpFalse:
 			b := stack[sp]
 			sp++
 			if b == False {
@@ -884,32 +1318,46 @@ func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
 			} else {
 				pc += 2
 			}
-		} else if op == opcodePop { // Pop
+		case opcodeLocalJumpFalse:
+			tmpEnv := env
+			i := ops[pc+1]
+			for i > 0 {
+				tmpEnv = tmpEnv.locals
+				i--
+			}
+			if tmpEnv.elements[ops[pc+2]] == False {
+				pc += ops[pc+3]
+			} else {
+				pc += 4
+			}
+		case opcodePop: // Pop
 //			println("opcodepop")
 			sp++
 			pc++
-		} else if op == opcodeTailCall {
+		case opcodeTailCall:
 			fun := stack[sp]
 			argc := ops[pc+1]
 			if fun.primitive != nil {
 				nextSp := sp + argc
 				val, err := vm.callPrimitive(fun.primitive, stack[sp+1:nextSp+1])
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
+					ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 					if err != nil {
 						return nil, err
 					}
+				} else {
+					stack[nextSp] = val
+					sp = nextSp
+					ops = env.ops
+					pc = env.pc
+					env = env.previous
+					if env == nil {
+						return stack[sp], nil
+					}
 				}
-				stack[nextSp] = val
-				sp = nextSp
-				ops = env.ops
-				pc = env.pc
-				env = env.previous
-				if env == nil {
-					return stack[sp], nil
-				}
-			} else if fun.Type == fun.Type {
-				ops, pc, sp, env, err = vm.tailcall(fun, argc, ops, stack, sp+1, env)
+			} else if fun.Type == FunctionType {
+				ops, pc, sp, env, err = vm.tailcall(fun, argc, ops, pc, stack, sp+1, env)
+				stack = vm.stack
 				if err != nil {
 					return nil, err
 				}
@@ -917,29 +1365,25 @@ func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
 					return stack[sp], nil
 				}
 			} else if fun.Type == KeywordType {
-				ops, pc, sp, env, err = vm.keywordTailcall(fun, argc, ops, stack, sp+1, env)
+				ops, pc, sp, env, err = vm.keywordTailcall(fun, argc, ops, pc, stack, sp+1, env) // its errors are caught already
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
-					if err != nil {
-						return nil, err
-					}
-				} else {
-					if env == nil {
-						return stack[sp], nil
-					}
+					return nil, err
+				}
+				if env == nil {
+					return stack[sp], nil
 				}
 			} else {
-				ops, pc, sp, env, err = vm.catch(Error(ArgumentErrorKey, "Not callable: ", fun), stack, env)
+				ops, pc, sp, env, err = vm.catch(Error(ArgumentErrorKey, "Not callable: ", fun), stack, env, pc)
 				if err != nil {
 					return nil, err
 				}
 			}
-		} else if op == opcodeLiteral {
+		case opcodeLiteral:
 //			println("opcodeLiteral")
 			sp--
-			stack[sp] = constants[ops[pc+1]]
+			stack[sp] = vm.constant(ops[pc+1])
 			pc += 2
-		} else if op == opcodeSetLocal {
+		case opcodeSetLocal:
 			tmpEnv := env
 			i := ops[pc+1]
 			for i > 0 {
@@ -949,37 +1393,38 @@ func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
 			j := ops[pc+2]
 			tmpEnv.elements[j] = stack[sp]
 			pc += 3
-		} else if op == opcodeClosure {
+		case opcodeClosure:
 			sp--
-			stack[sp] = Closure(constants[ops[pc+1]].code, env)
+			stack[sp] = Closure(vm.constant(ops[pc+1]).code, env)
+			vm.allocations++
 			pc = pc + 2
-		} else if op == opcodeReturn {
+		case opcodeReturn:
 			if env.previous == nil {
 				return stack[sp], nil
 			}
 			ops = env.ops
 			pc = env.pc
 			env = env.previous
-		} else if op == opcodeJump {
+		case opcodeJump:
 			pc += ops[pc+1]
-		} else if op == opcodeDefGlobal {
-			sym := constants[ops[pc+1]]
-			defGlobal(sym, stack[sp])
+		case opcodeDefGlobal:
+			sym := vm.constant(ops[pc+1])
+			vm.rt.defGlobal(sym, stack[sp])
 			pc += 2
-		} else if op == opcodeUndefGlobal {
-			sym := constants[ops[pc+1]]
-			undefGlobal(sym)
+		case opcodeUndefGlobal:
+			sym := vm.constant(ops[pc+1])
+			vm.rt.undefGlobal(sym)
 			pc += 2
-		} else if op == opcodeDefMacro {
-			sym := constants[ops[pc+1]]
-			defMacro(sym, stack[sp])
+		case opcodeDefMacro:
+			sym := vm.constant(ops[pc+1])
+			vm.rt.defMacro(sym, stack[sp])
 			stack[sp] = sym
 			pc += 2
-		} else if op == opcodeImport {
-			sym := constants[ops[pc+1]]
-			err := Import(sym)
+		case opcodeImport:
+			sym := vm.constant(ops[pc+1])
+			err := vm.rt.Import(sym)
 			if err != nil {
-				ops, pc, sp, env, err = vm.catch(err, stack, env)
+				ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 				if err != nil {
 					return nil, err
 				}
@@ -988,29 +1433,58 @@ func (vm *vm) exec(code *Code, env *frame) (*Object, error) {
 				stack[sp] = sym
 				pc += 2
 			}
-		} else if op == opcodeVector {
+		case opcodeVector:
 			vlen := ops[pc+1]
+			vm.allocations += int64(vlen) + 1
 			v := Vector(stack[sp : sp+vlen]...)
 			sp = sp + vlen - 1
 			stack[sp] = v
 			pc += 2
-		} else if op == opcodeStruct {
+		case opcodeStruct:
 			vlen := ops[pc+1]
+			vm.allocations += int64(vlen) + 1
 			v, _ := Struct(stack[sp : sp+vlen])
 			sp = sp + vlen - 1
 			stack[sp] = v
 			pc += 2
-		} else {
+		case opcodePushHandler:
+			vm.handlers = append(vm.handlers, handler{vm.constant(ops[pc+1]), ops, pc, sp, env})
+			pc += 2
+		case opcodePopHandler:
+			if len(vm.handlers) == 0 {
+				return nil, addContext(env, pc, Error(ErrorKey, "pophandler without an active handler"))
+			}
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			pc++
+		case opcodeThrow:
+			ops, pc, sp, env, err = vm.catch(thrownError(stack[sp]), stack, env, pc)
+			stack = vm.stack
+			if err != nil {
+				return nil, err
+			}
+		default:
 			panic("Bad instruction")
 		}
 	}
 }
 
+// thrownError - return the error for the object given to the throw instruction. That is the escape being unwound
+// when a finally block run for it throws it again.
+func thrownError(obj *Object) error {
+	if IsError(obj) {
+		if e, ok := obj.Value.(*escape); ok {
+			return e
+		}
+		return obj
+	}
+	return MakeError(ErrorKey, obj)
+}
+
 const stackColumn = 40 // Vile stack columns => 40
 
 func showInstruction(pc int, op int, args string, stack []*Object, sp int) { // showInstruction function
 	var body string
-	body = leftJustified(fmt.Sprintf("%d ", pc), 8) + leftJustified(opsyms[op].text, 10) + args
+	body = leftJustified(fmt.Sprintf("%d ", pc), 8) + leftJustified(opsyms[op].text, 15) + args
 	println(leftJustified(body, stackColumn), showStack(stack, sp))
 }
 
@@ -1075,15 +1549,50 @@ func showStack(stack []*Object, sp int) string {
 
 // used in exec when optimize||verbose||trace value is false
 func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
-	stack := make([]*Object, vm.stackSize) // stack
-	sp := vm.stackSize // stack pointer
+	trace := vm.rt.trace
+	if vm.stack == nil { // else the stack of a previous call, see call
+		vm.stack = make([]*Object, vm.stackSize)
+	}
+	sp, err := vm.ensureStack(len(vm.stack), len(code.ops)) // stack pointer
+	if err != nil {
+		return nil, err
+	}
+	stack := vm.stack // stack, reloaded from vm.stack whenever a call may have grown it
 	ops := code.ops // opcodes
 	pc := 0 // program counter
-	var err error // error
 	for {
+		if vm.budget == 0 {
+			if err = vm.checkLimits(env, pc); err != nil {
+				return nil, err
+			}
+		}
+		vm.budget--
 		op := ops[pc]
-		if op == opcodeCall { // CALL
+		switch op {
+		case opcodeGlobalCall:
+			sym := vm.constant(ops[pc+1])
+			val := vm.globalCell(ops[pc+1]).get()
+			if val == nil {
+				err := Error(ErrorKey, "Undefined symbol: ", sym)
+				ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if trace {
+				showInstruction(pc, op, sym.text, stack, sp)
+			}
+			sp--
+			stack[sp] = val
+			pc++ // the rest is a call instruction
+			op = opcodeCall
+			fallthrough
+		case opcodeCall: // CALL
 //			println("call")
+			if vm.profiler != nil {
+				vm.calling = profileCaller{env: env, pc: pc}
+			}
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d", ops[pc+1]), stack, sp)
 			}
@@ -1093,7 +1602,7 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 				nextSp := sp + argc
 				val, err := vm.callPrimitive(fun.primitive, stack[sp+1:nextSp+1])
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
+					ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 					if err != nil {
 						return nil, err
 					}
@@ -1104,30 +1613,32 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 				}
 			} else if fun.Type == FunctionType {
 				ops, pc, sp, env, err = vm.funcall(fun, argc, ops, pc+2, stack, sp+1, env)
+				stack = vm.stack
 				if err != nil {
 					return nil, err
 				}
 			} else if fun.Type == KeywordType {
-				pc, sp, err = vm.keywordCall(fun, argc, pc+2, stack, sp+1)
+				pc, sp, err = vm.keywordCall(fun, argc, pc, stack, sp+1)
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
+					ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 					if err != nil {
 						return nil, err
 					}
 				}
 			} else {
 				err := Error(ArgumentErrorKey, "Not callable: ", fun)
-				ops, pc, sp, env, err = vm.catch(err, stack, env)
+				ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 				if err != nil {
 					return nil, err
 				}
 			}
-		} else if op == opcodeGlobal { // GObjectAL
-			sym := constants[ops[pc+1]]
-			if sym.car == nil {
+		case opcodeGlobal: // GObjectAL
+			sym := vm.constant(ops[pc+1])
+			val := vm.globalCell(ops[pc+1]).get()
+			if val == nil {
 //				fmt.Printf("runtime.go checkpoint opcodeGlobal: %v and %T", sym.car)
 				err := Error(ErrorKey, "Undefined symbol: ", sym)
-				ops, pc, sp, env, err = vm.catch(err, stack, env)
+				ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 				if err != nil {
 					return nil, err
 				}
@@ -1136,10 +1647,10 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 					showInstruction(pc, op, sym.text, stack, sp)
 				}
 				sp--
-				stack[sp] = sym.car
+				stack[sp] = val
 				pc += 2
 			}
-		} else if op == opcodeLocal {
+		case opcodeLocal:
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d, %d", ops[pc+1], ops[pc+2]), stack, sp)
 			}
@@ -1155,7 +1666,7 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 			sp--
 			stack[sp] = val
 			pc += 3
-		} else if op == opcodeJumpFalse {
+		case opcodeJumpFalse:
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d", pc+ops[pc+1]), stack, sp)
 			}
@@ -1166,15 +1677,33 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 			} else {
 				pc += 2
 			}
-		} else if op == opcodePop {
+		case opcodeLocalJumpFalse:
+			if trace {
+				showInstruction(pc, op, fmt.Sprintf("%d, %d, %d", ops[pc+1], ops[pc+2], pc+ops[pc+3]), stack, sp)
+			}
+			tmpEnv := env
+			i := ops[pc+1]
+			for i > 0 {
+				tmpEnv = tmpEnv.locals
+				i--
+			}
+			if tmpEnv.elements[ops[pc+2]] == False {
+				pc += ops[pc+3]
+			} else {
+				pc += 4
+			}
+		case opcodePop:
 			if trace {
 				showInstruction(pc, op, "", stack, sp)
 			}
 			sp++
 			pc++
-		} else if op == opcodeTailCall {
-			if interrupted || checkInterrupt() {
-				return nil, addContext(env, Error(InterruptKey)) // not catchable
+		case opcodeTailCall:
+			if isInterrupted() {
+				return nil, addContext(env, pc, Error(InterruptKey)) // not catchable
+			}
+			if vm.profiler != nil {
+				vm.calling = profileCaller{env: env, pc: pc}
 			}
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d", ops[pc+1]), stack, sp)
@@ -1185,22 +1714,23 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 				nextSp := sp + argc
 				val, err := vm.callPrimitive(fun.primitive, stack[sp+1:nextSp+1])
 				if err != nil {
-					ops, pc, sp, env, err = vm.catch(err, stack, env)
+					ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 					if err != nil {
 						return nil, err
 					}
 				} else {
 					stack[nextSp] = val
 					sp = nextSp
+					if env.previous == nil {
+						return stack[sp], nil // with the frame of the call, for the profiler
+					}
 					ops = env.ops
 					pc = env.pc
 					env = env.previous
-					if env == nil {
-						return stack[sp], nil
-					}
 				}
 			} else if fun.Type == FunctionType {
-				ops, pc, sp, env, err = vm.tailcall(fun, argc, ops, stack, sp+1, env)
+				ops, pc, sp, env, err = vm.tailcall(fun, argc, ops, pc, stack, sp+1, env)
+				stack = vm.stack
 				if err != nil {
 					return nil, err
 				}
@@ -1208,24 +1738,27 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 					return stack[sp], nil
 				}
 			} else if fun.Type == KeywordType {
-				ops, pc, sp, env, err = vm.keywordTailcall(fun, argc, ops, stack, sp+1, env)
+				ops, pc, sp, env, err = vm.keywordTailcall(fun, argc, ops, pc, stack, sp+1, env)
 				if err != nil {
 					return nil, err
 				}
-				if env.previous == nil {
+				if env == nil {
 					return stack[sp], nil
 				}
 			} else {
-				return nil, addContext(env, Error(ArgumentErrorKey, "Not callable: ", fun))
+				ops, pc, sp, env, err = vm.catch(Error(ArgumentErrorKey, "Not callable: ", fun), stack, env, pc)
+				if err != nil {
+					return nil, err
+				}
 			}
-		} else if op == opcodeLiteral {
+		case opcodeLiteral:
 			if trace {
-				showInstruction(pc, op, Write(constants[ops[pc+1]].Type), stack, sp)
+				showInstruction(pc, op, Write(vm.constant(ops[pc+1]).Type), stack, sp)
 			}
 			sp--
-			stack[sp] = constants[ops[pc+1]]
+			stack[sp] = vm.constant(ops[pc+1])
 			pc += 2
-		} else if op == opcodeSetLocal {
+		case opcodeSetLocal:
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d, %d", ops[pc+1], ops[pc+2]), stack, sp)
 			}
@@ -1238,16 +1771,17 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 			j := ops[pc+2]
 			tmpEnv.elements[j] = stack[sp]
 			pc += 3
-		} else if op == opcodeClosure {
+		case opcodeClosure:
 			if trace {
 				showInstruction(pc, op, "", stack, sp)
 			}
 			sp--
-			stack[sp] = Closure(constants[ops[pc+1]].code, env)
+			stack[sp] = Closure(vm.constant(ops[pc+1]).code, env)
+			vm.allocations++
 			pc = pc + 2
-		} else if op == opcodeReturn {
-			if interrupted || checkInterrupt() {
-				return nil, addContext(env, Error(InterruptKey)) // not catchable
+		case opcodeReturn:
+			if isInterrupted() {
+				return nil, addContext(env, pc, Error(InterruptKey)) // not catchable
 			}
 			if trace {
 				showInstruction(pc, op, "", stack, sp)
@@ -1258,67 +1792,94 @@ func (vm *vm) instrumentedExec(code *Code, env *frame) (*Object, error) {
 			ops = env.ops
 			pc = env.pc
 			env = env.previous
-		} else if op == opcodeJump {
+		case opcodeJump:
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d", pc+ops[pc+1]), stack, sp)
 			}
 			pc += ops[pc+1]
-		} else if op == opcodeDefGlobal {
-			sym := constants[ops[pc+1]]
+		case opcodeDefGlobal:
+			sym := vm.constant(ops[pc+1])
 			if trace {
 				showInstruction(pc, op, sym.text, stack, sp)
 			}
-			defGlobal(sym, stack[sp])
+			vm.rt.defGlobal(sym, stack[sp])
 			pc += 2
-		} else if op == opcodeUndefGlobal {
-			sym := constants[ops[pc+1]]
+		case opcodeUndefGlobal:
+			sym := vm.constant(ops[pc+1])
 			if trace {
 				showInstruction(pc, op, sym.text, stack, sp)
 			}
-			undefGlobal(sym)
+			vm.rt.undefGlobal(sym)
 			pc += 2
-		} else if op == opcodeDefMacro {
-			sym := constants[ops[pc+1]]
+		case opcodeDefMacro:
+			sym := vm.constant(ops[pc+1])
 			if trace {
 				showInstruction(pc, op, sym.text, stack, sp)
 			}
-			defMacro(sym, stack[sp])
+			vm.rt.defMacro(sym, stack[sp])
 			stack[sp] = sym
 			pc += 2
-		} else if op == opcodeImport {
-			sym := constants[ops[pc+1]]
+		case opcodeImport:
+			sym := vm.constant(ops[pc+1])
 			if trace {
 				showInstruction(pc, op, sym.text, stack, sp)
 			}
-			err := Import(sym)
+			err := vm.rt.Import(sym)
 			if err != nil {
-				ops, pc, sp, env, err = vm.catch(err, stack, env)
+				ops, pc, sp, env, err = vm.catch(err, stack, env, pc)
 				if err != nil {
 					return nil, err
 				}
+			} else {
+				sp--
+				stack[sp] = sym
+				pc += 2
 			}
-			sp--
-			stack[sp] = sym
-			pc += 2
-		} else if op == opcodeVector {
+		case opcodeVector:
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d", ops[pc+1]), stack, sp)
 			}
 			vlen := ops[pc+1]
+			vm.allocations += int64(vlen) + 1
 			v := Vector(stack[sp : sp+vlen]...)
 			sp = sp + vlen - 1
 			stack[sp] = v
 			pc += 2
-		} else if op == opcodeStruct {
+		case opcodeStruct:
 			if trace {
 				showInstruction(pc, op, fmt.Sprintf("%d", ops[pc+1]), stack, sp)
 			}
 			vlen := ops[pc+1]
+			vm.allocations += int64(vlen) + 1
 			v, _ := Struct(stack[sp : sp+vlen])
 			sp = sp + vlen - 1
 			stack[sp] = v
 			pc += 2
-		} else {
+		case opcodePushHandler:
+			if trace {
+				showInstruction(pc, op, Write(vm.constant(ops[pc+1])), stack, sp)
+			}
+			vm.handlers = append(vm.handlers, handler{vm.constant(ops[pc+1]), ops, pc, sp, env})
+			pc += 2
+		case opcodePopHandler:
+			if trace {
+				showInstruction(pc, op, "", stack, sp)
+			}
+			if len(vm.handlers) == 0 {
+				return nil, addContext(env, pc, Error(ErrorKey, "pophandler without an active handler"))
+			}
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			pc++
+		case opcodeThrow:
+			if trace {
+				showInstruction(pc, op, "", stack, sp)
+			}
+			ops, pc, sp, env, err = vm.catch(thrownError(stack[sp]), stack, env, pc)
+			stack = vm.stack
+			if err != nil {
+				return nil, err
+			}
+		default:
 			panic("Bad instruction")
 		}
 	}
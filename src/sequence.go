@@ -0,0 +1,337 @@
+package vile
+
+import (
+	"sort"
+	"strings"
+)
+
+// The sequence functions work on lists, vectors, strings and structs alike, persistent or not. The elements of
+// a string are its characters, and those of a struct are its [key value] entries. Functions returning a sequence derived from
+// another one return one of the same type: a string is made of the characters (or strings) it is given, and a
+// struct of [key value] entries.
+
+// sequenceElements - return the elements of the sequence. The slice of a vector is shared, not copied.
+func sequenceElements(seq *Object) ([]*Object, error) {
+	switch seq.Type {
+	case ListType:
+		var elements []*Object
+		for lst := seq; lst != EmptyList; lst = lst.cdr {
+			elements = append(elements, lst.car)
+		}
+		return elements, nil
+	case VectorType:
+		return seq.elements, nil
+	case StringType:
+		return StringCharacters(seq), nil
+	case StructType:
+		return structToVector(seq).elements, nil
+	case PersistentVectorType:
+		return seq.Value.(*pvector).elements(), nil
+	case PersistentStructType:
+		var elements []*Object
+		seq.Value.(*pstruct).root.each(func(key structKey, val *Object) {
+			elements = append(elements, Vector(key.toObject(), val))
+		})
+		return elements, nil
+	}
+	return nil, Error(ArgumentErrorKey, "Expected a <list>, <vector>, <string> or <struct>, got a ", seq.Type)
+}
+
+// sequenceLike - return a new sequence of the same type as seq, with the elements
+func sequenceLike(seq *Object, elements []*Object) (*Object, error) {
+	switch seq.Type {
+	case VectorType:
+		return VectorFromElementsNoCopy(elements), nil
+	case StringType:
+		var buf strings.Builder
+		for _, o := range elements {
+			switch o.Type {
+			case CharacterType:
+				buf.WriteRune(rune(o.fval))
+			case StringType:
+				buf.WriteString(o.text)
+			default:
+				return nil, Error(ArgumentErrorKey, "Expected a <char> or <string> for an element of a <string>, got a ", o.Type)
+			}
+		}
+		return String(buf.String()), nil
+	case StructType, PersistentStructType:
+		strct := MakeStruct(len(elements))
+		for _, o := range elements {
+			if !IsVector(o) || len(o.elements) != 2 || !IsValidStructKey(o.elements[0]) {
+				return nil, Error(ArgumentErrorKey, "Expected a [key value] entry for an element of a <struct>, got ", o)
+			}
+			Put(strct, o.elements[0], o.elements[1])
+		}
+		if seq.Type == PersistentStructType {
+			return ToPersistent(strct)
+		}
+		return strct, nil
+	case PersistentVectorType:
+		return persistentVector(pvectorOf(elements)), nil
+	}
+	return ListFromValues(elements), nil
+}
+
+// Map - return the results of calling the function with the successive elements of the sequences, as a sequence
+// of the type of the first one. With more than one sequence, the function takes an element of each of them, and
+// the result is as long as the shortest one.
+func (rt *Runtime) Map(fun *Object, seqs ...*Object) (*Object, error) {
+	lists := make([][]*Object, len(seqs))
+	count := -1
+	for i, seq := range seqs {
+		elements, err := sequenceElements(seq)
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = elements
+		if count < 0 || len(elements) < count {
+			count = len(elements)
+		}
+	}
+	vm := rt.newVM(defaultStackSize)
+	results := make([]*Object, count)
+	for i := range results {
+		args := make([]*Object, len(lists))
+		for j, elements := range lists {
+			args[j] = elements[i]
+		}
+		val, err := vm.call(fun, args)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = val
+	}
+	return sequenceLike(seqs[0], results)
+}
+
+// Filter - return the elements of the sequence for which the predicate is not false. Like if, it takes null as true.
+func (rt *Runtime) Filter(pred *Object, seq *Object) (*Object, error) {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return nil, err
+	}
+	vm := rt.newVM(defaultStackSize)
+	var results []*Object
+	for _, o := range elements {
+		val, err := vm.call(pred, []*Object{o})
+		if err != nil {
+			return nil, err
+		}
+		if val != False {
+			results = append(results, o)
+		}
+	}
+	return sequenceLike(seq, results)
+}
+
+// Reduce - combine the elements of the sequence, calling the function with the result so far (initially init)
+// and each element in turn
+func (rt *Runtime) Reduce(fun *Object, init *Object, seq *Object) (*Object, error) {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return nil, err
+	}
+	vm := rt.newVM(defaultStackSize)
+	result := init
+	for _, o := range elements {
+		result, err = vm.call(fun, []*Object{result, o})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// ForEach - call the function with each element of the sequence, for its side effects
+func (rt *Runtime) ForEach(fun *Object, seq *Object) error {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return err
+	}
+	vm := rt.newVM(defaultStackSize)
+	for _, o := range elements {
+		if _, err := vm.call(fun, []*Object{o}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sort - return the elements of the sequence in order. Without a comparator they are ordered by Compare. A
+// comparator is a function of two elements returning either a <number>, negative if the first orders before
+// the second, or a <boolean>, true if it does. A stable sort keeps equal elements in their original order. A
+// struct, which has no order, is sorted into a vector of its entries.
+func (rt *Runtime) Sort(seq *Object, comparator *Object, stable bool) (*Object, error) {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]*Object, len(elements))
+	copy(sorted, elements)
+	vm := rt.newVM(defaultStackSize)
+	less := func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var c *Object
+		if comparator == Null {
+			var n int
+			n, err = Compare(sorted[i], sorted[j])
+			return n < 0
+		}
+		c, err = vm.call(comparator, []*Object{sorted[i], sorted[j]})
+		if err != nil {
+			return false
+		}
+		switch c.Type {
+		case BooleanType:
+			return c == True
+		case NumberType:
+			return c.fval < 0
+		}
+		err = Error(ArgumentErrorKey, "sort comparator expected to return a <number> or <boolean>, got a ", c.Type)
+		return false
+	}
+	if stable {
+		sort.SliceStable(sorted, less)
+	} else {
+		sort.Slice(sorted, less)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if seq.Type == StructType || seq.Type == PersistentStructType {
+		return VectorFromElementsNoCopy(sorted), nil
+	}
+	return sequenceLike(seq, sorted)
+}
+
+// Take - return the first n elements of the sequence, or all of them if it has fewer
+func Take(n int, seq *Object) (*Object, error) {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n < len(elements) {
+		elements = elements[:n]
+	}
+	return sequenceLike(seq, append([]*Object(nil), elements...))
+}
+
+// Drop - return the elements of the sequence after the first n
+func Drop(n int, seq *Object) (*Object, error) {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n > len(elements) {
+		n = len(elements)
+	}
+	return sequenceLike(seq, append([]*Object(nil), elements[n:]...))
+}
+
+// Zip - return a list of vectors of the corresponding elements of the sequences, as long as the shortest one
+func Zip(seqs ...*Object) (*Object, error) {
+	lists := make([][]*Object, len(seqs))
+	count := -1
+	for i, seq := range seqs {
+		elements, err := sequenceElements(seq)
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = elements
+		if count < 0 || len(elements) < count {
+			count = len(elements)
+		}
+	}
+	tuples := make([]*Object, count)
+	for i := range tuples {
+		tuple := make([]*Object, len(lists))
+		for j, elements := range lists {
+			tuple[j] = elements[i]
+		}
+		tuples[i] = VectorFromElementsNoCopy(tuple)
+	}
+	return ListFromValues(tuples), nil
+}
+
+// Range - return a list of the numbers from start up to, but not including, end, by step. A negative step
+// counts down.
+func Range(start *Object, end *Object, step *Object) (*Object, error) {
+	c, ok := numberCompare(step, Zero)
+	if !ok || c == 0 {
+		return nil, Error(ArgumentErrorKey, "range step cannot be ", step)
+	}
+	var numbers []*Object
+	for n := start; ; n = numberAdd(n, step) {
+		if d, ok := numberCompare(n, end); !ok || d*c >= 0 {
+			break
+		}
+		numbers = append(numbers, n)
+	}
+	return ListFromValues(numbers), nil
+}
+
+// Nth - return the element of the sequence at the index
+func Nth(seq *Object, idx int) (*Object, error) {
+	if seq.Type == ListType {
+		for lst, i := seq, 0; lst != EmptyList; lst, i = lst.cdr, i+1 {
+			if i == idx {
+				return lst.car, nil
+			}
+		}
+	} else if seq.Type == StringType {
+		if c := StringRef(seq, idx); c != Null {
+			return c, nil
+		}
+	} else if seq.Type == PersistentVectorType {
+		if v := seq.Value.(*pvector); idx >= 0 && idx < v.count {
+			return v.get(idx), nil
+		}
+	} else {
+		elements, err := sequenceElements(seq)
+		if err != nil {
+			return nil, err
+		}
+		if idx >= 0 && idx < len(elements) {
+			return elements[idx], nil
+		}
+	}
+	return nil, Error(ArgumentErrorKey, "nth: index out of range: ", idx)
+}
+
+// Position - return the index of the first element of the sequence that is Equal to x, or, if x is a function,
+// for which it is not false. The result is -1 if there is no such element.
+func (rt *Runtime) Position(x *Object, seq *Object) (int, error) {
+	elements, err := sequenceElements(seq)
+	if err != nil {
+		return 0, err
+	}
+	var vm *vm
+	if x.Type == FunctionType {
+		vm = rt.newVM(defaultStackSize)
+	}
+	for i, o := range elements {
+		if vm == nil {
+			if Equal(x, o) {
+				return i, nil
+			}
+			continue
+		}
+		val, err := vm.call(x, []*Object{o})
+		if err != nil {
+			return 0, err
+		}
+		if val != False {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
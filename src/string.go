@@ -2,6 +2,7 @@ package vile
 
 import (
 	"strings"
+	"unicode/utf8"
 )
 
 // EmptyString
@@ -46,6 +47,12 @@ func ToString(a *Object) (*Object, error) {
 		return String(string([]rune{rune(a.fval)})), nil
 	case StringType:
 		return a, nil
+	case StringBuilderType:
+		sb, err := theStringBuilder(a)
+		if err != nil {
+			return nil, err
+		}
+		return String(sb.buf.String()), nil
 	case SymbolType, KeywordType, TypeType:
 		return String(a.text), nil
 	case NumberType, BooleanType:
@@ -162,17 +169,49 @@ func StringCharacters(s *Object) []*Object {
 	return chars
 }
 
-// StringRef - return the <character> object at the specified string index
+// StringRef - return the <character> object at the specified character index, or null if there is none
 func StringRef(s *Object, idx int) *Object {
 	//utf8 requires a scan
-	for i, r := range s.text {
+	i := 0
+	for _, r := range s.text {
 		if i == idx {
 			return Character(r)
 		}
+		i++
 	}
 	return Null
 }
 
+// byteOffset - return the offset in bytes of the character at the index, or the length of the string if the
+// index is past its end
+func byteOffset(s string, idx int) int {
+	i := 0
+	for offset := range s {
+		if i == idx {
+			return offset
+		}
+		i++
+	}
+	return len(s)
+}
+
+// Substring - return the characters of the string from start up to end, both character indices
+func Substring(s *Object, start int, end int) (*Object, error) {
+	if start < 0 || start > end || end > StringLength(s.text) {
+		return nil, Error(ArgumentErrorKey, "substring: indices out of range: ", start, " ", end)
+	}
+	return String(s.text[byteOffset(s.text, start):byteOffset(s.text, end)]), nil
+}
+
+// StringIndex - return the character index of the first occurrence of the substring in the string, or -1
+func StringIndex(s *Object, sub *Object) int {
+	i := strings.Index(s.text, sub.text)
+	if i < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(s.text[:i])
+}
+
 func stringToVector(s *Object) *Object {
 	return Vector(StringCharacters(s)...)
 }
@@ -238,3 +277,42 @@ func StringJoin(seq *Object, delims *Object) (*Object, error) {
 		return nil, Error(ArgumentErrorKey, "join expected a <list> or <vector> for argument 1, got a ", seq.Type)
 	}
 }
+
+// StringBuilderType is the type of string builders, which accumulate a string efficiently
+var StringBuilderType = Intern("<string-builder>")
+
+type stringBuilder struct {
+	buf strings.Builder
+}
+
+// StringBuilder - create a new, empty, <string-builder> object
+func StringBuilder() *Object {
+	return NewObject(StringBuilderType, &stringBuilder{})
+}
+
+func theStringBuilder(obj *Object) (*stringBuilder, error) {
+	if sb, ok := obj.Value.(*stringBuilder); ok {
+		return sb, nil
+	}
+	return nil, Error(ArgumentErrorKey, "Expected a <string-builder>, got a ", obj.Type)
+}
+
+// StringBuilderAppend - append the objects to the builder: strings and characters as they are, anything else
+// as it is displayed
+func StringBuilderAppend(obj *Object, args ...*Object) error {
+	sb, err := theStringBuilder(obj)
+	if err != nil {
+		return err
+	}
+	for _, o := range args {
+		switch o.Type {
+		case StringType:
+			sb.buf.WriteString(o.text)
+		case CharacterType:
+			sb.buf.WriteRune(rune(o.fval))
+		default:
+			sb.buf.WriteString(o.String())
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package vile
 
 import (
 	"bytes"
+	"sort"
 )
 
 // Key - the key type for Structs. The string value and Vile type string are combined, so we can extract
@@ -22,6 +23,69 @@ func (k structKey) toObject() *Object {
 	return Intern(k.keyValue)
 }
 
+// structBindings - the bindings of a <struct>, in the order their keys were first added
+type structBindings struct {
+	index   map[structKey]int // the position of each key in the entries
+	entries []structEntry
+}
+
+type structEntry struct {
+	key   structKey
+	value *Object
+}
+
+func newStructBindings(capacity int) *structBindings {
+	return &structBindings{index: make(map[structKey]int, capacity), entries: make([]structEntry, 0, capacity)}
+}
+
+func (b *structBindings) get(k structKey) (*Object, bool) {
+	if i, ok := b.index[k]; ok {
+		return b.entries[i].value, true
+	}
+	return nil, false
+}
+
+// put - bind the key to the value. A key that is already bound keeps its position.
+func (b *structBindings) put(k structKey, v *Object) {
+	if i, ok := b.index[k]; ok {
+		b.entries[i].value = v
+		return
+	}
+	b.index[k] = len(b.entries)
+	b.entries = append(b.entries, structEntry{k, v})
+}
+
+func (b *structBindings) remove(k structKey) {
+	i, ok := b.index[k]
+	if !ok {
+		return
+	}
+	delete(b.index, k)
+	copy(b.entries[i:], b.entries[i+1:])
+	b.entries[len(b.entries)-1] = structEntry{}
+	b.entries = b.entries[:len(b.entries)-1]
+	for ; i < len(b.entries); i++ {
+		b.index[b.entries[i].key] = i
+	}
+}
+
+func (b *structBindings) size() int {
+	return len(b.entries)
+}
+
+// sorted - return the entries ordered by key, for a deterministic order that does not depend on how the struct
+// was built
+func (b *structBindings) sorted() []structEntry {
+	entries := append([]structEntry(nil), b.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key.keyValue != entries[j].key.keyValue {
+			return entries[i].key.keyValue < entries[j].key.keyValue
+		}
+		return entries[i].key.keyType < entries[j].key.keyType
+	})
+	return entries
+}
+
 // IsValidStructKey - return true of the object is a valid <struct> key.
 func IsValidStructKey(o *Object) bool {
 	switch o.Type {
@@ -38,53 +102,41 @@ var EmptyStruct = MakeStruct(0)
 func MakeStruct(capacity int) *Object {
 	strct := new(Object)
 	strct.Type = StructType
-	strct.bindings = make(map[structKey]*Object, capacity)
+	strct.bindings = newStructBindings(capacity)
 	return strct
 }
 
-// Struct - create a new <struct> object from the arguments, which can be other structs, or key/value pairs
+// Struct - create a new <struct> object from the arguments, which can be other structs, or key/value pairs.
+// The keys are kept in the order they are given.
 func Struct(fieldvals []*Object) (*Object, error) {
-	strct := new(Object)
-	strct.Type = StructType
-	strct.bindings = make(map[structKey]*Object)
 	count := len(fieldvals)
+	strct := MakeStruct(count / 2)
+	bindings := strct.bindings
 	i := 0
-	var bindings map[structKey]*Object
 	for i < count {
 		o := Value(fieldvals[i])
 		i++
 		switch o.Type {
 		case StructType: // not a valid key, just copy bindings from it
-			if bindings == nil {
-				bindings = make(map[structKey]*Object, len(o.bindings))
-			}
-			for k, v := range o.bindings {
-				bindings[k] = v
+			for _, e := range o.bindings.entries {
+				bindings.put(e.key, e.value)
 			}
 		case StringType, SymbolType, KeywordType, TypeType:
 			if i == count {
 				return nil, Error(ArgumentErrorKey, "Mismatched keyword/value in arglist: ", o)
 			}
-			if bindings == nil {
-				bindings = make(map[structKey]*Object)
-			}
-			bindings[newStructKey(o)] = fieldvals[i]
+			bindings.put(newStructKey(o), fieldvals[i])
 			i++
 		default:
 			return nil, Error(ArgumentErrorKey, "Bad struct key: ", o)
 		}
 	}
-	if bindings == nil {
-		strct.bindings = make(map[structKey]*Object)
-	} else {
-		strct.bindings = bindings
-	}
 	return strct, nil
 }
 
 // StructLength - return the length (field count) of the <struct> object
 func StructLength(strct *Object) int {
-	return len(strct.bindings)
+	return strct.bindings.size()
 }
 
 // Get - return the value for the key of the object. The Value() function is first called to
@@ -92,6 +144,14 @@ func StructLength(strct *Object) int {
 // This is called by the VM, when a keyword is used as a function.
 func Get(obj *Object, key *Object) (*Object, error) {
 	s := Value(obj) // defined in data.go - Value
+	if s.Type == PersistentStructType {
+		if IsValidStructKey(key) {
+			if val, ok := s.Value.(*pstruct).get(newStructKey(key)); ok {
+				return val, nil
+			}
+		}
+		return Null, nil
+	}
 	if s.Type != StructType {
 		return nil, Error(ArgumentErrorKey, "get expected a <struct> argument, got a ", obj.Type)
 	}
@@ -101,31 +161,39 @@ func Get(obj *Object, key *Object) (*Object, error) {
 func structGet(s *Object, key *Object) *Object {
 	switch key.Type {
 	case KeywordType, SymbolType, TypeType, StringType:
-		k := newStructKey(key)
-		result, ok := s.bindings[k]
-		if ok {
+		if result, ok := s.bindings.get(newStructKey(key)); ok {
 			return result
 		}
 	}
 	return Null
 }
 
+// Has - return true if the struct has a binding for the key, even to null
 func Has(obj *Object, key *Object) (bool, error) {
-	tmp, err := Get(obj, key)
-	if err != nil || tmp == Null {
-		return false, err
+	s := Value(obj)
+	switch s.Type {
+	case StructType:
+		if !IsValidStructKey(key) {
+			return false, nil
+		}
+		_, ok := s.bindings.get(newStructKey(key))
+		return ok, nil
+	case PersistentStructType:
+		if !IsValidStructKey(key) {
+			return false, nil
+		}
+		_, ok := s.Value.(*pstruct).get(newStructKey(key))
+		return ok, nil
 	}
-	return true, nil
+	return false, Error(ArgumentErrorKey, "has? expected a <struct> argument, got a ", obj.Type)
 }
 
 func Put(obj *Object, key *Object, val *Object) {
-	k := newStructKey(key)
-	obj.bindings[k] = val
+	obj.bindings.put(newStructKey(key), val)
 }
 
 func Unput(obj *Object, key *Object) {
-	k := newStructKey(key)
-	delete(obj.bindings, k)
+	obj.bindings.remove(newStructKey(key))
 }
 
 func sliceContains(slice []*Object, obj *Object) bool {
@@ -175,10 +243,10 @@ func validateKeywordArgBindings(args *Object, keys []*Object) ([]*Object, error)
 			}
 			bindings = slicePut(bindings, key, Car(args))
 		case StructType:
-			for k, v := range key.bindings {
-				sym := Intern(k.keyValue)
+			for _, e := range key.bindings.entries {
+				sym := Intern(e.key.keyValue)
 				if sliceContains(keys, sym) {
-					bindings = slicePut(bindings, sym, v)
+					bindings = slicePut(bindings, sym, e.value)
 				}
 			}
 		default:
@@ -189,18 +257,17 @@ func validateKeywordArgBindings(args *Object, keys []*Object) ([]*Object, error)
 	return bindings, nil
 }
 
-// Equal returns true if the object is equal to the argument
+// StructEqual returns true if the structs have the same keys, bound to equal values, in any order
 func StructEqual(s1 *Object, s2 *Object) bool {
 	bindings1 := s1.bindings
-	size := len(bindings1)
 	bindings2 := s2.bindings
-	if size == len(bindings2) {
-		for k, v := range bindings1 {
-			v2, ok := bindings2[k]
+	if bindings1.size() == bindings2.size() {
+		for _, e := range bindings1.entries {
+			v2, ok := bindings2.get(e.key)
 			if !ok {
 				return false
 			}
-			if !Equal(v, v2) {
+			if !Equal(e.value, v2) {
 				return false
 			}
 		}
@@ -212,16 +279,13 @@ func StructEqual(s1 *Object, s2 *Object) bool {
 func structToString(s *Object) string {
 	var buf bytes.Buffer
 	buf.WriteString("{")
-	first := true
-	for k, v := range s.bindings {
-		if first {
-			first = false
-		} else {
+	for i, e := range s.bindings.entries {
+		if i > 0 {
 			buf.WriteString(" ")
 		}
-		buf.WriteString(k.keyValue)
+		buf.WriteString(e.key.keyValue)
 		buf.WriteString(" ")
-		buf.WriteString(v.String())
+		buf.WriteString(e.value.String())
 	}
 	buf.WriteString("}")
 	return buf.String()
@@ -230,8 +294,8 @@ func structToString(s *Object) string {
 func structToList(s *Object) (*Object, error) {
 	result := EmptyList
 	tail := EmptyList
-	for k, v := range s.bindings {
-		tmp := List(k.toObject(), v)
+	for _, e := range s.bindings.entries {
+		tmp := List(e.key.toObject(), e.value)
 		if result == EmptyList {
 			result = List(tmp)
 			tail = result
@@ -244,14 +308,11 @@ func structToList(s *Object) (*Object, error) {
 }
 
 func structToVector(s *Object) *Object {
-	size := len(s.bindings)
-	el := make([]*Object, size)
-	j := 0
-	for k, v := range s.bindings {
-		el[j] = Vector(k.toObject(), v)
-		j++
+	el := make([]*Object, s.bindings.size())
+	for i, e := range s.bindings.entries {
+		el[i] = Vector(e.key.toObject(), e.value)
 	}
-	return VectorFromElements(el, size)
+	return VectorFromElementsNoCopy(el)
 }
 
 func StructKeys(s *Object) *Object {
@@ -265,8 +326,8 @@ func StructValues(s *Object) *Object {
 func structKeyList(s *Object) *Object {
 	result := EmptyList
 	tail := EmptyList
-	for k := range s.bindings {
-		key := k.toObject()
+	for _, e := range s.bindings.entries {
+		key := e.key.toObject()
 		if result == EmptyList {
 			result = List(key)
 			tail = result
@@ -281,12 +342,12 @@ func structKeyList(s *Object) *Object {
 func structValueList(s *Object) *Object {
 	result := EmptyList
 	tail := EmptyList
-	for _, v := range s.bindings {
+	for _, e := range s.bindings.entries {
 		if result == EmptyList {
-			result = List(v)
+			result = List(e.value)
 			tail = result
 		} else {
-			tail.cdr = List(v)
+			tail.cdr = List(e.value)
 			tail = tail.cdr
 		}
 	}
@@ -294,9 +355,7 @@ func structValueList(s *Object) *Object {
 }
 
 func listToStruct(lst *Object) (*Object, error) {
-	strct := new(Object)
-	strct.Type = StructType
-	strct.bindings = make(map[structKey]*Object)
+	strct := MakeStruct(0)
 	for lst != EmptyList {
 		k := lst.car
 		lst = lst.cdr
@@ -335,9 +394,7 @@ func listToStruct(lst *Object) (*Object, error) {
 
 func vectorToStruct(vec *Object) (*Object, error) {
 	count := len(vec.elements)
-	strct := new(Object)
-	strct.Type = StructType
-	strct.bindings = make(map[structKey]*Object, count)
+	strct := MakeStruct(count)
 	i := 0
 	for i < count {
 		k := vec.elements[i]
@@ -385,6 +442,8 @@ func ToStruct(obj *Object) (*Object, error) {
 		return listToStruct(val)
 	case VectorType:
 		return vectorToStruct(val)
+	case PersistentStructType:
+		return val.Value.(*pstruct).toStruct(), nil
 	}
 	return nil, Error(ArgumentErrorKey, "to-struct cannot accept argument of type ", obj.Type)
 }
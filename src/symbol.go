@@ -1,8 +1,23 @@
 package vile
 
-// Intern - internalize the name into the global symbol table
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Intern - internalize the name into the global symbol table. Lookups of existing symbols only take a read lock,
+// so interning from concurrently running spawns does not serialize them.
 func Intern(name string) *Object {
+	symtabLock.RLock()
 	sym, ok := symtab[name]
+	symtabLock.RUnlock()
+	if ok {
+		return sym
+	}
+	symtabLock.Lock()
+	defer symtabLock.Unlock()
+	sym, ok = symtab[name]
 	if !ok {
 		sym = new(Object)
 		sym.text = name
@@ -20,6 +35,23 @@ func Intern(name string) *Object {
 	return sym
 }
 
+// isInterned - return true if the symbol is the one Intern returns for its name
+func isInterned(sym *Object) bool {
+	symtabLock.RLock()
+	defer symtabLock.RUnlock()
+	return symtab[sym.text] == sym
+}
+
+// the number of the last symbol generated by Gensym
+var gensymCounter uint64
+
+// Gensym - return a new symbol named by the prefix and a unique number. The symbol is not interned, so neither
+// Intern nor the reader ever return it: it is distinct from every other symbol, even one with the same name.
+func Gensym(prefix string) *Object {
+	name := prefix + "__" + strconv.FormatUint(atomic.AddUint64(&gensymCounter, 1), 10)
+	return &Object{Type: SymbolType, text: name}
+}
+
 func IsValidSymbolName(name string) bool {
 	return len(name) > 0
 }
@@ -117,6 +149,7 @@ func ToSymbol(obj *Object) (*Object, error) {
 
 // the global symbol table. symbols for the basic types defined in this file are precached
 var symtab = initSymbolTable() /* symtab is a map cause the returned value from initSymbolTable is a map */
+var symtabLock sync.RWMutex
 
 func initSymbolTable() map[string]*Object {
 	syms := make(map[string]*Object, 0)
@@ -137,6 +170,8 @@ func initSymbolTable() map[string]*Object {
 
 // unknowns
 func Symbols() []*Object {
+	symtabLock.RLock()
+	defer symtabLock.RUnlock()
 	syms := make([]*Object, 0, len(symtab))
 	for _, sym := range symtab {
 		syms = append(syms, sym)
@@ -0,0 +1,439 @@
+package vile
+
+// Pattern macros, defined by rules instead of code:
+//
+//	(define-syntax swap!
+//	  (syntax-rules ()
+//	    ((_ a b) ((func (tmp) (set! a b) (set! b tmp)) a))))
+//
+// A use of the macro is matched against the pattern of each rule in turn, and replaced by the template of the
+// first one that matches. The first element of a pattern stands for the macro name and is ignored. In a pattern,
+// _ matches anything, a literal symbol only matches itself, any other symbol is a pattern variable, and an
+// element followed by ... matches any number of forms. In the template, a pattern variable followed by ... is
+// replaced by each of the forms it matched, and (... ...) stands for a literal ellipsis.
+//
+// The expansion is hygienic: the symbols the template binds, as func parameters, catch variables or globals
+// defined with var, are renamed so they cannot capture the symbols of the forms passed to the macro, nor replace
+// the globals of the program. The other symbols of the template, other than macros and special forms, refer to the
+// globals of their names, as where the macro is defined, even where the macro is used in the scope of a local of
+// the same name. A global is defined under the name given by the use of the macro only if that name is passed to it.
+
+var ellipsisSymbol = Intern("...")
+var underscoreSymbol = Intern("_")
+
+type syntaxRules struct {
+	literals  []*Object
+	patterns  []*Object
+	templates []*Object
+}
+
+// what a pattern variable matched: a form, or, for a variable under an ellipsis, the matches of each repetition
+type syntaxBinding struct {
+	value *Object
+	items []*syntaxBinding
+}
+
+// parseSyntaxRules - check and parse the (syntax-rules (literal...) (pattern template)...) form
+func parseSyntaxRules(rules *Object) (*syntaxRules, error) {
+	if ListLength(rules) < 2 || !IsList(Cadr(rules)) {
+		return nil, Error(SyntaxErrorKey, rules)
+	}
+	sr := new(syntaxRules)
+	for lits := Cadr(rules); lits != EmptyList; lits = Cdr(lits) {
+		lit := Car(lits)
+		if !IsSymbol(lit) || lit == ellipsisSymbol || lit == underscoreSymbol {
+			return nil, Error(SyntaxErrorKey, "Bad syntax-rules literal: ", lit)
+		}
+		sr.literals = append(sr.literals, lit)
+	}
+	for clauses := Cddr(rules); clauses != EmptyList; clauses = Cdr(clauses) {
+		clause := Car(clauses)
+		if !IsList(clause) || ListLength(clause) != 2 || !IsList(Car(clause)) || Car(clause) == EmptyList {
+			return nil, Error(SyntaxErrorKey, "Bad syntax-rules clause: ", clause)
+		}
+		sr.patterns = append(sr.patterns, Car(clause))
+		sr.templates = append(sr.templates, Cadr(clause))
+	}
+	return sr, nil
+}
+
+// syntaxRulesOf - return the parsed syntax-rules form. The rules are parsed once, when the macro is defined, and
+// the expander refers to the same form on each expansion. A form given to syntax-expand by other code is parsed on
+// its first use.
+func (rt *Runtime) syntaxRulesOf(rules *Object) (*syntaxRules, error) {
+	rt.syntaxLock.RLock()
+	sr, ok := rt.syntaxRules[rules]
+	rt.syntaxLock.RUnlock()
+	if ok {
+		return sr, nil
+	}
+	sr, err := parseSyntaxRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	rt.syntaxLock.Lock()
+	rt.syntaxRules[rules] = sr
+	rt.syntaxLock.Unlock()
+	return sr, nil
+}
+
+// expandSyntaxRules - the expander of the syntax-rules macro, a function of the form using the rules
+func (rt *Runtime) expandSyntaxRules(expr *Object) (*Object, error) {
+	if _, err := rt.syntaxRulesOf(expr); err != nil {
+		return nil, err
+	}
+	sym := Intern("expr")
+	return List(Intern("func"), List(sym), List(Intern("syntax-expand"), List(Intern("quote"), expr), sym)), nil
+}
+
+// expandDefineSyntax - (define-syntax name (syntax-rules ...)) is (macro name (syntax-rules ...))
+func expandDefineSyntax(expr *Object) (*Object, error) {
+	if ListLength(expr) != 3 || !IsSymbol(Cadr(expr)) {
+		return nil, Error(SyntaxErrorKey, expr)
+	}
+	return Cons(Intern("macro"), Cdr(expr)), nil
+}
+
+// SyntaxExpand - expand the form with the first of the syntax-rules whose pattern matches it
+func (rt *Runtime) SyntaxExpand(rules *Object, form *Object) (*Object, error) {
+	sr, err := rt.syntaxRulesOf(rules)
+	if err != nil {
+		return nil, err
+	}
+	if !IsList(form) || form == EmptyList {
+		return nil, Error(SyntaxErrorKey, form)
+	}
+	for i, pat := range sr.patterns {
+		bindings := make(map[*Object]*syntaxBinding)
+		if !sr.matchSequence(listElements(Cdr(pat)), listElements(Cdr(form)), bindings) {
+			continue
+		}
+		sx := &syntaxExpansion{rt: rt, rules: sr, aliases: make(map[*Object]*Object), originals: make(map[*Object]*Object), globals: make(map[*Object]*Object)}
+		expanded, err := sx.expand(sr.templates[i], bindings)
+		if err != nil {
+			return nil, err
+		}
+		// expand the macros used by the template first, so the symbols they bind are known
+		expanded, err = rt.macroexpandObject(expanded)
+		if err != nil {
+			return nil, err
+		}
+		bound := make(map[*Object]bool)
+		sx.findBound(expanded, bound)
+		return sx.restore(expanded, bound, false), nil
+	}
+	return nil, Error(MacroErrorKey, "No syntax rule matches ", form)
+}
+
+func listElements(lst *Object) []*Object {
+	var elements []*Object
+	for ; lst != EmptyList; lst = Cdr(lst) {
+		elements = append(elements, Car(lst))
+	}
+	return elements
+}
+
+func (sr *syntaxRules) match(pat *Object, form *Object, bindings map[*Object]*syntaxBinding) bool {
+	switch pat.Type {
+	case SymbolType:
+		if pat == underscoreSymbol {
+			return true
+		}
+		if sliceContains(sr.literals, pat) {
+			return form == pat
+		}
+		bindings[pat] = &syntaxBinding{value: form}
+		return true
+	case ListType:
+		return IsList(form) && sr.matchSequence(listElements(pat), listElements(form), bindings)
+	case VectorType:
+		return IsVector(form) && sr.matchSequence(pat.elements, form.elements, bindings)
+	}
+	return Equal(pat, form)
+}
+
+func (sr *syntaxRules) matchSequence(pats []*Object, forms []*Object, bindings map[*Object]*syntaxBinding) bool {
+	for i := 0; i < len(pats); i++ {
+		pat := pats[i]
+		if i+1 < len(pats) && pats[i+1] == ellipsisSymbol {
+			// leave enough forms for the patterns after the ellipsis
+			n := len(forms) - (len(pats) - i - 2)
+			if n < 0 {
+				return false
+			}
+			matches := make([]map[*Object]*syntaxBinding, n)
+			for j := range matches {
+				matches[j] = make(map[*Object]*syntaxBinding)
+				if !sr.match(pat, forms[j], matches[j]) {
+					return false
+				}
+			}
+			for _, v := range sr.patternVariables(pat, nil) {
+				items := make([]*syntaxBinding, n)
+				for j, m := range matches {
+					items[j] = m[v]
+				}
+				bindings[v] = &syntaxBinding{items: items}
+			}
+			return sr.matchSequence(pats[i+2:], forms[n:], bindings)
+		}
+		if len(forms) == 0 || !sr.match(pat, forms[0], bindings) {
+			return false
+		}
+		forms = forms[1:]
+	}
+	return len(forms) == 0
+}
+
+func (sr *syntaxRules) patternVariables(pat *Object, vars []*Object) []*Object {
+	switch pat.Type {
+	case SymbolType:
+		if pat != underscoreSymbol && pat != ellipsisSymbol && !sliceContains(sr.literals, pat) {
+			vars = append(vars, pat)
+		}
+	case ListType:
+		for ; pat != EmptyList; pat = Cdr(pat) {
+			vars = sr.patternVariables(Car(pat), vars)
+		}
+	case VectorType:
+		for _, p := range pat.elements {
+			vars = sr.patternVariables(p, vars)
+		}
+	}
+	return vars
+}
+
+// the state of one expansion: the symbols introduced by the template are replaced by aliases, which are turned
+// into references to the globals of the original symbols at the end unless they are bound by the expansion
+type syntaxExpansion struct {
+	rt        *Runtime
+	rules     *syntaxRules
+	aliases   map[*Object]*Object
+	originals map[*Object]*Object
+	globals   map[*Object]*Object
+}
+
+// globalReference - return a symbol with the name of sym, that refers to the global sym even in the scope of a
+// local named sym: it is not interned, so no local is ever bound to it, and globalName resolves it to sym.
+func (sx *syntaxExpansion) globalReference(sym *Object) *Object {
+	ref, ok := sx.globals[sym]
+	if !ok {
+		ref = globalReference(sym)
+		sx.globals[sym] = ref
+	}
+	return ref
+}
+
+// globalReference - return a new uninterned symbol that refers to the global sym, for expanders written in Go
+func globalReference(sym *Object) *Object {
+	return &Object{Type: SymbolType, text: sym.text, Value: sym}
+}
+
+// globalName - return the symbol of the global a symbol refers to: itself, unless it is a reference made by a
+// syntax-rules expansion
+func globalName(sym *Object) *Object {
+	if global, ok := sym.Value.(*Object); ok && sym.Type == SymbolType {
+		return global
+	}
+	return sym
+}
+
+func (sx *syntaxExpansion) alias(sym *Object) *Object {
+	if sym == Intern("&") || sym == Intern("catch") || sym == Intern("finally") || sliceContains(GetKeywords(), sym) || sx.rt.GetMacro(sym) != nil {
+		return sym
+	}
+	a, ok := sx.aliases[sym]
+	if !ok {
+		a = Gensym(sym.text)
+		sx.aliases[sym] = a
+		sx.originals[a] = sym
+	}
+	return a
+}
+
+func (sx *syntaxExpansion) expand(tmpl *Object, bindings map[*Object]*syntaxBinding) (*Object, error) {
+	switch tmpl.Type {
+	case SymbolType:
+		if b, ok := bindings[tmpl]; ok {
+			if b.value == nil {
+				return nil, Error(MacroErrorKey, "Pattern variable ", tmpl, " used without ", ellipsisSymbol)
+			}
+			return b.value, nil
+		}
+		return sx.alias(tmpl), nil
+	case ListType:
+		if Car(tmpl) == ellipsisSymbol && ListLength(tmpl) == 2 {
+			return Cadr(tmpl), nil
+		}
+		elements, err := sx.expandSequence(listElements(tmpl), bindings)
+		if err != nil {
+			return nil, err
+		}
+		return ListFromValues(elements), nil
+	case VectorType:
+		elements, err := sx.expandSequence(tmpl.elements, bindings)
+		if err != nil {
+			return nil, err
+		}
+		return VectorFromElementsNoCopy(elements), nil
+	case StructType:
+		strct := MakeStruct(tmpl.bindings.size())
+		for _, e := range tmpl.bindings.entries {
+			val, err := sx.expand(e.value, bindings)
+			if err != nil {
+				return nil, err
+			}
+			Put(strct, e.key.toObject(), val)
+		}
+		return strct, nil
+	}
+	return tmpl, nil
+}
+
+func (sx *syntaxExpansion) expandSequence(tmpls []*Object, bindings map[*Object]*syntaxBinding) ([]*Object, error) {
+	var result []*Object
+	for i := 0; i < len(tmpls); i++ {
+		tmpl := tmpls[i]
+		if i+1 == len(tmpls) || tmpls[i+1] != ellipsisSymbol {
+			val, err := sx.expand(tmpl, bindings)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, val)
+			continue
+		}
+		// the template is repeated once for each form matched by its pattern variables under an ellipsis
+		var vars []*Object
+		count := -1
+		for _, v := range sx.rules.patternVariables(tmpl, nil) {
+			if b, ok := bindings[v]; ok && b.value == nil {
+				if count >= 0 && len(b.items) != count {
+					return nil, Error(MacroErrorKey, "Pattern variables under ", ellipsisSymbol, " matched different numbers of forms: ", tmpl)
+				}
+				count = len(b.items)
+				vars = append(vars, v)
+			}
+		}
+		if vars == nil {
+			return nil, Error(MacroErrorKey, "No pattern variable to repeat before ", ellipsisSymbol, ": ", tmpl)
+		}
+		for j := 0; j < count; j++ {
+			repetition := make(map[*Object]*syntaxBinding, len(bindings))
+			for k, b := range bindings {
+				repetition[k] = b
+			}
+			for _, v := range vars {
+				repetition[v] = bindings[v].items[j]
+			}
+			val, err := sx.expand(tmpl, repetition)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, val)
+		}
+		i++
+	}
+	return result, nil
+}
+
+// findBound - find the aliases bound in the expanded form, as func parameters, catch variables or globals
+func (sx *syntaxExpansion) findBound(form *Object, bound map[*Object]bool) {
+	bind := func(sym *Object) {
+		if _, ok := sx.originals[sym]; ok {
+			bound[sym] = true
+		}
+	}
+	switch form.Type {
+	case ListType:
+		if form == EmptyList || Car(form) == Intern("quote") {
+			return
+		}
+		switch Car(form) {
+		case Intern("func"):
+			args := Cadr(form)
+			if IsSymbol(args) {
+				bind(args)
+			} else {
+				if IsVector(args) {
+					args, _ = ToList(args)
+				}
+				for ; IsList(args) && args != EmptyList; args = Cdr(args) {
+					switch a := Car(args); a.Type {
+					case SymbolType:
+						bind(a)
+					case VectorType:
+						for _, opt := range a.elements {
+							if IsList(opt) {
+								opt = Car(opt)
+							}
+							bind(opt)
+						}
+					}
+				}
+			}
+		case Intern("var"):
+			if rest := Cdr(form); rest != EmptyList && IsSymbol(Car(rest)) {
+				bind(Car(rest))
+			}
+		case Intern("catch"):
+			if rest := Cdr(form); rest != EmptyList {
+				if IsKeyword(Car(rest)) || IsVector(Car(rest)) {
+					rest = Cdr(rest)
+				}
+				if IsList(Car(rest)) && Car(rest) != EmptyList {
+					bind(Caar(rest))
+				}
+			}
+		}
+		for ; form != EmptyList; form = Cdr(form) {
+			sx.findBound(Car(form), bound)
+		}
+	case VectorType:
+		for _, o := range form.elements {
+			sx.findBound(o, bound)
+		}
+	case StructType:
+		for _, e := range form.bindings.entries {
+			sx.findBound(e.value, bound)
+		}
+	}
+}
+
+// restore - replace the aliases in quoted forms by their original symbols, and those that are not bound by
+// references to the globals of the original symbols
+func (sx *syntaxExpansion) restore(form *Object, bound map[*Object]bool, quoted bool) *Object {
+	switch form.Type {
+	case SymbolType:
+		if orig, ok := sx.originals[form]; ok {
+			if quoted {
+				return orig
+			}
+			if !bound[form] {
+				return sx.globalReference(orig)
+			}
+		}
+	case ListType:
+		if form == EmptyList {
+			return form
+		}
+		quoted = quoted || Car(form) == Intern("quote")
+		elements := listElements(form)
+		for i, o := range elements {
+			elements[i] = sx.restore(o, bound, quoted)
+		}
+		return inheritLocation(ListFromValues(elements), form)
+	case VectorType:
+		elements := make([]*Object, len(form.elements))
+		for i, o := range form.elements {
+			elements[i] = sx.restore(o, bound, quoted)
+		}
+		return VectorFromElementsNoCopy(elements)
+	case StructType:
+		strct := MakeStruct(form.bindings.size())
+		for _, e := range form.bindings.entries {
+			Put(strct, e.key.toObject(), sx.restore(e.value, bound, quoted))
+		}
+		return strct
+	}
+	return form
+}
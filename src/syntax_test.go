@@ -0,0 +1,29 @@
+package vile
+
+import (
+	"testing"
+)
+
+// TestSyntaxRulesParsedOnce - the rules of a syntax-rules macro are parsed when it is defined, not on each use
+func TestSyntaxRulesParsedOnce(t *testing.T) {
+	rt := newTestRuntime(t)
+	result, err := evalString(rt, `
+		(define-syntax swap!
+		  (syntax-rules ()
+		    ((_ a b) ((func (tmp) (set! a b) (set! b tmp)) a))))
+		(var x 1)
+		(var y 2)
+		(swap! x y)
+		(swap! x y)
+		(swap! x y)
+		(list x y)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(result, List(Number(2), Number(1))) {
+		t.Errorf("the swaps returned %v", result)
+	}
+	if len(rt.syntaxRules) != 1 {
+		t.Errorf("the rules were parsed %d times", len(rt.syntaxRules))
+	}
+}
@@ -83,6 +83,10 @@ func ToVector(obj *Object) (*Object, error) {
 		return structToVector(obj), nil
 	case StringType:
 		return stringToVector(obj), nil
+	case PersistentVectorType:
+		return VectorFromElementsNoCopy(obj.Value.(*pvector).elements()), nil
+	case PersistentStructType:
+		return structToVector(obj.Value.(*pstruct).toStruct()), nil
 	}
 	return nil, Error(ArgumentErrorKey, "to-vector expected <vector>, <list>, <struct>, or <string>, got a ", obj.Type)
 }